@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"rabbitmq-dlx-demo/rabbitmq"
+)
+
+const commandUsage = `Usage:
+  go run . export [-queue name] [-mode copy|destructive] [-limit n] [-o file]
+  go run . import [-queue name] [-i file] [-scan n]
+
+Without -o or -i the archive is written to stdout or read from stdin.
+The connection settings come from the same environment variables as the service.`
+
+// runCommand runs the export or import command in args against the configured
+// broker and returns the process exit code
+func runCommand(args []string) int {
+	var run func([]string, *rabbitmq.RabbitMQ) error
+	switch args[0] {
+	case "export":
+		run = exportCommand
+	case "import":
+		run = importCommand
+	default:
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return 2
+	}
+
+	// The commands run against the first main queue; -queue can still name any queue
+	cfg := configFromEnv()
+	queueNames := queueNamesFromEnv(cfg.QueueName)
+	cfg.QueueName = queueNames[0]
+	cfg.Limits = limitsFor(cfg.Limits, cfg.QueueName, queueNames)
+	rmq, err := rabbitmq.NewRabbitMQWithDLX(cfg)
+	if err != nil {
+		log.Printf("Failed to initialize RabbitMQ with DLX: %v", err)
+		return 1
+	}
+	defer rmq.Close()
+
+	if err := run(args[1:], rmq); err != nil {
+		log.Printf("%s failed: %v", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// exportCommand writes a queue to an NDJSON archive
+func exportCommand(args []string, rmq *rabbitmq.RabbitMQ) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	queue := flags.String("queue", "", "queue to export (default: the Dead Letter Queue)")
+	mode := flags.String("mode", string(rabbitmq.ExportCopy), "copy leaves the messages in the queue, destructive removes them")
+	limit := flags.Int("limit", 0, "maximum number of messages (0 exports all of them)")
+	output := flags.String("o", "", "archive file (default: stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create archive: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	_, err := rmq.Export(out, rabbitmq.ExportOptions{
+		Queue: *queue,
+		Mode:  rabbitmq.ExportMode(*mode),
+		Limit: *limit,
+	})
+	return err
+}
+
+// importCommand publishes the records of an NDJSON archive
+func importCommand(args []string, rmq *rabbitmq.RabbitMQ) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	queue := flags.String("queue", "", "target queue (default: the queue each record was exported from)")
+	input := flags.String("i", "", "archive file (default: stdin)")
+	scan := flags.Int("scan", 0, "ready messages of the target queue to read for duplicates; they are requeued (default: none)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return fmt.Errorf("failed to open archive: %w", err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	result, err := rmq.Import(in, rabbitmq.ImportOptions{Queue: *queue, Scan: *scan})
+	if err != nil {
+		return err
+	}
+	for _, failure := range result.Errors {
+		log.Printf("Line %d (%s): %s", failure.Line, failure.MessageID, failure.Error)
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("%d record(s) failed", result.Failed)
+	}
+	return nil
+}
@@ -2,23 +2,69 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"rabbitmq-dlx-demo/rabbitmq"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Handler struct {
-	RabbitMQ *rabbitmq.RabbitMQ
+	Broker rabbitmq.Broker
 }
 
 type PublishRequest struct {
 	Message string `json:"message"`
+
+	// Exchange and RoutingKey default to the main queue through the default exchange
+	Exchange   string `json:"exchange,omitempty"`
+	RoutingKey string `json:"routing_key,omitempty"`
+
+	// AMQP message properties; all optional
+	ContentType     string                 `json:"content_type,omitempty"`
+	ContentEncoding string                 `json:"content_encoding,omitempty"`
+	MessageID       string                 `json:"message_id,omitempty"`
+	CorrelationID   string                 `json:"correlation_id,omitempty"`
+	ReplyTo         string                 `json:"reply_to,omitempty"`
+	Priority        int                    `json:"priority,omitempty"`
+	Expiration      string                 `json:"expiration,omitempty"` // TTL in milliseconds
+	Timestamp       time.Time              `json:"timestamp,omitempty"`
+	Type            string                 `json:"type,omitempty"`
+	AppID           string                 `json:"app_id,omitempty"`
+	Headers         map[string]interface{} `json:"headers,omitempty"`
+}
+
+// message maps the request onto the message to publish
+func (req PublishRequest) message() rabbitmq.Message {
+	return rabbitmq.Message{
+		Body:            req.Message,
+		ContentType:     req.ContentType,
+		ContentEncoding: req.ContentEncoding,
+		MessageID:       req.MessageID,
+		CorrelationID:   req.CorrelationID,
+		ReplyTo:         req.ReplyTo,
+		Priority:        req.Priority,
+		Expiration:      req.Expiration,
+		Timestamp:       req.Timestamp,
+		Type:            req.Type,
+		AppID:           req.AppID,
+		Headers:         req.Headers,
+	}
 }
 
 type Response struct {
-	Status  string `json:"status"`
-	Message string `json:"message,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Data      any    `json:"data,omitempty"`
+
+	// Delivery carries the properties and delivery metadata of a consumed message
+	Delivery *rabbitmq.Delivery `json:"delivery,omitempty"`
 }
 
 // PublishHandler handles POST requests to publish messages
@@ -40,14 +86,113 @@ func (h *Handler) PublishHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Publish message to RabbitMQ
-	if err := h.RabbitMQ.PublishMessage(req.Message); err != nil {
+	messageID, err := h.Broker.PublishMessage(req.message(), rabbitmq.PublishOptions{
+		Exchange:   req.Exchange,
+		RoutingKey: req.RoutingKey,
+	})
+	if err != nil {
 		log.Printf("Error publishing message: %v", err)
-		respondWithError(w, "Failed to publish message", http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, rabbitmq.ErrUnroutable):
+			// The broker returned the mandatory message: no queue is bound for it
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(Response{
+				Status: "unroutable",
+				Error:  err.Error(),
+			})
+		case errors.Is(err, rabbitmq.ErrQueueFull):
+			// The queue is at its length limit with x-overflow reject-publish
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(Response{
+				Status: "queue_full",
+				Error:  err.Error(),
+			})
+		case errors.Is(err, rabbitmq.ErrInvalidMessage):
+			respondWithError(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, rabbitmq.ErrExchangeNotFound):
+			respondWithError(w, err.Error(), http.StatusNotFound)
+		default:
+			respondWithError(w, "Failed to publish message", errorStatus(err, http.StatusInternalServerError))
+		}
 		return
 	}
 
-	log.Printf("Published message: %s", req.Message)
-	respondWithSuccess(w, "Message published successfully")
+	log.Printf("Published message %s: %s", messageID, req.Message)
+	respondWithSuccess(w, "Message published successfully", messageID)
+}
+
+// PublishBatchRequest is the body of POST /publish/batch
+type PublishBatchRequest struct {
+	// Mode is best-effort (the default) or all-or-nothing
+	Mode     string           `json:"mode,omitempty"`
+	Messages []PublishRequest `json:"messages"`
+}
+
+// PublishBatchHandler handles POST requests to publish several messages at once.
+// It answers 200 when every message was acked, 207 with the status of each message
+// when some failed, and 409 when an all-or-nothing batch was rolled back.
+func (h *Handler) PublishBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PublishBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mode := rabbitmq.BatchMode(req.Mode)
+	if mode == "" {
+		mode = rabbitmq.BatchBestEffort
+	}
+
+	batch := make([]rabbitmq.BatchMessage, len(req.Messages))
+	for i, msg := range req.Messages {
+		if msg.Message == "" {
+			respondWithError(w, fmt.Sprintf("Message %d cannot be empty", i), http.StatusBadRequest)
+			return
+		}
+		batch[i] = rabbitmq.BatchMessage{
+			Message: msg.message(),
+			Options: rabbitmq.PublishOptions{Exchange: msg.Exchange, RoutingKey: msg.RoutingKey},
+		}
+	}
+
+	result, err := h.Broker.PublishBatch(batch, mode)
+	if err != nil {
+		log.Printf("Error publishing batch: %v", err)
+		if errors.Is(err, rabbitmq.ErrInvalidBatch) || errors.Is(err, rabbitmq.ErrInvalidMessage) {
+			respondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		respondWithError(w, "Failed to publish batch", errorStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	status, code := "success", http.StatusOK
+	message := fmt.Sprintf("%d message(s) published", result.Acked)
+	switch {
+	case result.Failed == 0:
+	case result.RolledBack:
+		status, code = "rolled_back", http.StatusConflict
+		message = "Transaction rolled back, no message was published"
+	default:
+		status, code = "partial", http.StatusMultiStatus
+		message = fmt.Sprintf("%d of %d message(s) published", result.Acked, len(result.Results))
+	}
+	log.Printf("Published batch (%s): %d acked, %d failed", mode, result.Acked, result.Failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(Response{
+		Status:  status,
+		Message: message,
+		Data:    result,
+	})
 }
 
 // ConsumeHandler handles GET requests to consume messages
@@ -58,15 +203,15 @@ func (h *Handler) ConsumeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Consume message from RabbitMQ
-	message, err := h.RabbitMQ.ConsumeMessage()
+	delivery, err := h.Broker.ConsumeMessage()
 	if err != nil {
 		log.Printf("Error consuming message: %v", err)
-		respondWithError(w, err.Error(), http.StatusNotFound)
+		respondWithError(w, err.Error(), errorStatus(err, http.StatusNotFound))
 		return
 	}
 
-	log.Printf("Consumed message: %s", message)
-	respondWithMessage(w, message)
+	log.Printf("Consumed message: %s", delivery.Body)
+	respondWithDelivery(w, delivery.Body, delivery)
 }
 
 // RejectMessageHandler handles POST requests to reject messages (simulate failure)
@@ -76,22 +221,78 @@ func (h *Handler) RejectMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Consume and reject a message (sends to DLX)
-	message, err := h.RabbitMQ.RejectMessage()
+	// Consume and reject a message (sends to DLX, or to the next retry tier)
+	result, err := h.Broker.RejectMessage()
 	if err != nil {
 		log.Printf("Error rejecting message: %v", err)
-		respondWithError(w, err.Error(), http.StatusNotFound)
+		status := http.StatusInternalServerError
+		if errors.Is(err, rabbitmq.ErrNoMessages) {
+			status = http.StatusNotFound
+		}
+		respondWithError(w, err.Error(), errorStatus(err, status))
 		return
 	}
 
-	log.Printf("Rejected message (sent to DLX): %s", message)
+	var message string
+	switch result.Action {
+	case rabbitmq.RejectRetry:
+		message = fmt.Sprintf("Message scheduled for retry %d/%d in %s (%s): %s",
+			result.Attempt, result.MaxRetries, result.Delay, result.Queue, result.Delivery.Body)
+	case rabbitmq.RejectParked:
+		message = fmt.Sprintf("Retries exhausted, message parked in %s: %s", result.Queue, result.Delivery.Body)
+	default:
+		message = "Message rejected and sent to DLX: " + result.Delivery.Body
+	}
+
+	log.Print(message)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(Response{
-		Status:  "success",
-		Message: "Message rejected and sent to DLX: " + message,
+		Status:   "success",
+		Message:  message,
+		Data:     result,
+		Delivery: result.Delivery,
 	})
 }
 
+// RetryStatusHandler handles GET requests to show the retry tiers and the parking lot
+func (h *Handler) RetryStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, err := h.Broker.RetryStatus()
+	if err != nil {
+		log.Printf("Error getting retry status: %v", err)
+		respondWithError(w, "Failed to get retry status: "+err.Error(), errorStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{
+		Status: "success",
+		Data:   status,
+	})
+}
+
+// ConsumeParkingLotHandler handles GET requests to consume a message whose retries ran out
+func (h *Handler) ConsumeParkingLotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	delivery, err := h.Broker.ConsumeFromParkingLot()
+	if err != nil {
+		log.Printf("Error consuming from parking lot: %v", err)
+		respondWithError(w, err.Error(), errorStatus(err, http.StatusNotFound))
+		return
+	}
+
+	log.Printf("Consumed from parking lot: %s", delivery.Body)
+	respondWithDelivery(w, "Message from parking lot: "+delivery.Body, delivery)
+}
+
 // ConsumeDLQHandler handles GET requests to consume from Dead Letter Queue
 func (h *Handler) ConsumeDLQHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -100,22 +301,234 @@ func (h *Handler) ConsumeDLQHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Consume message from DLQ
-	message, err := h.RabbitMQ.ConsumeFromDLQ()
+	delivery, err := h.Broker.ConsumeFromDLQ()
 	if err != nil {
 		log.Printf("Error consuming from DLQ: %v", err)
-		respondWithError(w, err.Error(), http.StatusNotFound)
+		respondWithError(w, err.Error(), errorStatus(err, http.StatusNotFound))
+		return
+	}
+
+	// The most recent x-death record says why the message ended up here
+	message := "Message from DLQ: " + delivery.Body
+	if len(delivery.Deaths) > 0 {
+		death := delivery.Deaths[0]
+		message = fmt.Sprintf("Message from DLQ (%s in %s, %d time(s)): %s", death.Reason, death.Queue, death.Count, delivery.Body)
+	}
+
+	log.Print(message)
+	respondWithDelivery(w, message, delivery)
+}
+
+// RedriveDLQHandler handles POST requests to move messages from the DLQ back to a queue
+func (h *Handler) RedriveDLQHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// An empty body redrives every message to its original queue
+	var opts rabbitmq.RedriveOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Consumed from DLQ: %s", message)
+	result, err := h.Broker.Redrive(opts)
+	if err != nil {
+		log.Printf("Error redriving DLQ: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, rabbitmq.ErrInvalidRedrive) {
+			status = http.StatusBadRequest
+		}
+		respondWithError(w, err.Error(), errorStatus(err, status))
+		return
+	}
+
+	verb := "moved"
+	if result.DryRun {
+		verb = "would move"
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(Response{
 		Status:  "success",
-		Message: "Message from DLQ: " + message,
+		Message: fmt.Sprintf("Redrive %s %d message(s), skipped %d, failed %d", verb, result.Moved, result.Skipped, result.Failed),
+		Data:    result,
+	})
+}
+
+// DLQStatsHandler handles GET requests to show the DLQ depth and per-reason counters
+func (h *Handler) DLQStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := h.Broker.DLQStats()
+	if err != nil {
+		log.Printf("Error getting DLQ stats: %v", err)
+		respondWithError(w, "Failed to get DLQ stats: "+err.Error(), errorStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{
+		Status: "success",
+		Data:   stats,
+	})
+}
+
+// BrowseHandler handles GET requests to page through the main queue without consuming it
+func (h *Handler) BrowseHandler(w http.ResponseWriter, r *http.Request) {
+	h.browse(w, r, h.Broker.BrowseMessages)
+}
+
+// DLQMessagesHandler handles GET /dlq/messages to page through the DLQ without consuming it,
+// and GET /dlq/messages/{id} to look up a DLQ message by message ID
+func (h *Handler) DLQMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/dlq/messages"), "/")
+	if id == "" {
+		h.browse(w, r, h.Broker.BrowseDLQ)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	delivery, err := h.Broker.FindDLQMessage(id)
+	if err != nil {
+		log.Printf("Error looking up DLQ message %s: %v", id, err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, rabbitmq.ErrMessageNotFound) {
+			status = http.StatusNotFound
+		}
+		respondWithError(w, err.Error(), errorStatus(err, status))
+		return
+	}
+
+	respondWithDelivery(w, delivery.Body, delivery)
+}
+
+// browse writes the page requested with the offset and limit query parameters
+func (h *Handler) browse(w http.ResponseWriter, r *http.Request, page func(offset, limit int) (*rabbitmq.BrowsePage, error)) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var offset, limit int
+	for name, value := range map[string]*int{"offset": &offset, "limit": &limit} {
+		raw := r.URL.Query().Get(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			respondWithError(w, "Invalid "+name+": "+raw, http.StatusBadRequest)
+			return
+		}
+		*value = n
+	}
+
+	result, err := page(offset, limit)
+	if err != nil {
+		log.Printf("Error browsing queue: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, rabbitmq.ErrInvalidPage) {
+			status = http.StatusBadRequest
+		}
+		respondWithError(w, err.Error(), errorStatus(err, status))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{
+		Status:  "success",
+		Message: fmt.Sprintf("%d of %d message(s) in %s", len(result.Messages), result.Total, result.Queue),
+		Data:    result,
+	})
+}
+
+// DeclareExchangeHandler handles POST requests to declare a direct, fanout, topic or headers exchange
+func (h *Handler) DeclareExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var spec rabbitmq.ExchangeSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Broker.DeclareExchange(spec); err != nil {
+		log.Printf("Error declaring exchange: %v", err)
+		respondWithError(w, err.Error(), topologyErrorStatus(err))
+		return
+	}
+
+	log.Printf("Declared %s exchange: %s", spec.Type, spec.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Response{
+		Status:  "success",
+		Message: "Exchange declared: " + spec.Name,
+	})
+}
+
+// BindHandler handles POST requests to bind a queue or exchange to an exchange
+func (h *Handler) BindHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var spec rabbitmq.BindingSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Broker.Bind(spec); err != nil {
+		log.Printf("Error binding: %v", err)
+		respondWithError(w, err.Error(), topologyErrorStatus(err))
+		return
+	}
+
+	log.Printf("Bound %s to exchange %s with key %q", spec.Destination, spec.Source, spec.RoutingKey)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Response{
+		Status:  "success",
+		Message: "Bound " + spec.Destination + " to exchange " + spec.Source,
 	})
 }
 
 // Helper functions
+
+// topologyErrorStatus maps declaration errors to 400 (invalid), 409 (conflicts with the broker) or 503
+func topologyErrorStatus(err error) int {
+	var conflict *rabbitmq.TopologyError
+	switch {
+	case errors.Is(err, rabbitmq.ErrInvalidTopology):
+		return http.StatusBadRequest
+	case errors.As(err, &conflict):
+		return http.StatusConflict
+	default:
+		return errorStatus(err, http.StatusInternalServerError)
+	}
+}
+
+// errorStatus maps broker errors to an HTTP status, using 503 while RabbitMQ is reconnecting
+func errorStatus(err error, fallback int) int {
+	if errors.Is(err, rabbitmq.ErrNotConnected) {
+		return http.StatusServiceUnavailable
+	}
+	return fallback
+}
+
 func respondWithError(w http.ResponseWriter, error string, code int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -125,18 +538,20 @@ func respondWithError(w http.ResponseWriter, error string, code int) {
 	})
 }
 
-func respondWithSuccess(w http.ResponseWriter, message string) {
+func respondWithSuccess(w http.ResponseWriter, message, messageID string) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(Response{
-		Status:  "success",
-		Message: message,
+		Status:    "success",
+		Message:   message,
+		MessageID: messageID,
 	})
 }
 
-func respondWithMessage(w http.ResponseWriter, message string) {
+func respondWithDelivery(w http.ResponseWriter, message string, delivery *rabbitmq.Delivery) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(Response{
-		Status:  "success",
-		Message: message,
+		Status:   "success",
+		Message:  message,
+		Delivery: delivery,
 	})
 }
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rabbitmq-dlx-demo/rabbitmq"
+	"testing"
+)
+
+// newTestRouter serves an in-memory broker for each queue under /queues/{queue}
+func newTestRouter(t *testing.T, queues ...string) (*QueueRouter, map[string]*rabbitmq.MemoryBroker) {
+	t.Helper()
+	router := NewQueueRouter()
+	brokers := make(map[string]*rabbitmq.MemoryBroker)
+	for _, queue := range queues {
+		broker := rabbitmq.NewMemoryBroker(queue)
+		brokers[queue] = broker
+		router.Add(queue, broker.DLQName, &Handler{Broker: broker})
+	}
+	return router, brokers
+}
+
+// request serves a request with a JSON body and decodes the JSON answer into a Response
+func request(t *testing.T, h http.Handler, method, target string, body interface{}) (int, Response) {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, &payload))
+
+	var resp Response
+	if rec.Header().Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decoding the %s %s response: %v", method, target, err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestPublishAndConsume(t *testing.T) {
+	router, brokers := newTestRouter(t, "orders", "payments")
+
+	code, resp := request(t, router, http.MethodPost, "/queues/orders/publish", PublishRequest{Message: "order 1", MessageID: "o-1"})
+	if code != http.StatusOK || resp.MessageID != "o-1" {
+		t.Fatalf("publish = %d %+v, want 200 with message ID o-1", code, resp)
+	}
+	if got := brokers["orders"].Messages(); len(got) != 1 || got[0] != "order 1" {
+		t.Fatalf("orders = %v, want [order 1]", got)
+	}
+	if got := brokers["payments"].Messages(); len(got) != 0 {
+		t.Errorf("payments = %v, want it untouched", got)
+	}
+
+	code, resp = request(t, router, http.MethodGet, "/queues/orders/consume", nil)
+	if code != http.StatusOK || resp.Delivery == nil || resp.Delivery.Body != "order 1" || resp.Delivery.MessageID != "o-1" {
+		t.Fatalf("consume = %d %+v, want order 1", code, resp)
+	}
+
+	code, _ = request(t, router, http.MethodGet, "/queues/orders/consume", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("consume on an empty queue = %d, want 404", code)
+	}
+}
+
+func TestUnknownQueue(t *testing.T) {
+	router, _ := newTestRouter(t, "orders")
+
+	code, resp := request(t, router, http.MethodPost, "/queues/missing/publish", PublishRequest{Message: "lost"})
+	if code != http.StatusNotFound || resp.Status != "error" {
+		t.Errorf("publish to an unknown queue = %d %+v, want 404", code, resp)
+	}
+
+	code, resp = request(t, router, http.MethodGet, "/queues", nil)
+	queues, _ := resp.Data.([]interface{})
+	if code != http.StatusOK || len(queues) != 1 {
+		t.Errorf("list = %d %+v, want the orders queue", code, resp)
+	}
+}
+
+func TestPublishErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, broker *rabbitmq.MemoryBroker)
+		req    PublishRequest
+		code   int
+		status string
+	}{
+		{
+			name:   "empty message",
+			req:    PublishRequest{},
+			code:   http.StatusBadRequest,
+			status: "error",
+		},
+		{
+			name:   "no queue for the routing key",
+			req:    PublishRequest{Message: "lost", RoutingKey: "missing"},
+			code:   http.StatusUnprocessableEntity,
+			status: "unroutable",
+		},
+		{
+			name:   "unknown exchange",
+			req:    PublishRequest{Message: "lost", Exchange: "missing"},
+			code:   http.StatusNotFound,
+			status: "error",
+		},
+		{
+			name: "queue full",
+			setup: func(t *testing.T, broker *rabbitmq.MemoryBroker) {
+				limits := rabbitmq.QueueLimits{MaxLength: 1, Overflow: rabbitmq.OverflowRejectPublish}
+				if err := broker.SetQueueLimits(broker.QueueName, limits); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := broker.PublishMessage(rabbitmq.Message{Body: "first"}, rabbitmq.PublishOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			req:    PublishRequest{Message: "second"},
+			code:   http.StatusTooManyRequests,
+			status: "queue_full",
+		},
+		{
+			name: "not connected",
+			setup: func(t *testing.T, broker *rabbitmq.MemoryBroker) {
+				broker.SetState(rabbitmq.StateReconnecting)
+			},
+			req:    PublishRequest{Message: "later"},
+			code:   http.StatusServiceUnavailable,
+			status: "error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, brokers := newTestRouter(t, "orders")
+			broker := brokers["orders"]
+			if tt.setup != nil {
+				tt.setup(t, broker)
+			}
+			before := len(broker.Messages())
+
+			code, resp := request(t, router, http.MethodPost, "/queues/orders/publish", tt.req)
+			if code != tt.code || resp.Status != tt.status {
+				t.Errorf("publish = %d %q, want %d %q", code, resp.Status, tt.code, tt.status)
+			}
+			if got := len(broker.Messages()); got != before {
+				t.Errorf("queue holds %d message(s) after a failed publish, want %d", got, before)
+			}
+		})
+	}
+}
+
+func TestPublishBatchAllOrNothing(t *testing.T) {
+	router, brokers := newTestRouter(t, "orders")
+	batch := PublishBatchRequest{Mode: string(rabbitmq.BatchAllOrNothing), Messages: []PublishRequest{
+		{Message: "one"},
+		{Message: "lost", RoutingKey: "missing"},
+	}}
+
+	code, resp := request(t, router, http.MethodPost, "/queues/orders/publish/batch", batch)
+	if code != http.StatusConflict || resp.Status != "rolled_back" {
+		t.Fatalf("batch = %d %+v, want 409 rolled back", code, resp)
+	}
+	if got := brokers["orders"].Messages(); len(got) != 0 {
+		t.Errorf("orders = %v after a rollback, want it empty", got)
+	}
+}
+
+func TestPublishBatchDuplicateMessageID(t *testing.T) {
+	router, brokers := newTestRouter(t, "orders")
+	batch := PublishBatchRequest{Messages: []PublishRequest{
+		{Message: "one", MessageID: "o-1"},
+		{Message: "two", MessageID: "o-1"},
+	}}
+
+	if code, _ := request(t, router, http.MethodPost, "/queues/orders/publish/batch", batch); code != http.StatusBadRequest {
+		t.Errorf("batch = %d, want 400", code)
+	}
+	if got := brokers["orders"].Messages(); len(got) != 0 {
+		t.Errorf("orders = %v after an invalid batch, want it empty", got)
+	}
+}
+
+func TestRejectAndConsumeFromDLQ(t *testing.T) {
+	router, brokers := newTestRouter(t, "orders")
+	broker := brokers["orders"]
+
+	if code, _ := request(t, router, http.MethodPost, "/queues/orders/publish", PublishRequest{Message: "bad order", MessageID: "o-1"}); code != http.StatusOK {
+		t.Fatalf("publish = %d, want 200", code)
+	}
+	code, resp := request(t, router, http.MethodPost, "/queues/orders/reject", nil)
+	if code != http.StatusOK || resp.Delivery == nil || resp.Delivery.Body != "bad order" {
+		t.Fatalf("reject = %d %+v, want bad order", code, resp)
+	}
+	if got := broker.DLQMessages(); len(got) != 1 {
+		t.Fatalf("DLQ = %v, want the rejected message", got)
+	}
+
+	code, resp = request(t, router, http.MethodGet, "/queues/orders/dlq/messages/o-1", nil)
+	if code != http.StatusOK || resp.Delivery == nil || resp.Delivery.MessageID != "o-1" {
+		t.Errorf("DLQ lookup = %d %+v, want o-1", code, resp)
+	}
+	if code, _ := request(t, router, http.MethodGet, "/queues/orders/dlq/messages/missing", nil); code != http.StatusNotFound {
+		t.Errorf("DLQ lookup of a missing message = %d, want 404", code)
+	}
+
+	code, resp = request(t, router, http.MethodGet, "/queues/orders/dlq/consume", nil)
+	if code != http.StatusOK || resp.Delivery == nil || len(resp.Delivery.Deaths) != 1 {
+		t.Fatalf("DLQ consume = %d %+v, want the message with its x-death record", code, resp)
+	}
+	death := resp.Delivery.Deaths[0]
+	if death.Reason != rabbitmq.DeathRejected || death.Queue != "orders" || death.Count != 1 {
+		t.Errorf("x-death = %+v, want rejected once from orders", death)
+	}
+
+	if code, _ := request(t, router, http.MethodGet, "/queues/orders/dlq/consume", nil); code != http.StatusNotFound {
+		t.Errorf("consume on an empty DLQ = %d, want 404", code)
+	}
+}
+
+func TestExportUnknownQueue(t *testing.T) {
+	router, _ := newTestRouter(t, "orders")
+	if code, _ := request(t, router, http.MethodGet, "/queues/orders/export?queue=missing", nil); code != http.StatusNotFound {
+		t.Errorf("export of an unknown queue = %d, want 404", code)
+	}
+}
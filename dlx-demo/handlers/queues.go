@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Routes returns the endpoints served by h, relative to the main queue of its broker
+func (h *Handler) Routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/publish", h.PublishHandler)
+	mux.HandleFunc("/publish/batch", h.PublishBatchHandler)
+	mux.HandleFunc("/consume", h.ConsumeHandler)
+	mux.HandleFunc("/browse", h.BrowseHandler)
+	mux.HandleFunc("/reject", h.RejectMessageHandler)
+	mux.HandleFunc("/dlq/consume", h.ConsumeDLQHandler)
+	mux.HandleFunc("/dlq/messages", h.DLQMessagesHandler)
+	mux.HandleFunc("/dlq/messages/", h.DLQMessagesHandler)
+	mux.HandleFunc("/dlq/stats", h.DLQStatsHandler)
+	mux.HandleFunc("/dlq/redrive", h.RedriveDLQHandler)
+	mux.HandleFunc("/export", h.ExportHandler)
+	mux.HandleFunc("/import", h.ImportHandler)
+	mux.HandleFunc("/retries", h.RetryStatusHandler)
+	mux.HandleFunc("/parking-lot/consume", h.ConsumeParkingLotHandler)
+	mux.HandleFunc("/exchanges", h.DeclareExchangeHandler)
+	mux.HandleFunc("/bindings", h.BindHandler)
+	return mux
+}
+
+// QueueInfo describes a main queue served by a QueueRouter
+type QueueInfo struct {
+	Queue string `json:"queue"`
+	DLQ   string `json:"dlq"`
+	Path  string `json:"path"`
+}
+
+// QueueRouter serves the endpoints of several main queues, each with its own
+// broker and DLQ, under /queues/{queue}/... (e.g. /queues/orders/dlq/consume).
+// GET /queues lists the queues.
+type QueueRouter struct {
+	queues []QueueInfo
+	routes map[string]http.Handler
+}
+
+// NewQueueRouter creates a router without queues
+func NewQueueRouter() *QueueRouter {
+	return &QueueRouter{routes: make(map[string]http.Handler)}
+}
+
+// Add serves the endpoints of h for queue, whose Dead Letter Queue is dlq
+func (q *QueueRouter) Add(queue, dlq string, h *Handler) {
+	if _, ok := q.routes[queue]; !ok {
+		q.queues = append(q.queues, QueueInfo{
+			Queue: queue,
+			DLQ:   dlq,
+			Path:  "/queues/" + url.PathEscape(queue),
+		})
+	}
+	q.routes[queue] = h.Routes()
+}
+
+func (q *QueueRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/queues")
+	if path == "" || path == "/" {
+		q.list(w, r)
+		return
+	}
+
+	// The queue name is the first segment; names with a slash must be escaped
+	escaped, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	queue, err := url.PathUnescape(escaped)
+	if err != nil {
+		respondWithError(w, "Invalid queue name", http.StatusBadRequest)
+		return
+	}
+	routes, ok := q.routes[queue]
+	if !ok {
+		respondWithError(w, "Unknown queue: "+queue, http.StatusNotFound)
+		return
+	}
+
+	// Hand the rest of the path to the queue's endpoints, as http.StripPrefix does
+	restPath, err := url.PathUnescape("/" + rest)
+	if err != nil {
+		respondWithError(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = restPath
+	r2.URL.RawPath = ""
+	routes.ServeHTTP(w, r2)
+}
+
+// list handles GET /queues
+func (q *QueueRouter) list(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{
+		Status:  "success",
+		Message: fmt.Sprintf("%d queue(s)", len(q.queues)),
+		Data:    q.queues,
+	})
+}
@@ -0,0 +1,551 @@
+package rabbitmq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// ErrInvalidArchive is returned for export options or archive records that cannot be used
+	ErrInvalidArchive = errors.New("invalid archive")
+
+	// ErrQueueNotFound is returned when exporting from or importing into a queue that does not exist
+	ErrQueueNotFound = errors.New("queue not found")
+)
+
+// ExportMode selects what happens to the exported messages
+type ExportMode string
+
+const (
+	ExportCopy        ExportMode = "copy"        // messages are returned to the queue in their order
+	ExportDestructive ExportMode = "destructive" // messages are removed once the whole archive is written
+)
+
+// ExportOptions selects the queue and the messages to export
+type ExportOptions struct {
+	Queue string     // defaults to the Dead Letter Queue
+	Mode  ExportMode // defaults to ExportCopy
+	Limit int        // zero exports every ready message
+}
+
+// withDefaults fills in the queue and mode and validates the options
+func (o ExportOptions) withDefaults(queue string) (ExportOptions, error) {
+	if o.Queue == "" {
+		o.Queue = queue
+	}
+	if o.Mode == "" {
+		o.Mode = ExportCopy
+	}
+	if o.Mode != ExportCopy && o.Mode != ExportDestructive {
+		return o, fmt.Errorf("%w: mode must be %q or %q, got %q", ErrInvalidArchive, ExportCopy, ExportDestructive, o.Mode)
+	}
+	if o.Limit < 0 {
+		return o, fmt.Errorf("%w: limit must not be negative", ErrInvalidArchive)
+	}
+	return o, nil
+}
+
+// ExportResult reports an export
+type ExportResult struct {
+	Queue    string     `json:"queue"`
+	Mode     ExportMode `json:"mode"`
+	Exported int        `json:"exported"`
+}
+
+// ImportOptions selects where archived messages are published
+type ImportOptions struct {
+	// Queue receives every record; when empty each record goes back to the queue it was exported from
+	Queue string
+
+	// Scan reads up to this many ready messages of each target queue and skips the
+	// records already there. Zero, the default, reads nothing from the queue and only
+	// skips duplicates within the archive. The scanned messages are requeued in their
+	// order, so the broker marks them redelivered.
+	Scan int
+}
+
+// ImportResult reports an import. Duplicates are records whose message ID was
+// earlier in the archive or, with ImportOptions.Scan, among the scanned messages
+// of the target queue.
+type ImportResult struct {
+	Imported   int           `json:"imported"`
+	Duplicates int           `json:"duplicates"`
+	Failed     int           `json:"failed"`
+	Errors     []ImportError `json:"errors,omitempty"`
+}
+
+// ImportError is a record that could not be imported
+type ImportError struct {
+	Line      int    `json:"line"`
+	MessageID string `json:"message_id,omitempty"`
+	Error     string `json:"error"`
+}
+
+// ArchiveRecord is one line of an NDJSON archive: a message with every property
+// and header, and the queue it was exported from
+type ArchiveRecord struct {
+	Queue       string `json:"queue"`
+	Exchange    string `json:"exchange,omitempty"`
+	RoutingKey  string `json:"routing_key,omitempty"`
+	Redelivered bool   `json:"redelivered,omitempty"`
+
+	// Body is the body as text, or base64 when BodyEncoding is "base64" (bodies that are not UTF-8)
+	Body         string `json:"body"`
+	BodyEncoding string `json:"body_encoding,omitempty"`
+
+	ContentType     string     `json:"content_type,omitempty"`
+	ContentEncoding string     `json:"content_encoding,omitempty"`
+	DeliveryMode    uint8      `json:"delivery_mode,omitempty"`
+	Priority        uint8      `json:"priority,omitempty"`
+	CorrelationID   string     `json:"correlation_id,omitempty"`
+	ReplyTo         string     `json:"reply_to,omitempty"`
+	Expiration      string     `json:"expiration,omitempty"`
+	MessageID       string     `json:"message_id,omitempty"`
+	Timestamp       *time.Time `json:"timestamp,omitempty"`
+	Type            string     `json:"type,omitempty"`
+	UserID          string     `json:"user_id,omitempty"`
+	AppID           string     `json:"app_id,omitempty"`
+
+	// Headers keeps AMQP types JSON cannot tell apart as {"$timestamp": ...},
+	// {"$float": ...}, {"$bytes": ...} and {"$decimal": ...}; plain numbers are integers
+	Headers map[string]interface{} `json:"headers,omitempty"`
+}
+
+// newArchiveRecord captures a message read from queue
+func newArchiveRecord(d amqp.Delivery, queue string) *ArchiveRecord {
+	rec := &ArchiveRecord{
+		Queue:           queue,
+		Exchange:        d.Exchange,
+		RoutingKey:      d.RoutingKey,
+		Redelivered:     d.Redelivered,
+		Body:            string(d.Body),
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationID:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		Expiration:      d.Expiration,
+		MessageID:       d.MessageId,
+		Type:            d.Type,
+		UserID:          d.UserId,
+		AppID:           d.AppId,
+	}
+	if !utf8.Valid(d.Body) {
+		rec.Body, rec.BodyEncoding = base64.StdEncoding.EncodeToString(d.Body), "base64"
+	}
+	if !d.Timestamp.IsZero() {
+		timestamp := d.Timestamp
+		rec.Timestamp = &timestamp
+	}
+	if len(d.Headers) > 0 {
+		rec.Headers = encodeArchiveValue(d.Headers).(map[string]interface{})
+	}
+	return rec
+}
+
+// publishing rebuilds the message of rec. UserId is left out: the broker rejects a
+// user-id that is not the one of the importing connection.
+func (rec *ArchiveRecord) publishing() (amqp.Publishing, error) {
+	body := []byte(rec.Body)
+	switch rec.BodyEncoding {
+	case "":
+	case "base64":
+		decoded, err := base64.StdEncoding.DecodeString(rec.Body)
+		if err != nil {
+			return amqp.Publishing{}, fmt.Errorf("%w: body is not valid base64: %v", ErrInvalidArchive, err)
+		}
+		body = decoded
+	default:
+		return amqp.Publishing{}, fmt.Errorf("%w: unknown body_encoding %q", ErrInvalidArchive, rec.BodyEncoding)
+	}
+
+	var headers amqp.Table
+	if len(rec.Headers) > 0 {
+		decoded, err := decodeArchiveValue(rec.Headers)
+		if err != nil {
+			return amqp.Publishing{}, fmt.Errorf("%w: headers: %v", ErrInvalidArchive, err)
+		}
+		headers = decoded.(amqp.Table)
+		// Quorum queues count deliveries per queue; the count starts over in the new one
+		delete(headers, "x-delivery-count")
+		if err := headers.Validate(); err != nil {
+			return amqp.Publishing{}, fmt.Errorf("%w: headers: %v", ErrInvalidArchive, err)
+		}
+	}
+
+	p := amqp.Publishing{
+		Headers:         headers,
+		ContentType:     rec.ContentType,
+		ContentEncoding: rec.ContentEncoding,
+		DeliveryMode:    rec.DeliveryMode,
+		Priority:        rec.Priority,
+		CorrelationId:   rec.CorrelationID,
+		ReplyTo:         rec.ReplyTo,
+		Expiration:      rec.Expiration,
+		MessageId:       rec.MessageID,
+		Type:            rec.Type,
+		AppId:           rec.AppID,
+		Body:            body,
+	}
+	if rec.Timestamp != nil {
+		p.Timestamp = *rec.Timestamp
+	}
+	return p, nil
+}
+
+// encodeArchiveValue turns an AMQP header value into JSON, tagging the types JSON would lose
+func encodeArchiveValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case amqp.Table:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = encodeArchiveValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = encodeArchiveValue(item)
+		}
+		return out
+	case time.Time:
+		return map[string]interface{}{"$timestamp": v.UTC().Format(time.RFC3339)}
+	case []byte:
+		return map[string]interface{}{"$bytes": base64.StdEncoding.EncodeToString(v)}
+	case float32:
+		return map[string]interface{}{"$float": v}
+	case float64:
+		return map[string]interface{}{"$float": v}
+	case amqp.Decimal:
+		return map[string]interface{}{"$decimal": map[string]interface{}{"scale": v.Scale, "value": v.Value}}
+	default:
+		return v
+	}
+}
+
+// decodeArchiveValue reverses encodeArchiveValue on JSON decoded with UseNumber
+func decodeArchiveValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			decoded, err := decodeArchiveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = decoded
+		}
+		return out, nil
+	case map[string]interface{}:
+		if len(v) == 1 {
+			for tag, value := range v {
+				if strings.HasPrefix(tag, "$") {
+					return decodeTaggedValue(tag, value)
+				}
+			}
+		}
+		table := make(amqp.Table, len(v))
+		for k, item := range v {
+			decoded, err := decodeArchiveValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			table[k] = decoded
+		}
+		return table, nil
+	default:
+		return v, nil
+	}
+}
+
+func decodeTaggedValue(tag string, value interface{}) (interface{}, error) {
+	switch tag {
+	case "$timestamp":
+		s, _ := value.(string)
+		return time.Parse(time.RFC3339, s)
+	case "$bytes":
+		s, _ := value.(string)
+		return base64.StdEncoding.DecodeString(s)
+	case "$float":
+		n, _ := value.(json.Number)
+		return n.Float64()
+	case "$decimal":
+		fields, _ := value.(map[string]interface{})
+		scale, _ := fields["scale"].(json.Number)
+		number, _ := fields["value"].(json.Number)
+		s, err := scale.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("$decimal scale: %w", err)
+		}
+		n, err := number.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("$decimal value: %w", err)
+		}
+		return amqp.Decimal{Scale: uint8(s), Value: int32(n)}, nil
+	default:
+		return nil, fmt.Errorf("unknown type tag %q", tag)
+	}
+}
+
+// importArchive reads NDJSON records from src and publishes each one whose message ID
+// it has not seen yet. existing checks that a queue exists and lists the message IDs
+// found by scanning up to scan of its ready messages.
+func importArchive(src io.Reader, opts ImportOptions, existing func(queue string, scan int) (map[string]bool, error), publish func(p amqp.Publishing, queue string) error) (*ImportResult, error) {
+	if opts.Scan < 0 {
+		return nil, fmt.Errorf("%w: scan must not be negative", ErrInvalidArchive)
+	}
+
+	seen := make(map[string]map[string]bool)
+	failures := make(map[string]error)
+	lookup := func(queue string) (map[string]bool, error) {
+		if ids, ok := seen[queue]; ok {
+			return ids, nil
+		}
+		if err, ok := failures[queue]; ok {
+			return nil, err
+		}
+		ids, err := existing(queue, opts.Scan)
+		if err != nil {
+			failures[queue] = err
+			return nil, err
+		}
+		seen[queue] = ids
+		return ids, nil
+	}
+
+	// A missing target queue fails the whole import instead of every record
+	if opts.Queue != "" {
+		if _, err := lookup(opts.Queue); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &ImportResult{}
+	fail := func(line int, messageID string, err error) {
+		result.Failed++
+		result.Errors = append(result.Errors, ImportError{Line: line, MessageID: messageID, Error: err.Error()})
+	}
+
+	reader := bufio.NewReader(src)
+	for line := 1; ; line++ {
+		raw, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return result, fmt.Errorf("failed to read archive: %w", readErr)
+		}
+		if len(bytes.TrimSpace(raw)) > 0 {
+			importRecord(raw, line, opts, lookup, publish, result, fail)
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	return result, nil
+}
+
+// importRecord imports one archive line into result
+func importRecord(raw []byte, line int, opts ImportOptions, lookup func(queue string) (map[string]bool, error), publish func(p amqp.Publishing, queue string) error, result *ImportResult, fail func(int, string, error)) {
+	var rec ArchiveRecord
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&rec); err != nil {
+		fail(line, "", fmt.Errorf("%w: %v", ErrInvalidArchive, err))
+		return
+	}
+
+	queue := opts.Queue
+	if queue == "" {
+		queue = rec.Queue
+	}
+	if queue == "" {
+		fail(line, rec.MessageID, fmt.Errorf("%w: record has no queue; name a target queue", ErrInvalidArchive))
+		return
+	}
+
+	ids, err := lookup(queue)
+	if err != nil {
+		fail(line, rec.MessageID, err)
+		return
+	}
+	// Records without a message ID cannot be deduplicated and are always published
+	if rec.MessageID != "" && ids[rec.MessageID] {
+		result.Duplicates++
+		return
+	}
+
+	p, err := rec.publishing()
+	if err != nil {
+		fail(line, rec.MessageID, err)
+		return
+	}
+	if err := publish(p, queue); err != nil {
+		fail(line, rec.MessageID, err)
+		return
+	}
+	if rec.MessageID != "" {
+		ids[rec.MessageID] = true
+	}
+	result.Imported++
+}
+
+// Export writes the ready messages of opts.Queue to w as NDJSON archive records.
+// In copy mode they are returned to the queue in their order; in destructive mode
+// they are removed, but only once every record has been written.
+func (r *RabbitMQ) Export(w io.Writer, opts ExportOptions) (*ExportResult, error) {
+	opts, err := opts.withDefaults(r.DLQName)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder := json.NewEncoder(w)
+	n, err := r.drain(opts.Queue, opts.Limit, opts.Mode == ExportDestructive, func(d amqp.Delivery) error {
+		if err := encoder.Encode(newArchiveRecord(d, opts.Queue)); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Exported %d message(s) from %s (%s)", n, opts.Queue, opts.Mode)
+	return &ExportResult{Queue: opts.Queue, Mode: opts.Mode, Exported: n}, nil
+}
+
+// Import publishes the records of an NDJSON archive with publisher confirms,
+// skipping those whose message ID was already imported or, with opts.Scan, found
+// among the first ready messages of the target queue
+func (r *RabbitMQ) Import(src io.Reader, opts ImportOptions) (*ImportResult, error) {
+	result, err := importArchive(src, opts, r.messageIDs, func(p amqp.Publishing, queue string) error {
+		return r.publish(p, "", queue)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Imported %d message(s), %d duplicate(s), %d failed", result.Imported, result.Duplicates, result.Failed)
+	return result, nil
+}
+
+// messageIDs checks that queue exists and lists the message IDs of up to scan of its
+// ready messages, which are requeued in their order
+func (r *RabbitMQ) messageIDs(queue string, scan int) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	if scan == 0 {
+		return ids, r.checkQueue(queue)
+	}
+	_, err := r.drain(queue, scan, false, func(d amqp.Delivery) error {
+		if d.MessageId != "" {
+			ids[d.MessageId] = true
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// checkQueue returns ErrQueueNotFound when queue does not exist
+func (r *RabbitMQ) checkQueue(queue string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	pool, ch, err := r.borrowConsume(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.put(ch)
+
+	_, err = inspectQueue(ch, queue)
+	return err
+}
+
+// drain gets the ready messages of queue, up to limit (zero for all of them), without
+// ack on one channel so each Get returns the next one, and passes them to visit in order.
+// They are then settled in a single call: acked when remove is set and every visit
+// succeeded, otherwise nacked with requeue so they go back to their positions.
+func (r *RabbitMQ) drain(queue string, limit int, remove bool, visit func(d amqp.Delivery) error) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	pool, ch, err := r.borrowConsume(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer pool.put(ch)
+
+	q, err := inspectQueue(ch, queue)
+	if err != nil {
+		return 0, err
+	}
+
+	count := q.Messages
+	if limit > 0 && limit < count {
+		count = limit
+	}
+
+	var last uint64
+	var visitErr error
+	n := 0
+	for n < count {
+		msg, ok, err := ch.Get(
+			queue, // queue
+			false, // auto-ack
+		)
+		if err != nil {
+			visitErr = fmt.Errorf("failed to read from %s: %w", queue, err)
+			break
+		}
+		if !ok {
+			break
+		}
+		last = msg.DeliveryTag
+		if visitErr = visit(msg); visitErr != nil {
+			break
+		}
+		n++
+	}
+
+	if last != 0 {
+		if remove && visitErr == nil {
+			if err := ch.Ack(last, true); err != nil {
+				return 0, fmt.Errorf("failed to remove messages from %s: %w", queue, err)
+			}
+		} else {
+			ch.Nack(
+				last, // delivery tag
+				true, // multiple: every message held so far
+				true, // requeue
+			)
+		}
+	}
+	return n, visitErr
+}
+
+// inspectQueue passively declares queue, so a queue that does not exist is ErrQueueNotFound
+func inspectQueue(ch *amqp.Channel, queue string) (amqp.Queue, error) {
+	q, err := ch.QueueDeclarePassive(queue, true, false, false, false, nil)
+	if err != nil {
+		var amqpErr *amqp.Error
+		if errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound {
+			return amqp.Queue{}, fmt.Errorf("%w: %s", ErrQueueNotFound, queue)
+		}
+		return amqp.Queue{}, fmt.Errorf("failed to inspect queue %s: %w", queue, err)
+	}
+	return q, nil
+}
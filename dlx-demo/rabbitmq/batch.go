@@ -0,0 +1,353 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MaxBatchSize is the largest number of messages PublishBatch accepts at once
+const MaxBatchSize = 1000
+
+// batchTimeout bounds a whole batch, from the first publish to the last confirm or the commit
+const batchTimeout = 30 * time.Second
+
+// ErrInvalidBatch is returned for an empty or oversized batch, an unknown mode
+// or a message ID used by two messages of the batch
+var ErrInvalidBatch = errors.New("invalid batch")
+
+// BatchMode decides whether the messages of a batch succeed or fail together
+type BatchMode string
+
+const (
+	// BatchBestEffort pipelines the messages with publisher confirms; each one succeeds or fails on its own
+	BatchBestEffort BatchMode = "best-effort"
+	// BatchAllOrNothing publishes the messages in an AMQP transaction: all of them are committed or none
+	BatchAllOrNothing BatchMode = "all-or-nothing"
+)
+
+// BatchStatus is the outcome of one message of a batch
+type BatchStatus string
+
+const (
+	BatchAcked      BatchStatus = "acked"       // confirmed by the broker, or committed
+	BatchNacked     BatchStatus = "nacked"      // refused by the broker or not published
+	BatchReturned   BatchStatus = "returned"    // mandatory message that matched no queue
+	BatchTimedOut   BatchStatus = "timed_out"   // no confirm before the batch timed out
+	BatchRolledBack BatchStatus = "rolled_back" // discarded with the rest of its transaction
+)
+
+// BatchMessage is one message of a batch and where to publish it
+type BatchMessage struct {
+	Message
+	Options PublishOptions
+}
+
+// BatchMessageResult is the outcome of one message, by its position in the batch
+type BatchMessageResult struct {
+	Index     int         `json:"index"`
+	MessageID string      `json:"message_id,omitempty"`
+	Status    BatchStatus `json:"status"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// BatchResult is the outcome of PublishBatch, with one result per message in batch order
+type BatchResult struct {
+	Mode    BatchMode            `json:"mode"`
+	Acked   int                  `json:"acked"`
+	Failed  int                  `json:"failed"`
+	Results []BatchMessageResult `json:"results"`
+
+	// RolledBack is set when an all-or-nothing batch published nothing
+	RolledBack bool `json:"rolled_back,omitempty"`
+}
+
+// prepareBatch checks the batch and builds every publishing before anything is sent,
+// so an invalid message fails the whole request. A return only carries the message ID
+// of its message, so the IDs of a batch must be unique to tell which one was returned.
+func prepareBatch(batch []BatchMessage, mode BatchMode) ([]amqp.Publishing, error) {
+	switch mode {
+	case BatchBestEffort, BatchAllOrNothing:
+	default:
+		return nil, fmt.Errorf("%w: unknown mode %q (want best-effort or all-or-nothing)", ErrInvalidBatch, mode)
+	}
+	if len(batch) == 0 {
+		return nil, fmt.Errorf("%w: no messages", ErrInvalidBatch)
+	}
+	if len(batch) > MaxBatchSize {
+		return nil, fmt.Errorf("%w: %d messages exceed the limit of %d", ErrInvalidBatch, len(batch), MaxBatchSize)
+	}
+
+	publishings := make([]amqp.Publishing, len(batch))
+	seen := make(map[string]int, len(batch))
+	for i, msg := range batch {
+		publishing, err := msg.publishing()
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		if j, ok := seen[publishing.MessageId]; ok {
+			return nil, fmt.Errorf("%w: messages %d and %d have the same message ID %q", ErrInvalidBatch, j, i, publishing.MessageId)
+		}
+		seen[publishing.MessageId] = i
+		publishings[i] = publishing
+	}
+	return publishings, nil
+}
+
+func newBatchResult(mode BatchMode, publishings []amqp.Publishing) *BatchResult {
+	result := &BatchResult{Mode: mode, Results: make([]BatchMessageResult, len(publishings))}
+	for i, p := range publishings {
+		result.Results[i] = BatchMessageResult{Index: i, MessageID: p.MessageId}
+	}
+	return result
+}
+
+// set records the outcome of message i
+func (r *BatchResult) set(i int, status BatchStatus, err error) {
+	r.Results[i].Status = status
+	r.Results[i].Error = ""
+	if err != nil {
+		r.Results[i].Error = err.Error()
+	}
+}
+
+// rollBack marks the batch as rolled back and every message but failed (-1 for none) with it
+func (r *BatchResult) rollBack(failed int, err error) {
+	r.RolledBack = true
+	for i := range r.Results {
+		if i != failed {
+			r.set(i, BatchRolledBack, err)
+		}
+	}
+}
+
+// count tallies the acked and failed messages
+func (r *BatchResult) count() {
+	r.Acked, r.Failed = 0, 0
+	for _, res := range r.Results {
+		if res.Status == BatchAcked {
+			r.Acked++
+		} else {
+			r.Failed++
+		}
+	}
+}
+
+// batchFailure maps a publish error onto the status of its message
+func batchFailure(err error) BatchStatus {
+	if errors.Is(err, ErrUnroutable) {
+		return BatchReturned
+	}
+	return BatchNacked
+}
+
+// PublishBatch publishes messages as mandatory and reports the outcome of each one.
+// Best-effort pipelines them on one confirm-mode channel; all-or-nothing publishes
+// them in a transaction on a dedicated channel and commits only if all were sent.
+func (r *RabbitMQ) PublishBatch(batch []BatchMessage, mode BatchMode) (*BatchResult, error) {
+	publishings, err := prepareBatch(batch, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), batchTimeout)
+	defer cancel()
+
+	result := newBatchResult(mode, publishings)
+	if mode == BatchAllOrNothing {
+		err = r.publishBatchTx(ctx, batch, publishings, result)
+	} else {
+		err = r.publishBatchConfirmed(ctx, batch, publishings, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.count()
+	return result, nil
+}
+
+// publishBatchConfirmed sends every message before waiting for the confirms.
+// Returns are collected meanwhile and matched to messages by their unique message ID.
+func (r *RabbitMQ) publishBatchConfirmed(ctx context.Context, batch []BatchMessage, publishings []amqp.Publishing, result *BatchResult) error {
+	pool, ch, err := r.borrowPublish(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.put(ch)
+
+	listener := r.listeners.get(ch)
+	if listener == nil {
+		// The connection was released while we held the channel
+		return fmt.Errorf("%w: publish channel was closed", ErrNotConnected)
+	}
+
+	// Returns must be read while publishing: an unread one blocks the whole connection
+	returned := make(map[string]int)
+	stop, collected := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(collected)
+		for {
+			select {
+			case ret := <-listener.returns:
+				returned[ret.MessageId]++
+			case <-stop:
+				// A return is handed over before its confirm, so none can be left behind
+				for {
+					select {
+					case ret := <-listener.returns:
+						returned[ret.MessageId]++
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	confirms := make([]*amqp.DeferredConfirmation, len(publishings))
+	var sendErr error
+	for i, publishing := range publishings {
+		if sendErr != nil {
+			result.set(i, BatchNacked, sendErr)
+			continue
+		}
+		exchange, routingKey := batch[i].Options.target(r.QueueName)
+		confirms[i], err = ch.PublishWithDeferredConfirmWithContext(
+			ctx,
+			exchange,   // exchange
+			routingKey, // routing key
+			true,       // mandatory: return the message if it matches no queue
+			false,      // immediate
+			publishing,
+		)
+		if err != nil {
+			// The channel is unusable after a failed publish, so the rest is not sent
+			sendErr = fmt.Errorf("failed to publish message: %w", err)
+			result.set(i, BatchNacked, sendErr)
+		}
+	}
+
+	timedOut := false
+	for i, confirm := range confirms {
+		if confirm == nil {
+			continue
+		}
+		acked, err := confirm.WaitContext(ctx)
+		switch {
+		case err != nil:
+			timedOut = true
+			result.set(i, BatchTimedOut, fmt.Errorf("no publisher confirm within %s", batchTimeout))
+		case acked:
+			result.set(i, BatchAcked, nil)
+		default:
+			exchange, routingKey := batch[i].Options.target(r.QueueName)
+			result.set(i, BatchNacked, r.nackError(ch, listener, exchange, routingKey, publishings[i].Headers))
+		}
+	}
+
+	close(stop)
+	<-collected
+	for i := range result.Results {
+		res := &result.Results[i]
+		if res.Status == BatchAcked && returned[res.MessageID] > 0 {
+			returned[res.MessageID]--
+			exchange, routingKey := batch[i].Options.target(r.QueueName)
+			result.set(i, BatchReturned, fmt.Errorf("%w: exchange %q, routing key %q", ErrUnroutable, exchange, routingKey))
+		}
+	}
+
+	if timedOut {
+		// A late return or confirm would be mistaken for the next publish's, so drop the channel
+		ch.Close()
+		r.listeners.forget(ch)
+	}
+	return nil
+}
+
+// publishBatchTx publishes the messages in a transaction on a channel of its own,
+// since a channel in confirm mode cannot use transactions. Routing is checked
+// against the declared topology first: a committed message that is returned
+// cannot be taken back, so an unroutable one rolls the batch back up front.
+func (r *RabbitMQ) publishBatchTx(ctx context.Context, batch []BatchMessage, publishings []amqp.Publishing, result *BatchResult) error {
+	r.mu.RLock()
+	conn, state, topology := r.publishConn, r.state, r.topology
+	r.mu.RUnlock()
+	if state != StateConnected {
+		return fmt.Errorf("%w (state: %s)", ErrNotConnected, state)
+	}
+
+	for i, publishing := range publishings {
+		exchange, routingKey := batch[i].Options.target(r.QueueName)
+		// Exchanges the service did not declare are left to the broker
+		if queues, err := topology.route(exchange, routingKey, publishing.Headers); err == nil && len(queues) == 0 {
+			result.set(i, BatchReturned, fmt.Errorf("%w: exchange %q, routing key %q", ErrUnroutable, exchange, routingKey))
+			result.rollBack(i, fmt.Errorf("transaction rolled back: message %d cannot be routed", i))
+			return nil
+		}
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open a transaction channel: %w", err)
+	}
+	defer ch.Close()
+
+	if err := ch.Tx(); err != nil {
+		return fmt.Errorf("failed to start a transaction: %w", err)
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, len(publishings)))
+
+	for i, publishing := range publishings {
+		exchange, routingKey := batch[i].Options.target(r.QueueName)
+		err := ch.PublishWithContext(
+			ctx,
+			exchange,   // exchange
+			routingKey, // routing key
+			true,       // mandatory: return the message if it matches no queue
+			false,      // immediate
+			publishing,
+		)
+		if err != nil {
+			ch.TxRollback()
+			result.set(i, BatchNacked, fmt.Errorf("failed to publish message: %w", err))
+			result.rollBack(i, fmt.Errorf("transaction rolled back: message %d failed", i))
+			return nil
+		}
+	}
+
+	if err := ch.TxCommit(); err != nil {
+		var amqpErr *amqp.Error
+		if errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound {
+			err = fmt.Errorf("%w: %s", ErrExchangeNotFound, amqpErr.Reason)
+		}
+		result.rollBack(-1, fmt.Errorf("transaction commit failed: %w", err))
+		return nil
+	}
+
+	for i := range result.Results {
+		result.set(i, BatchAcked, nil)
+	}
+
+	// The broker sends the returns of a transaction before commit-ok, so they are buffered.
+	// The messages were committed, but the ones returned reached no queue.
+	returned := make(map[string]int)
+	for done := false; !done; {
+		select {
+		case ret := <-returns:
+			returned[ret.MessageId]++
+		default:
+			done = true
+		}
+	}
+	for i := range result.Results {
+		if id := result.Results[i].MessageID; returned[id] > 0 {
+			returned[id]--
+			exchange, routingKey := batch[i].Options.target(r.QueueName)
+			result.set(i, BatchReturned, fmt.Errorf("%w: exchange %q, routing key %q", ErrUnroutable, exchange, routingKey))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package rabbitmq
+
+import "io"
+
+// Broker is the set of queue operations the HTTP handlers depend on.
+// *RabbitMQ implements it against a real broker and *MemoryBroker in process,
+// so handlers can be exercised with httptest and no running RabbitMQ.
+type Broker interface {
+	// PublishMessage publishes a message to the main queue, or to the exchange and
+	// routing key in opts, and returns its message ID.
+	// It returns ErrUnroutable when no queue receives the message.
+	PublishMessage(msg Message, opts PublishOptions) (string, error)
+
+	// PublishBatch publishes several messages and reports the outcome of each one,
+	// best-effort or all-or-nothing
+	PublishBatch(batch []BatchMessage, mode BatchMode) (*BatchResult, error)
+
+	// DeclareExchange declares an exchange of any type
+	DeclareExchange(spec ExchangeSpec) error
+
+	// Bind binds a queue or exchange to a source exchange
+	Bind(spec BindingSpec) error
+
+	// ConsumeMessage gets a message from the main queue with auto-ack
+	ConsumeMessage() (*Delivery, error)
+
+	// ConsumeMessageManual gets a message that must be settled with AckMessage or NackMessage
+	ConsumeMessageManual() (*MessageWithTag, error)
+	AckMessage(msg *MessageWithTag) error
+	NackMessage(msg *MessageWithTag, requeue bool) error
+
+	// RejectMessage gets a message and dead-letters it to the DLQ or, when
+	// retries are enabled, moves it to its next retry tier or the parking lot
+	RejectMessage() (*RejectResult, error)
+
+	// ConsumeFromDLQ gets a message from the Dead Letter Queue with auto-ack;
+	// its parsed x-death records are in Delivery.Deaths
+	ConsumeFromDLQ() (*Delivery, error)
+
+	// BrowseMessages and BrowseDLQ return a page of a queue without consuming it
+	BrowseMessages(offset, limit int) (*BrowsePage, error)
+	BrowseDLQ(offset, limit int) (*BrowsePage, error)
+
+	// FindDLQMessage returns the DLQ message with the given message ID, leaving it in the DLQ
+	FindDLQMessage(messageID string) (*Delivery, error)
+
+	// Redrive moves matching DLQ messages back to their original queue or to a named one
+	Redrive(opts RedriveOptions) (*RedriveResult, error)
+
+	// DLQStats reports the DLQ depth and per-reason counts of the messages consumed from it
+	DLQStats() (*DLQStats, error)
+
+	// RetryStatus reports the messages waiting in each retry tier and in the parking lot
+	RetryStatus() (*RetryStatus, error)
+
+	// ConsumeFromParkingLot gets a message whose retries ran out, with auto-ack.
+	// It returns ErrRetriesDisabled when no retry delays are configured.
+	ConsumeFromParkingLot() (*Delivery, error)
+
+	// Export writes the ready messages of a queue (the DLQ by default) to w as
+	// NDJSON archive records, copying them or, in destructive mode, removing them
+	Export(w io.Writer, opts ExportOptions) (*ExportResult, error)
+
+	// Import publishes NDJSON archive records with confirms, skipping message IDs already queued
+	Import(r io.Reader, opts ImportOptions) (*ImportResult, error)
+
+	// State describes the connection to the broker
+	State() State
+}
+
+var (
+	_ Broker = (*RabbitMQ)(nil)
+	_ Broker = (*MemoryBroker)(nil)
+)
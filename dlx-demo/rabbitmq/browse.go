@@ -0,0 +1,162 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Page sizes accepted by the browse endpoints
+const (
+	DefaultBrowseLimit = 20
+	MaxBrowseLimit     = 100
+)
+
+var (
+	// ErrInvalidPage is returned when offset or limit are out of range
+	ErrInvalidPage = errors.New("invalid page")
+
+	// ErrMessageNotFound is returned when no message in the queue has the requested ID
+	ErrMessageNotFound = errors.New("message not found")
+)
+
+// BrowsePage is a page of the messages ready in a queue, left in place
+type BrowsePage struct {
+	Queue    string     `json:"queue"`
+	Offset   int        `json:"offset"`
+	Limit    int        `json:"limit"`
+	Total    int        `json:"total"` // ready messages when the page was read
+	Messages []Delivery `json:"messages"`
+}
+
+// visitFunc is called for each message of a scan, in queue order, and returns false to stop it
+type visitFunc func(d *Delivery) bool
+
+// browsePage reads the page [offset, offset+limit) through scan; a zero limit means DefaultBrowseLimit
+func browsePage(queue string, offset, limit int, scan func(queue string, visit visitFunc) (int, error)) (*BrowsePage, error) {
+	if limit == 0 {
+		limit = DefaultBrowseLimit
+	}
+	if offset < 0 || limit < 0 || limit > MaxBrowseLimit {
+		return nil, fmt.Errorf("%w: offset must be >= 0 and limit between 1 and %d", ErrInvalidPage, MaxBrowseLimit)
+	}
+
+	page := &BrowsePage{Queue: queue, Offset: offset, Limit: limit, Messages: []Delivery{}}
+	position := 0
+	total, err := scan(queue, func(d *Delivery) bool {
+		if position >= offset {
+			page.Messages = append(page.Messages, *d)
+		}
+		position++
+		return position < offset+limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	page.Total = total
+	return page, nil
+}
+
+// findMessage looks through scan for the message with the given ID
+func findMessage(queue, messageID string, scan func(queue string, visit visitFunc) (int, error)) (*Delivery, error) {
+	var found *Delivery
+	_, err := scan(queue, func(d *Delivery) bool {
+		if d.MessageID == messageID {
+			found = d
+		}
+		return found == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s in %s", ErrMessageNotFound, messageID, queue)
+	}
+	return found, nil
+}
+
+// BrowseMessages returns a page of the main queue without consuming it
+func (r *RabbitMQ) BrowseMessages(offset, limit int) (*BrowsePage, error) {
+	return browsePage(r.QueueName, offset, limit, r.scan)
+}
+
+// BrowseDLQ returns a page of the Dead Letter Queue without consuming it
+func (r *RabbitMQ) BrowseDLQ(offset, limit int) (*BrowsePage, error) {
+	return browsePage(r.DLQName, offset, limit, r.scan)
+}
+
+// FindDLQMessage returns the DLQ message with the given message ID, leaving it in the DLQ
+func (r *RabbitMQ) FindDLQMessage(messageID string) (*Delivery, error) {
+	return findMessage(r.DLQName, messageID, r.scan)
+}
+
+// scan reads the ready messages of queue the way ConsumeMessageManual does, without
+// ack, and holds them on one channel so each Get returns the next one. When visit
+// stops or the messages present at the start run out, they are all nacked with
+// requeue in a single call and go back to their positions. The content is not
+// changed, but the broker marks them redelivered; messages held by other
+// consumers at the time are not seen.
+func (r *RabbitMQ) scan(queue string, visit visitFunc) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	pool, ch, err := r.borrowConsume(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer pool.put(ch)
+
+	q, err := ch.QueueDeclarePassive(queue, true, false, false, false, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect queue %s: %w", queue, err)
+	}
+
+	var last uint64
+	defer func() {
+		if last == 0 {
+			return
+		}
+		ch.Nack(
+			last, // delivery tag
+			true, // multiple: every message held so far
+			true, // requeue
+		)
+	}()
+
+	for i := 0; i < q.Messages; i++ {
+		msg, ok, err := getManual(ch, pool, queue)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			break
+		}
+		last = msg.DeliveryTag
+		if !visit(&msg.Delivery) {
+			break
+		}
+	}
+	return q.Messages, nil
+}
+
+// getManual gets a message from queue on ch without auto-ack; it must be
+// settled on the same channel
+func getManual(ch *amqp.Channel, pool *channelPool, queue string) (*MessageWithTag, bool, error) {
+	msg, ok, err := ch.Get(
+		queue, // queue
+		false, // auto-ack = false (manual acknowledgment)
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to consume from %s: %w", queue, err)
+	}
+	if !ok {
+		return nil, false, nil
+	}
+	return &MessageWithTag{
+		Delivery:    *newDelivery(msg),
+		DeliveryTag: msg.DeliveryTag,
+		acker:       channelAcknowledger{ch: ch, pool: pool},
+	}, true, nil
+}
@@ -1,67 +1,317 @@
 package rabbitmq
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// State describes the lifecycle of the broker connection
+type State int32
+
+const (
+	StateConnecting State = iota
+	StateConnected
+	StateReconnecting
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrNotConnected is returned while the connection is down or being re-established
+var ErrNotConnected = errors.New("rabbitmq connection is not available")
+
+// Config holds the settings used to connect to RabbitMQ
+type Config struct {
+	URL       string
+	QueueName string
+
+	// PoolSize is the number of channels kept open in each of the
+	// publish and consume pools (DefaultPoolSize when zero)
+	PoolSize int
+
+	// TLS enables TLS (and mutual TLS with a client certificate) for amqps:// URLs
+	TLS *TLSConfig
+
+	// Topology is declared on every (re)connect; DeadLetterTopology(QueueName, DeadLetter) when nil.
+	// It must dead-letter QueueName into a queue, which becomes the DLQ.
+	Topology *Topology
+
+	// DeadLetter names the DLX, DLQ and dead-letter routing key of the built-in
+	// topology; empty fields are derived from QueueName. It cannot be combined with Topology.
+	DeadLetter DeadLetterNames
+
+	// RetryDelays enables delayed retries: RejectMessage sends a message through
+	// one wait queue per delay, in order, and then to a parking-lot queue.
+	// Without delays rejected messages go straight to the DLQ.
+	RetryDelays []time.Duration
+
+	// Limits sets TTL, length limits, expiry and overflow policy per queue of the
+	// topology, keyed by queue name; the "" key stands for QueueName
+	Limits map[string]QueueLimits
+
+	// QueueBytes reports the body size of the ready messages of a queue, e.g. from the
+	// management API. A nack from a queue with only x-max-length-bytes is reported as
+	// ErrQueueFull only when it says the limit is reached.
+	QueueBytes func(ctx context.Context, queue string) (int64, error)
+}
+
 type RabbitMQ struct {
-	Connection *amqp.Connection
-	Channel    *amqp.Channel
-	QueueName  string
-	DLQName    string
+	QueueName string
+	DLQName   string
+
+	config    Config
+	tlsConfig *tls.Config
+	backoff   Backoff
+	listeners *publishListeners
+	retries   *RetryPolicy // nil when retries are disabled
+	deaths    *deathCounters
+
+	mu          sync.RWMutex
+	publishConn *amqp.Connection
+	consumeConn *amqp.Connection
+	publishPool *channelPool
+	consumePool *channelPool
+	state       State
+	topology    *Topology // declared on every connect, extended by DeclareExchange and Bind
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRabbitMQWithDLX creates a new RabbitMQ connection with Dead Letter Exchange support.
+// Publishing and consuming use separate connections, each with its own pool of channels.
+// The connections are watched and re-established automatically if the broker goes away.
+func NewRabbitMQWithDLX(cfg Config) (*RabbitMQ, error) {
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		if err := checkTLSURL(cfg.URL); err != nil {
+			return nil, err
+		}
+		var err error
+		if tlsConfig, err = cfg.TLS.Build(); err != nil {
+			return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+	}
+
+	topology := cfg.Topology
+	if topology == nil {
+		names := cfg.DeadLetter.For(cfg.QueueName)
+		if err := names.validate(cfg.QueueName); err != nil {
+			return nil, err
+		}
+		topology = DeadLetterTopology(cfg.QueueName, names)
+	} else if cfg.DeadLetter != (DeadLetterNames{}) {
+		return nil, errors.New("dead-letter names cannot be combined with a topology; name the DLX and DLQ in the topology instead")
+	}
+	if !topology.HasQueue(cfg.QueueName) {
+		return nil, fmt.Errorf("topology does not declare queue %q", cfg.QueueName)
+	}
+	dlqName, ok := topology.DeadLetterQueue(cfg.QueueName)
+	if !ok || dlqName == cfg.QueueName {
+		return nil, fmt.Errorf("topology does not dead-letter queue %q into a bound queue", cfg.QueueName)
+	}
+
+	var retries *RetryPolicy
+	if len(cfg.RetryDelays) > 0 {
+		policy, err := NewRetryPolicy(cfg.QueueName, cfg.RetryDelays)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry delays: %w", err)
+		}
+		if topology, err = topology.merge(policy.Topology()); err != nil {
+			return nil, err
+		}
+		retries = policy
+	}
+	if len(cfg.Limits) > 0 {
+		var err error
+		if topology, err = topology.WithLimits(cfg.QueueName, cfg.Limits); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &RabbitMQ{
+		QueueName: cfg.QueueName,
+		DLQName:   dlqName,
+		retries:   retries,
+		deaths:    newDeathCounters(),
+		config:    cfg,
+		tlsConfig: tlsConfig,
+		topology:  topology,
+		backoff:   DefaultBackoff,
+		listeners: newPublishListeners(),
+		state:     StateConnecting,
+		done:      make(chan struct{}),
+	}
+
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Connected to RabbitMQ with DLX enabled")
+	log.Printf("Main Queue: %s", cfg.QueueName)
+	log.Printf("Dead Letter Queue: %s", dlqName)
+	if retries != nil {
+		for i, tier := range retries.Tiers {
+			log.Printf("Retry %d: %s (wait queue %s)", i+1, tier.Name, tier.Queue)
+		}
+		log.Printf("Parking Lot: %s", retries.ParkingLot)
+	}
+
+	return r, nil
 }
 
-// NewRabbitMQWithDLX creates a new RabbitMQ connection with Dead Letter Exchange support
-func NewRabbitMQWithDLX(url, queueName string) (*RabbitMQ, error) {
-	// Connect to RabbitMQ
-	conn, err := amqp.Dial(url)
+// connect dials the broker, declares the topology and opens the channel pools
+func (r *RabbitMQ) connect() error {
+	// Connect to RabbitMQ, once for publishing and once for consuming
+	publishConn, err := dial(r.config.URL, r.tlsConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 	}
 
-	// Create a channel
-	ch, err := conn.Channel()
+	consumeConn, err := dial(r.config.URL, r.tlsConfig)
 	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("failed to open a channel: %w", err)
+		publishConn.Close()
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 	}
 
-	// Setup Dead Letter Exchange and Dead Letter Queue
-	if err := SetupDLX(ch); err != nil {
-		ch.Close()
-		conn.Close()
-		return nil, fmt.Errorf("failed to setup DLX: %w", err)
+	closeAll := func() {
+		publishConn.Close()
+		consumeConn.Close()
 	}
 
-	// Setup main queue with DLX configuration
-	if err := SetupMainQueueWithDLX(ch, queueName); err != nil {
-		ch.Close()
-		conn.Close()
-		return nil, fmt.Errorf("failed to setup main queue with DLX: %w", err)
+	// Declare the topology so a restarted broker gets it back
+	r.mu.RLock()
+	topology := r.topology
+	r.mu.RUnlock()
+	if err := topology.Declare(publishConn); err != nil {
+		closeAll()
+		return err
 	}
 
-	log.Printf("Connected to RabbitMQ with DLX enabled")
-	log.Printf("Main Queue: %s", queueName)
-	log.Printf("Dead Letter Queue: %s", DLQName)
-
-	return &RabbitMQ{
-		Connection: conn,
-		Channel:    ch,
-		QueueName:  queueName,
-		DLQName:    DLQName,
-	}, nil
+	// Every publish channel runs in confirm mode and listens for mandatory returns
+	publishPool, err := newChannelPool("publish", publishConn, r.config.PoolSize, r.listeners.register)
+	if err != nil {
+		closeAll()
+		return err
+	}
+
+	consumePool, err := newChannelPool("consume", consumeConn, r.config.PoolSize, nil)
+	if err != nil {
+		closeAll()
+		return err
+	}
+
+	r.mu.Lock()
+	if r.state == StateClosed {
+		// Close was called while we were dialing
+		r.mu.Unlock()
+		closeAll()
+		return nil
+	}
+	r.publishConn = publishConn
+	r.consumeConn = consumeConn
+	r.publishPool = publishPool
+	r.consumePool = consumePool
+	r.state = StateConnected
+	r.mu.Unlock()
+
+	go r.watch(publishConn, consumeConn)
+
+	return nil
+}
+
+// State returns the current connection state
+func (r *RabbitMQ) State() State {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state
 }
 
-// Close closes the channel and connection
+// pools returns the active publish and consume pools or ErrNotConnected while reconnecting
+func (r *RabbitMQ) pools() (*channelPool, *channelPool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.state != StateConnected {
+		return nil, nil, fmt.Errorf("%w (state: %s)", ErrNotConnected, r.state)
+	}
+	return r.publishPool, r.consumePool, nil
+}
+
+// borrowPublish borrows a channel from the publish pool; return it with pool.put
+func (r *RabbitMQ) borrowPublish(ctx context.Context) (*channelPool, *amqp.Channel, error) {
+	pool, _, err := r.pools()
+	if err != nil {
+		return nil, nil, err
+	}
+	ch, err := pool.get(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pool, ch, nil
+}
+
+// borrowConsume borrows a channel from the consume pool; return it with pool.put
+func (r *RabbitMQ) borrowConsume(ctx context.Context) (*channelPool, *amqp.Channel, error) {
+	_, pool, err := r.pools()
+	if err != nil {
+		return nil, nil, err
+	}
+	ch, err := pool.get(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pool, ch, nil
+}
+
+// Close closes the channel pools and connections
 func (r *RabbitMQ) Close() {
-	if r.Channel != nil {
-		r.Channel.Close()
+	r.closeOnce.Do(func() {
+		close(r.done)
+
+		r.mu.Lock()
+		r.state = StateClosed
+		r.release()
+		r.mu.Unlock()
+
+		log.Println("RabbitMQ connection closed")
+	})
+}
+
+// release closes the pools and connections; the caller must hold r.mu
+func (r *RabbitMQ) release() {
+	if r.publishPool != nil {
+		r.publishPool.close()
+	}
+	if r.consumePool != nil {
+		r.consumePool.close()
+	}
+	if r.publishConn != nil {
+		r.publishConn.Close()
 	}
-	if r.Connection != nil {
-		r.Connection.Close()
+	if r.consumeConn != nil {
+		r.consumeConn.Close()
 	}
-	log.Println("RabbitMQ connection closed")
+	r.publishPool, r.consumePool = nil, nil
+	r.publishConn, r.consumeConn = nil, nil
+	r.listeners.reset()
 }
@@ -1,82 +1,251 @@
 package rabbitmq
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+var (
+	// ErrNoMessages is returned when the main queue is empty
+	ErrNoMessages = errors.New("no messages available in queue")
+
+	// ErrDLQEmpty is returned when the Dead Letter Queue is empty
+	ErrDLQEmpty = errors.New("no messages available in DLQ")
+)
+
+// MessageWithTag represents a message received without auto-ack.
+// It must be settled with AckMessage or NackMessage.
+type MessageWithTag struct {
+	Delivery
+	DeliveryTag uint64
+
+	acker acknowledger
+}
+
+// acknowledger settles a message received without auto-ack
+type acknowledger interface {
+	ack(tag uint64) error
+	nack(tag uint64, requeue bool) error
+}
+
+// channelAcknowledger settles messages on the pooled channel they were received on,
+// since delivery tags are only valid there, and then returns the channel to its pool
+type channelAcknowledger struct {
+	ch   *amqp.Channel
+	pool *channelPool
+}
+
+func (a channelAcknowledger) ack(tag uint64) error {
+	defer a.pool.put(a.ch)
+	return a.ch.Ack(tag, false)
+}
+
+func (a channelAcknowledger) nack(tag uint64, requeue bool) error {
+	defer a.pool.put(a.ch)
+	return a.ch.Nack(
+		tag,     // delivery tag
+		false,   // multiple
+		requeue, // requeue
+	)
+}
+
 // ConsumeMessage consumes a single message from the queue with auto-ack
-func (r *RabbitMQ) ConsumeMessage() (string, error) {
+func (r *RabbitMQ) ConsumeMessage() (*Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	pool, ch, err := r.borrowConsume(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.put(ch)
+
 	// Get a single message
-	msg, ok, err := r.Channel.Get(
+	msg, ok, err := ch.Get(
 		r.QueueName, // queue
 		true,        // auto-ack
 	)
 	if err != nil {
-		return "", fmt.Errorf("failed to consume message: %w", err)
+		return nil, fmt.Errorf("failed to consume message: %w", err)
 	}
 
 	if !ok {
-		return "", fmt.Errorf("no messages available in queue")
+		return nil, ErrNoMessages
 	}
 
-	return string(msg.Body), nil
+	return newDelivery(msg), nil
 }
 
-// ConsumeMessageManual consumes a message without auto-ack (for manual ack/nack)
-func (r *RabbitMQ) ConsumeMessageManual() (string, uint64, error) {
-	// Get a single message without auto-ack
-	msg, ok, err := r.Channel.Get(
-		r.QueueName, // queue
-		false,       // auto-ack = false (manual acknowledgment)
-	)
+// ConsumeMessageManual consumes a message without auto-ack (for manual ack/nack).
+// The returned message must be passed to AckMessage or NackMessage.
+func (r *RabbitMQ) ConsumeMessageManual() (*MessageWithTag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	pool, ch, err := r.borrowConsume(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get a single message without auto-ack; the channel returns to the pool once it is settled
+	msg, ok, err := getManual(ch, pool, r.QueueName)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to consume message: %w", err)
+		pool.put(ch)
+		return nil, err
 	}
 
 	if !ok {
-		return "", 0, fmt.Errorf("no messages available in queue")
+		pool.put(ch)
+		return nil, ErrNoMessages
+	}
+
+	return msg, nil
+}
+
+// AckMessage acknowledges a message
+func (r *RabbitMQ) AckMessage(msg *MessageWithTag) error {
+	return ackMessage(msg)
+}
+
+// NackMessage negatively acknowledges a message.
+// With requeue=false the broker dead-letters the message to the DLX.
+func (r *RabbitMQ) NackMessage(msg *MessageWithTag, requeue bool) error {
+	return nackMessage(msg, requeue)
+}
+
+// RejectMessage consumes a message and rejects it: it is sent to the DLX or,
+// with retry delays configured, to its next retry tier or the parking lot
+func (r *RabbitMQ) RejectMessage() (*RejectResult, error) {
+	if r.retries != nil {
+		return r.retryMessage()
 	}
+	return rejectMessage(r, r.DLQName)
+}
 
-	return string(msg.Body), msg.DeliveryTag, nil
+func ackMessage(msg *MessageWithTag) error {
+	if err := msg.acker.ack(msg.DeliveryTag); err != nil {
+		return fmt.Errorf("failed to ack message: %w", err)
+	}
+	return nil
+}
+
+func nackMessage(msg *MessageWithTag, requeue bool) error {
+	if err := msg.acker.nack(msg.DeliveryTag, requeue); err != nil {
+		return fmt.Errorf("failed to nack message: %w", err)
+	}
+	return nil
 }
 
-// RejectMessage consumes a message and rejects it (sends to DLX)
-func (r *RabbitMQ) RejectMessage() (string, error) {
+// rejectMessage gets a message from b and nacks it without requeue so it is dead-lettered
+func rejectMessage(b Broker, dlqName string) (*RejectResult, error) {
 	// Get a message without auto-ack
-	message, deliveryTag, err := r.ConsumeMessageManual()
+	msg, err := b.ConsumeMessageManual()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Reject the message (nack with requeue=false sends it to DLX)
-	err = r.Channel.Nack(
-		deliveryTag, // delivery tag
-		false,       // multiple
-		false,       // requeue = false (send to DLX instead of requeuing)
-	)
+	// Reject the message (nack with requeue=false sends it to DLX instead of requeuing)
+	if err := b.NackMessage(msg, false); err != nil {
+		return nil, fmt.Errorf("failed to reject message: %w", err)
+	}
+
+	log.Printf("Message rejected and sent to DLX: %s", msg.Body)
+	return &RejectResult{Action: RejectDeadLettered, Queue: dlqName, Delivery: &msg.Delivery}, nil
+}
+
+// ConsumeFromDLQ consumes a message from the Dead Letter Queue and counts it by death reason
+func (r *RabbitMQ) ConsumeFromDLQ() (*Delivery, error) {
+	delivery, err := r.consumeFrom(r.DLQName, ErrDLQEmpty)
+	if err != nil {
+		return nil, err
+	}
+	r.deaths.observe(delivery)
+	return delivery, nil
+}
+
+// DLQStats reports the DLQ depth and the death reasons of the messages consumed from it
+func (r *RabbitMQ) DLQStats() (*DLQStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	pool, ch, err := r.borrowConsume(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to reject message: %w", err)
+		return nil, err
 	}
+	defer pool.put(ch)
 
-	log.Printf("Message rejected and sent to DLX: %s", message)
-	return message, nil
+	q, err := ch.QueueDeclarePassive(r.DLQName, true, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect queue %s: %w", r.DLQName, err)
+	}
+	return r.deaths.stats(r.DLQName, q.Messages), nil
 }
 
-// ConsumeFromDLQ consumes a message from the Dead Letter Queue
-func (r *RabbitMQ) ConsumeFromDLQ() (string, error) {
-	// Get a single message from DLQ
-	msg, ok, err := r.Channel.Get(
-		r.DLQName, // dead letter queue
-		true,      // auto-ack
+// consumeFrom gets a single message from queue with auto-ack, or empty when there is none
+func (r *RabbitMQ) consumeFrom(queue string, empty error) (*Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	pool, ch, err := r.borrowConsume(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.put(ch)
+
+	msg, ok, err := ch.Get(
+		queue, // queue
+		true,  // auto-ack
 	)
 	if err != nil {
-		return "", fmt.Errorf("failed to consume from DLQ: %w", err)
+		return nil, fmt.Errorf("failed to consume from %s: %w", queue, err)
 	}
 
 	if !ok {
-		return "", fmt.Errorf("no messages available in DLQ")
+		return nil, empty
+	}
+
+	return newDelivery(msg), nil
+}
+
+// startConsumer opens a dedicated channel on the consume connection, limits it to
+// prefetch unacknowledged deliveries and starts a manual-ack consumer on queue.
+// Pooled channels are not used because a consumer keeps its channel for as long as it runs.
+func (r *RabbitMQ) startConsumer(queue, consumerTag string, prefetch int) (*amqp.Channel, <-chan amqp.Delivery, error) {
+	r.mu.RLock()
+	conn, state := r.consumeConn, r.state
+	r.mu.RUnlock()
+	if state != StateConnected {
+		return nil, nil, fmt.Errorf("%w (state: %s)", ErrNotConnected, state)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open a consumer channel: %w", err)
+	}
+
+	// Limit the unacknowledged deliveries the broker pushes to this consumer
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to set prefetch: %w", err)
+	}
+
+	deliveries, err := ch.Consume(
+		queue,       // queue
+		consumerTag, // consumer
+		false,       // auto-ack
+		false,       // exclusive
+		false,       // no-local
+		false,       // no-wait
+		nil,         // args
+	)
+	if err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to start consumer: %w", err)
 	}
 
-	return string(msg.Body), nil
+	return ch, deliveries, nil
 }
@@ -0,0 +1,72 @@
+package rabbitmq
+
+import (
+	"sync"
+	"time"
+)
+
+// DLQStats reports the Dead Letter Queue depth and why the messages read from it
+// were dead-lettered, so a growing failure mode stands out
+type DLQStats struct {
+	Queue    string `json:"queue"`
+	Messages int    `json:"messages"` // ready in the DLQ right now
+
+	// Consumed counts the messages read from the DLQ since the service started;
+	// Reasons splits them by the reason of their latest dead-lettering
+	Consumed int64                 `json:"consumed"`
+	Reasons  map[DeathReason]int64 `json:"reasons"`
+	LastSeen *time.Time            `json:"last_seen,omitempty"`
+}
+
+// deathCounters counts dead-lettered messages by reason
+type deathCounters struct {
+	mu       sync.Mutex
+	total    int64
+	reasons  map[DeathReason]int64
+	lastSeen time.Time
+}
+
+func newDeathCounters() *deathCounters {
+	return &deathCounters{reasons: make(map[DeathReason]int64)}
+}
+
+// observe counts a message read from the DLQ. The first x-death record is the
+// most recent one, the dead-lettering that brought it there; messages published
+// straight to the DLQ have none and count as "unknown".
+func (c *deathCounters) observe(d *Delivery) {
+	reason := DeathReason("unknown")
+	if len(d.Deaths) > 0 && d.Deaths[0].Reason != "" {
+		reason = d.Deaths[0].Reason
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total++
+	c.reasons[reason]++
+	c.lastSeen = time.Now()
+}
+
+// stats fills the counters into a DLQStats for queue with the given depth
+func (c *deathCounters) stats(queue string, messages int) *DLQStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := &DLQStats{
+		Queue:    queue,
+		Messages: messages,
+		Consumed: c.total,
+		Reasons:  make(map[DeathReason]int64, len(DeathReasons)+len(c.reasons)),
+	}
+	// Known reasons are always listed, so the counters can be graphed from zero
+	for _, reason := range DeathReasons {
+		stats.Reasons[reason] = 0
+	}
+	for reason, n := range c.reasons {
+		stats.Reasons[reason] = n
+	}
+	if !c.lastSeen.IsZero() {
+		lastSeen := c.lastSeen
+		stats.LastSeen = &lastSeen
+	}
+	return stats
+}
@@ -2,87 +2,141 @@ package rabbitmq
 
 import (
 	"fmt"
-	"log"
+	"strings"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// QueuePlaceholder in a DeadLetterNames field is replaced by the main queue name
+const QueuePlaceholder = "{queue}"
+
+// Default dead-letter names, derived from the main queue name
 const (
-	DLXExchangeName = "dlx.exchange"
-	DLQName         = "messages-dlx.dlq"
-	DLXRoutingKey   = "dlx.routing.key"
+	DefaultDLXExchange   = QueuePlaceholder + ".dlx"
+	DefaultDLQ           = QueuePlaceholder + ".dlq"
+	DefaultDLXRoutingKey = QueuePlaceholder
 )
 
-// SetupDLX creates the Dead Letter Exchange and Dead Letter Queue
-func SetupDLX(ch *amqp.Channel) error {
-	// Declare the Dead Letter Exchange
-	err := ch.ExchangeDeclare(
-		DLXExchangeName, // name
-		"direct",        // type
-		true,            // durable
-		false,           // auto-deleted
-		false,           // internal
-		false,           // no-wait
-		nil,             // arguments
-	)
-	if err != nil {
-		return fmt.Errorf("failed to declare DLX exchange: %w", err)
-	}
-	log.Printf("Created Dead Letter Exchange: %s", DLXExchangeName)
+// DefaultQueueName is the main queue of the service when none is configured
+const DefaultQueueName = "messages-dlx"
 
-	// Declare the Dead Letter Queue
-	_, err = ch.QueueDeclare(
-		DLQName, // name
-		true,    // durable
-		false,   // delete when unused
-		false,   // exclusive
-		false,   // no-wait
-		nil,     // arguments
-	)
-	if err != nil {
-		return fmt.Errorf("failed to declare DLQ: %w", err)
-	}
-	log.Printf("Created Dead Letter Queue: %s", DLQName)
+// legacyDeadLetterNames are the names DefaultQueueName has always been declared with.
+// RabbitMQ refuses to redeclare a queue with other x-dead-letter arguments
+// (PRECONDITION_FAILED), so existing brokers keep working with the new defaults.
+var legacyDeadLetterNames = DeadLetterNames{
+	Exchange:   "dlx.exchange",
+	Queue:      "messages-dlx.dlq",
+	RoutingKey: "dlx.routing.key",
+}
+
+// DeadLetterNames names the Dead Letter Exchange, the Dead Letter Queue and the
+// routing key a main queue dead-letters with. Empty fields take the defaults and
+// QueuePlaceholder is replaced by the queue name, so one set of names can be
+// shared by several main queues.
+type DeadLetterNames struct {
+	Exchange   string `json:"exchange"`
+	Queue      string `json:"queue"`
+	RoutingKey string `json:"routing_key"`
+}
 
-	// Bind the DLQ to the DLX
-	err = ch.QueueBind(
-		DLQName,         // queue name
-		DLXRoutingKey,   // routing key
-		DLXExchangeName, // exchange
-		false,           // no-wait
-		nil,             // arguments
-	)
-	if err != nil {
-		return fmt.Errorf("failed to bind DLQ to DLX: %w", err)
+// For returns the names used by queueName, e.g. orders.dlx, orders.dlq and orders by default.
+// DefaultQueueName keeps its original names (dlx.exchange, messages-dlx.dlq and
+// dlx.routing.key) for the fields left empty.
+func (n DeadLetterNames) For(queueName string) DeadLetterNames {
+	defaults := DeadLetterNames{Exchange: DefaultDLXExchange, Queue: DefaultDLQ, RoutingKey: DefaultDLXRoutingKey}
+	if queueName == DefaultQueueName {
+		defaults = legacyDeadLetterNames
+	}
+	expand := func(name, fallback string) string {
+		if name == "" {
+			name = fallback
+		}
+		return strings.ReplaceAll(name, QueuePlaceholder, queueName)
+	}
+	return DeadLetterNames{
+		Exchange:   expand(n.Exchange, defaults.Exchange),
+		Queue:      expand(n.Queue, defaults.Queue),
+		RoutingKey: expand(n.RoutingKey, defaults.RoutingKey),
 	}
-	log.Printf("Bound DLQ to DLX with routing key: %s", DLXRoutingKey)
+}
 
+// validate rejects names that would make queueName dead-letter into itself
+func (n DeadLetterNames) validate(queueName string) error {
+	if n.Queue == queueName {
+		return fmt.Errorf("queue %q cannot be its own Dead Letter Queue", queueName)
+	}
+	if n.Exchange == "" {
+		return fmt.Errorf("queue %q needs a named Dead Letter Exchange", queueName)
+	}
 	return nil
 }
 
-// SetupMainQueueWithDLX creates the main queue with DLX configuration
-func SetupMainQueueWithDLX(ch *amqp.Channel, queueName string) error {
-	// Queue arguments to enable DLX
-	args := amqp.Table{
-		"x-dead-letter-exchange":    DLXExchangeName,
-		"x-dead-letter-routing-key": DLXRoutingKey,
-		// Optional: set message TTL (time to live) in milliseconds
-		// "x-message-ttl": 60000, // 60 seconds
+// DefaultTopology is used when no topology file is configured:
+// the Dead Letter Exchange, the Dead Letter Queue bound to it, and the
+// main queue dead-lettering into the DLX, all named after queueName
+func DefaultTopology(queueName string) *Topology {
+	return DeadLetterTopology(queueName, DeadLetterNames{}.For(queueName))
+}
+
+// DeadLetterTopology is DefaultTopology with the dead-letter names given by names
+func DeadLetterTopology(queueName string, names DeadLetterNames) *Topology {
+	return &Topology{
+		Exchanges: []ExchangeSpec{
+			{Name: names.Exchange, Type: amqp.ExchangeDirect},
+		},
+		Queues: []QueueSpec{
+			{Name: names.Queue},
+			{
+				Name: queueName,
+				Arguments: map[string]interface{}{
+					"x-dead-letter-exchange":    names.Exchange,
+					"x-dead-letter-routing-key": names.RoutingKey,
+					// TTL, length limits and overflow are added from Config.Limits
+				},
+			},
+		},
+		Bindings: []BindingSpec{
+			{Source: names.Exchange, Destination: names.Queue, RoutingKey: names.RoutingKey},
+		},
 	}
+}
 
-	// Declare the main queue with DLX arguments
-	_, err := ch.QueueDeclare(
-		queueName, // name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		args,      // arguments with DLX configuration
-	)
-	if err != nil {
-		return fmt.Errorf("failed to declare main queue with DLX: %w", err)
+// DeadLetterQueue finds the queue that receives the messages dead-lettered by queueName:
+// the queue bound to its x-dead-letter-exchange with its x-dead-letter-routing-key
+func (t *Topology) DeadLetterQueue(queueName string) (string, bool) {
+	var dlx, routingKey string
+	found := false
+	for _, q := range t.Queues {
+		if q.Name != queueName {
+			continue
+		}
+		dlx, found = q.Arguments["x-dead-letter-exchange"].(string)
+		if key, ok := q.Arguments["x-dead-letter-routing-key"].(string); ok {
+			routingKey = key
+		} else {
+			// Without an explicit key the message keeps its original routing key,
+			// which for the default exchange is the queue name
+			routingKey = queueName
+		}
+	}
+	if !found {
+		return "", false
 	}
-	log.Printf("Created main queue with DLX: %s", queueName)
 
-	return nil
+	exchangeType := ""
+	for _, e := range t.Exchanges {
+		if e.Name == dlx {
+			exchangeType = e.Type
+		}
+	}
+
+	for _, b := range t.Bindings {
+		if b.Source != dlx || b.DestinationType == "exchange" {
+			continue
+		}
+		if exchangeType == amqp.ExchangeFanout || b.RoutingKey == routingKey {
+			return b.Destination, true
+		}
+	}
+	return "", false
 }
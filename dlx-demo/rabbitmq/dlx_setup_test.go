@@ -0,0 +1,59 @@
+package rabbitmq
+
+import "testing"
+
+func TestDeadLetterNamesFor(t *testing.T) {
+	tests := []struct {
+		name  string
+		names DeadLetterNames
+		queue string
+		want  DeadLetterNames
+	}{
+		{
+			name:  "new queue",
+			queue: "orders",
+			want:  DeadLetterNames{Exchange: "orders.dlx", Queue: "orders.dlq", RoutingKey: "orders"},
+		},
+		{
+			// Declared like this by every earlier version; other arguments fail with PRECONDITION_FAILED
+			name:  "default queue",
+			queue: DefaultQueueName,
+			want:  DeadLetterNames{Exchange: "dlx.exchange", Queue: "messages-dlx.dlq", RoutingKey: "dlx.routing.key"},
+		},
+		{
+			name:  "default queue with a configured DLQ",
+			names: DeadLetterNames{Queue: "{queue}.dead"},
+			queue: DefaultQueueName,
+			want:  DeadLetterNames{Exchange: "dlx.exchange", Queue: "messages-dlx.dead", RoutingKey: "dlx.routing.key"},
+		},
+		{
+			name:  "shared names",
+			names: DeadLetterNames{Exchange: "all.dlx", Queue: "all.dlq", RoutingKey: "dead"},
+			queue: "orders",
+			want:  DeadLetterNames{Exchange: "all.dlx", Queue: "all.dlq", RoutingKey: "dead"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.names.For(tt.queue); got != tt.want {
+				t.Errorf("For(%q) = %+v, want %+v", tt.queue, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultTopologyKeepsLegacyArguments(t *testing.T) {
+	topology := DefaultTopology(DefaultQueueName)
+	var queue QueueSpec
+	for _, q := range topology.Queues {
+		if q.Name == DefaultQueueName {
+			queue = q
+		}
+	}
+	if queue.Arguments["x-dead-letter-exchange"] != "dlx.exchange" || queue.Arguments["x-dead-letter-routing-key"] != "dlx.routing.key" {
+		t.Errorf("%s arguments = %v, want dlx.exchange and dlx.routing.key", DefaultQueueName, queue.Arguments)
+	}
+	if dlq, ok := topology.DeadLetterQueue(DefaultQueueName); !ok || dlq != "messages-dlx.dlq" {
+		t.Errorf("DLQ = %q, want messages-dlx.dlq", dlq)
+	}
+}
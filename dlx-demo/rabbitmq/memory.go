@@ -0,0 +1,679 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MemoryBroker is an in-process Broker for tests and local development.
+// It models the durable main queue and its Dead Letter Queue: messages survive
+// Restart, a requeued message goes back to the head of the main queue and a
+// message nacked without requeue is dead-lettered to the DLQ.
+// Exchanges and bindings are routed like RabbitMQ does. With SetRetryDelays,
+// rejected messages wait in retry tiers that expire back to the main queue.
+type MemoryBroker struct {
+	QueueName string
+	DLQName   string
+
+	mu      sync.Mutex
+	state   State
+	queue   []*memoryMessage
+	dlq     []*memoryMessage
+	unacked map[uint64]*memoryMessage
+	nextTag uint64
+
+	topology *Topology
+	deaths   *deathCounters
+
+	// Delayed retries, enabled by SetRetryDelays
+	retries *RetryPolicy
+	waiting map[string][]*memoryMessage // by wait queue
+	parked  []*memoryMessage
+}
+
+// memoryMessage is a message stored by MemoryBroker, kept as the broker would deliver it
+type memoryMessage struct {
+	delivery amqp.Delivery
+
+	// due is when a message in a retry wait queue expires back to the main queue
+	due time.Time
+}
+
+// NewMemoryBroker creates a connected in-memory broker with empty queues,
+// dead-lettering into the DLX and DLQ named after queueName
+func NewMemoryBroker(queueName string) *MemoryBroker {
+	names := DeadLetterNames{}.For(queueName)
+	return &MemoryBroker{
+		QueueName: queueName,
+		DLQName:   names.Queue,
+		state:     StateConnected,
+		unacked:   make(map[uint64]*memoryMessage),
+		topology:  DeadLetterTopology(queueName, names),
+		deaths:    newDeathCounters(),
+	}
+}
+
+// SetState changes the reported connection state; any state other than
+// StateConnected makes operations fail with ErrNotConnected
+func (m *MemoryBroker) SetState(state State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = state
+}
+
+// Restart simulates a broker restart: both queues are durable, so their
+// messages are kept and unacknowledged ones are returned to the main queue
+func (m *MemoryBroker) Restart() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for tag, msg := range m.unacked {
+		delete(m.unacked, tag)
+		m.requeue(msg)
+	}
+}
+
+// SetRetryDelays enables delayed retries the way Config.RetryDelays does:
+// RejectMessage then moves messages through the retry tiers and the parking lot
+func (m *MemoryBroker) SetRetryDelays(delays []time.Duration) error {
+	policy, err := NewRetryPolicy(m.QueueName, delays)
+	if err != nil {
+		return fmt.Errorf("invalid retry delays: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	merged, err := m.topology.merge(policy.Topology())
+	if err != nil {
+		return err
+	}
+	m.topology = merged
+	m.retries = policy
+	m.waiting = make(map[string][]*memoryMessage)
+	return nil
+}
+
+// SetQueueLimits declares limits on one queue the way Config.Limits does; storing a
+// message then honours x-max-length, x-max-length-bytes and x-overflow.
+// TTL and queue expiry are declared but not simulated.
+func (m *MemoryBroker) SetQueueLimits(queue string, limits QueueLimits) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	topology, err := m.topology.WithLimits(m.QueueName, map[string]QueueLimits{queue: limits})
+	if err != nil {
+		return err
+	}
+	m.topology = topology
+	return nil
+}
+
+// Messages returns the bodies of the ready messages in the main queue
+func (m *MemoryBroker) Messages() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireRetries()
+	return bodies(m.queue)
+}
+
+// ParkedMessages returns the bodies of the messages in the parking lot
+func (m *MemoryBroker) ParkedMessages() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return bodies(m.parked)
+}
+
+// DLQMessages returns the bodies of the messages in the Dead Letter Queue
+func (m *MemoryBroker) DLQMessages() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return bodies(m.dlq)
+}
+
+// PublishMessage routes a message through the declared exchanges and bindings
+// and appends it to the main queue and/or the DLQ when it reaches them
+func (m *MemoryBroker) PublishMessage(msg Message, opts PublishOptions) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return "", err
+	}
+
+	publishing, err := msg.publishing()
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.publish(publishing, opts); err != nil {
+		return "", err
+	}
+	return publishing.MessageId, nil
+}
+
+// PublishBatch publishes the messages one by one; in all-or-nothing mode the
+// first failure restores every queue and rolls back the rest of the batch
+func (m *MemoryBroker) PublishBatch(batch []BatchMessage, mode BatchMode) (*BatchResult, error) {
+	publishings, err := prepareBatch(batch, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+
+	result := newBatchResult(mode, publishings)
+	restore := m.snapshot()
+	for i, publishing := range publishings {
+		if err := m.publish(publishing, batch[i].Options); err != nil {
+			result.set(i, batchFailure(err), err)
+			if mode == BatchAllOrNothing {
+				restore()
+				result.rollBack(i, fmt.Errorf("transaction rolled back: message %d failed", i))
+				break
+			}
+			continue
+		}
+		result.set(i, BatchAcked, nil)
+	}
+	result.count()
+	return result, nil
+}
+
+// publish routes publishing and stores it in every queue it reaches; the caller must hold m.mu
+func (m *MemoryBroker) publish(publishing amqp.Publishing, opts PublishOptions) error {
+	exchange, routingKey := opts.target(m.QueueName)
+	queues, err := m.topology.route(exchange, routingKey, publishing.Headers)
+	if err != nil {
+		return err
+	}
+	if len(queues) == 0 {
+		return fmt.Errorf("%w: exchange %q, routing key %q (312 NO_ROUTE)", ErrUnroutable, exchange, routingKey)
+	}
+
+	// Like the broker, a queue that refuses the message does not stop the others
+	// from getting it, but the publisher sees a nack
+	var full error
+	for _, q := range queues {
+		if err := m.store(q, &memoryMessage{delivery: publishedDelivery(publishing, exchange, routingKey)}); err != nil && full == nil {
+			full = err
+		}
+	}
+	return full
+}
+
+// snapshot saves the contents of every queue and returns a func that puts them back;
+// the caller must hold m.mu
+func (m *MemoryBroker) snapshot() func() {
+	queue := append([]*memoryMessage(nil), m.queue...)
+	dlq := append([]*memoryMessage(nil), m.dlq...)
+	parked := append([]*memoryMessage(nil), m.parked...)
+	var waiting map[string][]*memoryMessage
+	if m.waiting != nil {
+		waiting = make(map[string][]*memoryMessage, len(m.waiting))
+		for q, messages := range m.waiting {
+			waiting[q] = append([]*memoryMessage(nil), messages...)
+		}
+	}
+	return func() {
+		m.queue, m.dlq, m.parked, m.waiting = queue, dlq, parked, waiting
+	}
+}
+
+// DeclareExchange adds an exchange to the in-memory topology
+func (m *MemoryBroker) DeclareExchange(spec ExchangeSpec) error {
+	return m.extendTopology(&Topology{Exchanges: []ExchangeSpec{spec}})
+}
+
+// Bind adds a binding to the in-memory topology
+func (m *MemoryBroker) Bind(spec BindingSpec) error {
+	return m.extendTopology(&Topology{Bindings: []BindingSpec{spec}})
+}
+
+func (m *MemoryBroker) extendTopology(extra *Topology) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return err
+	}
+
+	for _, e := range extra.Exchanges {
+		if kind, ok := m.topology.exchangeType(e.Name); ok && kind != e.Type {
+			// Same error the broker reports for an inequivalent redeclaration
+			return &TopologyError{Conflicts: []TopologyConflict{{
+				Kind:   "exchange",
+				Name:   e.Name,
+				Reason: fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg 'type' for exchange '%s': received '%s' but current is '%s'", e.Name, e.Type, kind),
+			}}}
+		}
+	}
+
+	merged, err := m.topology.merge(extra)
+	if err != nil {
+		return err
+	}
+	m.topology = merged
+	return nil
+}
+
+// ConsumeMessage removes and returns the message at the head of the main queue
+func (m *MemoryBroker) ConsumeMessage() (*Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	m.expireRetries()
+	msg, ok := pop(&m.queue)
+	if !ok {
+		return nil, ErrNoMessages
+	}
+	return newDelivery(msg.delivery), nil
+}
+
+// ConsumeMessageManual takes the message at the head of the main queue and holds it as unacknowledged
+func (m *MemoryBroker) ConsumeMessageManual() (*MessageWithTag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	m.expireRetries()
+	msg, ok := pop(&m.queue)
+	if !ok {
+		return nil, ErrNoMessages
+	}
+
+	m.nextTag++
+	m.unacked[m.nextTag] = msg
+
+	return &MessageWithTag{
+		Delivery:    *newDelivery(msg.delivery),
+		DeliveryTag: m.nextTag,
+		acker:       memoryAcknowledger{m},
+	}, nil
+}
+
+// AckMessage removes an unacknowledged message for good
+func (m *MemoryBroker) AckMessage(msg *MessageWithTag) error {
+	return ackMessage(msg)
+}
+
+// NackMessage requeues an unacknowledged message or, without requeue, dead-letters it
+func (m *MemoryBroker) NackMessage(msg *MessageWithTag, requeue bool) error {
+	return nackMessage(msg, requeue)
+}
+
+// RejectMessage consumes a message and dead-letters it to the DLQ or,
+// with retry delays set, moves it to its next retry tier or the parking lot
+func (m *MemoryBroker) RejectMessage() (*RejectResult, error) {
+	m.mu.Lock()
+	if m.retries == nil {
+		m.mu.Unlock()
+		return rejectMessage(m, m.DLQName)
+	}
+	defer m.mu.Unlock()
+
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	m.expireRetries()
+	msg, ok := pop(&m.queue)
+	if !ok {
+		return nil, ErrNoMessages
+	}
+
+	// Republished through the retry exchange, like RabbitMQ.RejectMessage does
+	tier, attempt := m.retries.next(msg.delivery.Headers)
+	d := msg.delivery
+	d.Exchange, d.Redelivered = m.retries.Exchange, false
+	if tier != nil {
+		d.RoutingKey = tier.RoutingKey
+		m.store(tier.Queue, &memoryMessage{delivery: d}) // the in-memory wait queues ignore length limits
+	} else {
+		d.RoutingKey = m.retries.ParkingLot
+		m.store(m.retries.ParkingLot, &memoryMessage{delivery: d})
+	}
+	return m.retries.result(newDelivery(msg.delivery), tier, attempt), nil
+}
+
+// ConsumeFromDLQ removes and returns the message at the head of the DLQ, counting it by death reason
+func (m *MemoryBroker) ConsumeFromDLQ() (*Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	msg, ok := pop(&m.dlq)
+	if !ok {
+		return nil, ErrDLQEmpty
+	}
+	delivery := newDelivery(msg.delivery)
+	m.deaths.observe(delivery)
+	return delivery, nil
+}
+
+// BrowseMessages returns a page of the main queue without consuming it
+func (m *MemoryBroker) BrowseMessages(offset, limit int) (*BrowsePage, error) {
+	return browsePage(m.QueueName, offset, limit, m.scan)
+}
+
+// BrowseDLQ returns a page of the Dead Letter Queue without consuming it
+func (m *MemoryBroker) BrowseDLQ(offset, limit int) (*BrowsePage, error) {
+	return browsePage(m.DLQName, offset, limit, m.scan)
+}
+
+// FindDLQMessage returns the DLQ message with the given message ID, leaving it in the DLQ
+func (m *MemoryBroker) FindDLQMessage(messageID string) (*Delivery, error) {
+	return findMessage(m.DLQName, messageID, m.scan)
+}
+
+// scan visits the ready messages of the main queue or the DLQ in order. Like
+// RabbitMQ.scan, every message it reads is left in place but marked redelivered.
+func (m *MemoryBroker) scan(queue string, visit visitFunc) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return 0, err
+	}
+	m.expireRetries()
+
+	messages := m.queue
+	if queue == m.DLQName {
+		messages = m.dlq
+	}
+	for _, msg := range messages {
+		delivery := newDelivery(msg.delivery)
+		msg.delivery.Redelivered = true
+		if !visit(delivery) {
+			break
+		}
+	}
+	return len(messages), nil
+}
+
+// Redrive moves matching DLQ messages to their original queue or opts.Queue,
+// routing them through the default exchange like RabbitMQ.Redrive does
+func (m *MemoryBroker) Redrive(opts RedriveOptions) (*RedriveResult, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+
+	result := &RedriveResult{DryRun: opts.DryRun, Messages: []RedriveItem{}}
+	var kept []*memoryMessage
+	for i, msg := range m.dlq {
+		if opts.MaxMessages > 0 && result.Moved >= opts.MaxMessages {
+			kept = append(kept, m.dlq[i:]...)
+			break
+		}
+
+		item := opts.plan(newDelivery(msg.delivery), m.DLQName)
+		if item.Action == RedriveMoved && !opts.DryRun {
+			if queues, _ := m.topology.route("", item.Queue, nil); len(queues) == 0 {
+				item.Action = RedriveFailed
+				item.Error = fmt.Sprintf("%v: queue %q does not exist (312 NO_ROUTE)", ErrUnroutable, item.Queue)
+			} else {
+				d := msg.delivery
+				d.Exchange, d.RoutingKey, d.Redelivered = "", item.Queue, false
+				err := m.store(item.Queue, &memoryMessage{delivery: d})
+				if err == nil {
+					result.add(item)
+					continue
+				}
+				item.Action = RedriveFailed
+				item.Error = err.Error()
+			}
+		}
+		kept = append(kept, msg)
+		result.add(item)
+	}
+	m.dlq = kept
+	return result, nil
+}
+
+// DLQStats reports the DLQ depth and the death reasons of the messages consumed from it
+func (m *MemoryBroker) DLQStats() (*DLQStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	return m.deaths.stats(m.DLQName, len(m.dlq)), nil
+}
+
+// RetryStatus reports the messages waiting in each retry tier and in the parking lot
+func (m *MemoryBroker) RetryStatus() (*RetryStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	m.expireRetries()
+	return m.retries.status(func(queue string) (int, error) {
+		if queue == m.retries.ParkingLot {
+			return len(m.parked), nil
+		}
+		return len(m.waiting[queue]), nil
+	})
+}
+
+// ConsumeFromParkingLot removes and returns the message at the head of the parking lot
+func (m *MemoryBroker) ConsumeFromParkingLot() (*Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	if m.retries == nil {
+		return nil, ErrRetriesDisabled
+	}
+	msg, ok := pop(&m.parked)
+	if !ok {
+		return nil, ErrParkingLotEmpty
+	}
+	return newDelivery(msg.delivery), nil
+}
+
+// State returns the simulated connection state
+func (m *MemoryBroker) State() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// checkConnected fails while the simulated connection is down; the caller must hold m.mu
+func (m *MemoryBroker) checkConnected() error {
+	if m.state != StateConnected {
+		return fmt.Errorf("%w (state: %s)", ErrNotConnected, m.state)
+	}
+	return nil
+}
+
+// requeue puts a returned message back at the head of the main queue; the caller must hold m.mu
+func (m *MemoryBroker) requeue(msg *memoryMessage) {
+	msg.delivery.Redelivered = true
+	m.queue = append([]*memoryMessage{msg}, m.queue...)
+}
+
+// deadLetter moves a rejected message to the DLQ the way RabbitMQ does: it is
+// republished through the dead-letter exchange with an x-death record; the caller must hold m.mu
+func (m *MemoryBroker) deadLetter(msg *memoryMessage, reason DeathReason) {
+	d := msg.delivery
+	d.Headers = addDeath(d.Headers, DeathRecord{
+		Reason:      reason,
+		Queue:       m.QueueName,
+		Exchange:    d.Exchange,
+		RoutingKeys: []string{d.RoutingKey},
+		Time:        time.Now().Truncate(time.Second),
+	})
+
+	for _, q := range m.topology.Queues {
+		if q.Name != m.QueueName {
+			continue
+		}
+		if dlx, ok := q.Arguments["x-dead-letter-exchange"].(string); ok {
+			d.Exchange = dlx
+		}
+		if key, ok := q.Arguments["x-dead-letter-routing-key"].(string); ok {
+			d.RoutingKey = key
+		}
+	}
+	d.Redelivered = false
+
+	// A full DLQ drops the message, as the broker does when dead-lettering
+	m.enqueue(&m.dlq, m.DLQName, &memoryMessage{delivery: d})
+}
+
+// store appends a message to the named queue; the caller must hold m.mu.
+// It returns ErrQueueFull when the queue is full and its overflow policy refuses new messages.
+func (m *MemoryBroker) store(queue string, msg *memoryMessage) error {
+	switch queue {
+	case m.QueueName:
+		return m.enqueue(&m.queue, queue, msg)
+	case m.DLQName:
+		return m.enqueue(&m.dlq, queue, msg)
+	}
+	if m.retries == nil {
+		return nil
+	}
+	if queue == m.retries.ParkingLot {
+		return m.enqueue(&m.parked, queue, msg)
+	}
+	for _, tier := range m.retries.Tiers {
+		if queue == tier.Queue {
+			msg.due = time.Now().Add(tier.Delay)
+			m.waiting[queue] = append(m.waiting[queue], msg)
+		}
+	}
+	return nil
+}
+
+// enqueue appends msg to messages within the x-max-length and x-max-length-bytes of queue:
+// reject-publish refuses the message (reject-publish-dlx also dead-letters it) and drop-head
+// removes the oldest messages, which the main queue dead-letters with reason maxlen.
+// The caller must hold m.mu.
+func (m *MemoryBroker) enqueue(messages *[]*memoryMessage, queue string, msg *memoryMessage) error {
+	limits := m.topology.QueueLimits(queue)
+	sizes := make([]int, len(*messages))
+	for i, queued := range *messages {
+		sizes[i] = len(queued.delivery.Body)
+	}
+
+	reject, drop := limits.overflow(sizes, len(msg.delivery.Body))
+	if reject {
+		if limits.Overflow == OverflowRejectPublishDLX && queue == m.QueueName {
+			m.deadLetter(msg, DeathMaxLen)
+		}
+		return queueFullError(queue, limits.Overflow)
+	}
+
+	*messages = append(*messages, msg)
+	dropped := (*messages)[:drop]
+	*messages = (*messages)[drop:]
+	if queue == m.QueueName {
+		for _, old := range dropped {
+			m.deadLetter(old, DeathMaxLen)
+		}
+	}
+	return nil
+}
+
+// expireRetries moves the messages whose wait is over back to the main queue, as the
+// x-message-ttl and dead-lettering of the wait queues do; the caller must hold m.mu
+func (m *MemoryBroker) expireRetries() {
+	if m.retries == nil {
+		return
+	}
+
+	now := time.Now()
+	var expired []*memoryMessage
+	for _, tier := range m.retries.Tiers {
+		waiting := m.waiting[tier.Queue]
+		n := 0
+		for n < len(waiting) && !waiting[n].due.After(now) {
+			d := waiting[n].delivery
+			d.Headers = addDeath(d.Headers, DeathRecord{
+				Reason:      DeathExpired,
+				Queue:       tier.Queue,
+				Exchange:    d.Exchange,
+				RoutingKeys: []string{d.RoutingKey},
+				Time:        waiting[n].due.Truncate(time.Second),
+			})
+			d.Exchange, d.RoutingKey = "", m.QueueName
+			expired = append(expired, &memoryMessage{delivery: d, due: waiting[n].due})
+			n++
+		}
+		m.waiting[tier.Queue] = waiting[n:]
+	}
+
+	// Messages from different tiers reach the main queue in expiry order
+	sort.SliceStable(expired, func(i, j int) bool { return expired[i].due.Before(expired[j].due) })
+	for _, msg := range expired {
+		msg.due = time.Time{}
+		m.queue = append(m.queue, msg)
+	}
+}
+
+func pop(queue *[]*memoryMessage) (*memoryMessage, bool) {
+	if len(*queue) == 0 {
+		return nil, false
+	}
+	msg := (*queue)[0]
+	*queue = (*queue)[1:]
+	return msg, true
+}
+
+func bodies(queue []*memoryMessage) []string {
+	out := make([]string, len(queue))
+	for i, msg := range queue {
+		out[i] = string(msg.delivery.Body)
+	}
+	return out
+}
+
+// memoryAcknowledger settles messages handed out by a MemoryBroker
+type memoryAcknowledger struct {
+	m *MemoryBroker
+}
+
+func (a memoryAcknowledger) take(tag uint64) (*memoryMessage, error) {
+	msg, ok := a.m.unacked[tag]
+	if !ok {
+		// RabbitMQ closes the channel with PRECONDITION_FAILED in this case
+		return nil, fmt.Errorf("unknown delivery tag %d", tag)
+	}
+	delete(a.m.unacked, tag)
+	return msg, nil
+}
+
+func (a memoryAcknowledger) ack(tag uint64) error {
+	a.m.mu.Lock()
+	defer a.m.mu.Unlock()
+	_, err := a.take(tag)
+	return err
+}
+
+func (a memoryAcknowledger) nack(tag uint64, requeue bool) error {
+	a.m.mu.Lock()
+	defer a.m.mu.Unlock()
+	msg, err := a.take(tag)
+	if err != nil {
+		return err
+	}
+	if requeue {
+		a.m.requeue(msg)
+	} else {
+		a.m.deadLetter(msg, DeathRejected)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Export writes the ready messages of the DLQ, the main queue or the parking lot
+// as NDJSON archive records, like RabbitMQ.Export.
+// Copied messages stay in place but are marked redelivered, as a requeue does.
+func (m *MemoryBroker) Export(w io.Writer, opts ExportOptions) (*ExportResult, error) {
+	opts, err := opts.withDefaults(m.DLQName)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	m.expireRetries()
+
+	queue := m.queueByName(opts.Queue)
+	if queue == nil {
+		return nil, fmt.Errorf("%w: %s", ErrQueueNotFound, opts.Queue)
+	}
+
+	n := len(*queue)
+	if opts.Limit > 0 && opts.Limit < n {
+		n = opts.Limit
+	}
+	encoder := json.NewEncoder(w)
+	for _, msg := range (*queue)[:n] {
+		if err := encoder.Encode(newArchiveRecord(msg.delivery, opts.Queue)); err != nil {
+			return nil, fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+
+	if opts.Mode == ExportDestructive {
+		*queue = (*queue)[n:]
+	} else {
+		for _, msg := range (*queue)[:n] {
+			msg.delivery.Redelivered = true
+		}
+	}
+	return &ExportResult{Queue: opts.Queue, Mode: opts.Mode, Exported: n}, nil
+}
+
+// Import stores the records of an NDJSON archive, skipping duplicates like RabbitMQ.Import
+func (m *MemoryBroker) Import(src io.Reader, opts ImportOptions) (*ImportResult, error) {
+	return importArchive(src, opts, m.messageIDs, m.importPublishing)
+}
+
+// messageIDs lists the message IDs of up to scan ready messages of queue, which
+// are marked redelivered, as the requeue of RabbitMQ.Import's scan does
+func (m *MemoryBroker) messageIDs(queue string, scan int) (map[string]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	m.expireRetries()
+
+	messages := m.queueByName(queue)
+	if messages == nil {
+		return nil, fmt.Errorf("%w: %s", ErrQueueNotFound, queue)
+	}
+	n := len(*messages)
+	if scan < n {
+		n = scan
+	}
+	ids := make(map[string]bool)
+	for _, msg := range (*messages)[:n] {
+		msg.delivery.Redelivered = true
+		if msg.delivery.MessageId != "" {
+			ids[msg.delivery.MessageId] = true
+		}
+	}
+	return ids, nil
+}
+
+// importPublishing stores p in queue as if published through the default exchange
+func (m *MemoryBroker) importPublishing(p amqp.Publishing, queue string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return err
+	}
+	if m.queueByName(queue) == nil {
+		return fmt.Errorf("%w: exchange %q, routing key %q (312 NO_ROUTE)", ErrUnroutable, "", queue)
+	}
+
+	return m.store(queue, &memoryMessage{delivery: publishedDelivery(p, "", queue)})
+}
+
+// queueByName returns the messages of the main queue, the DLQ or the parking lot,
+// or nil for any other queue; the caller must hold m.mu
+func (m *MemoryBroker) queueByName(queue string) *[]*memoryMessage {
+	switch queue {
+	case m.QueueName:
+		return &m.queue
+	case m.DLQName:
+		return &m.dlq
+	}
+	if m.retries == nil {
+		return nil
+	}
+	if queue == m.retries.ParkingLot {
+		return &m.parked
+	}
+	return nil
+}
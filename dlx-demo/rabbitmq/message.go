@@ -0,0 +1,127 @@
+package rabbitmq
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrInvalidMessage is returned when a message property fails validation
+var ErrInvalidMessage = errors.New("invalid message")
+
+// Message is a message body with the AMQP properties it is published with
+type Message struct {
+	Body string
+
+	ContentType     string // defaults to text/plain
+	ContentEncoding string
+	MessageID       string // generated when empty
+	CorrelationID   string
+	ReplyTo         string
+	Priority        int       // 0-255; queues honour it up to their x-max-priority
+	Expiration      string    // per-message TTL in milliseconds
+	Timestamp       time.Time // defaults to the publish time
+	Type            string
+	AppID           string
+	Headers         map[string]interface{}
+}
+
+// Validate checks every property against what the AMQP 0-9-1 encoding accepts
+func (m Message) Validate() error {
+	var problems []string
+
+	shortStrings := []struct{ name, value string }{
+		{"content_type", m.ContentType},
+		{"content_encoding", m.ContentEncoding},
+		{"message_id", m.MessageID},
+		{"correlation_id", m.CorrelationID},
+		{"reply_to", m.ReplyTo},
+		{"expiration", m.Expiration},
+		{"type", m.Type},
+		{"app_id", m.AppID},
+	}
+	for _, s := range shortStrings {
+		if len(s.value) > 255 {
+			problems = append(problems, fmt.Sprintf("%s is longer than 255 bytes", s.name))
+		}
+	}
+
+	if m.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(m.ContentType); err != nil {
+			problems = append(problems, fmt.Sprintf("content_type %q is not a valid media type", m.ContentType))
+		}
+	}
+	if m.Priority < 0 || m.Priority > 255 {
+		problems = append(problems, fmt.Sprintf("priority %d is out of range 0-255", m.Priority))
+	}
+	if m.Expiration != "" {
+		if _, err := strconv.ParseUint(m.Expiration, 10, 32); err != nil {
+			problems = append(problems, fmt.Sprintf("expiration %q must be a number of milliseconds", m.Expiration))
+		}
+	}
+	for k := range m.Headers {
+		if k == "" || len(k) > 255 {
+			problems = append(problems, fmt.Sprintf("header name %q must be 1-255 bytes", k))
+		}
+	}
+	if err := toTable(m.Headers).Validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("headers: %v", err))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidMessage, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// publishing validates m and maps it onto an amqp.Publishing,
+// filling in the message ID, timestamp and content type when they are empty
+func (m Message) publishing() (amqp.Publishing, error) {
+	if err := m.Validate(); err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	if m.MessageID == "" {
+		m.MessageID = newMessageID()
+	}
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now()
+	}
+	if m.ContentType == "" {
+		m.ContentType = "text/plain"
+	}
+
+	return amqp.Publishing{
+		Headers:         toTable(m.Headers),
+		ContentType:     m.ContentType,
+		ContentEncoding: m.ContentEncoding,
+		DeliveryMode:    amqp.Persistent, // make message persistent
+		Priority:        uint8(m.Priority),
+		CorrelationId:   m.CorrelationID,
+		ReplyTo:         m.ReplyTo,
+		Expiration:      m.Expiration,
+		MessageId:       m.MessageID,
+		Timestamp:       m.Timestamp,
+		Type:            m.Type,
+		AppId:           m.AppID,
+		Body:            []byte(m.Body),
+	}, nil
+}
+
+// newMessageID returns a random (version 4) UUID
+func newMessageID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(fmt.Sprintf("failed to generate message ID: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -2,32 +2,215 @@ package rabbitmq
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"sync"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-// PublishMessage publishes a message to the queue
-func (r *RabbitMQ) PublishMessage(message string) error {
+var (
+	// ErrUnroutable is returned when a mandatory message matched no queue and was returned
+	ErrUnroutable = errors.New("message could not be routed to any queue")
+
+	// ErrExchangeNotFound is returned when publishing to an exchange that does not exist
+	ErrExchangeNotFound = errors.New("exchange not found")
+)
+
+// PublishOptions selects where a message is published.
+// The zero value publishes to the queue through the default exchange.
+type PublishOptions struct {
+	Exchange   string
+	RoutingKey string
+}
+
+// target returns the exchange and routing key to publish to
+func (o PublishOptions) target(queueName string) (string, string) {
+	if o.Exchange == "" && o.RoutingKey == "" {
+		return "", queueName
+	}
+	return o.Exchange, o.RoutingKey
+}
+
+// PublishMessage publishes a message as mandatory and waits for the broker to confirm it.
+// It returns the message ID, generated when msg has none, and ErrUnroutable
+// when no queue is bound for the routing key.
+func (r *RabbitMQ) PublishMessage(msg Message, opts PublishOptions) (string, error) {
+	publishing, err := msg.publishing()
+	if err != nil {
+		return "", err
+	}
+
+	exchange, routingKey := opts.target(r.QueueName)
+	if err := r.publish(publishing, exchange, routingKey); err != nil {
+		return "", err
+	}
+	return publishing.MessageId, nil
+}
+
+// publish sends publishing as mandatory on a confirm-mode channel and waits for the confirm
+func (r *RabbitMQ) publish(publishing amqp.Publishing, exchange, routingKey string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err := r.Channel.PublishWithContext(
+	pool, ch, err := r.borrowPublish(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.put(ch)
+
+	listener := r.listeners.get(ch)
+	if listener == nil {
+		// The connection was released while we held the channel
+		return fmt.Errorf("%w: publish channel was closed", ErrNotConnected)
+	}
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(
 		ctx,
-		"",          // exchange
-		r.QueueName, // routing key (queue name)
-		false,       // mandatory
-		false,       // immediate
-		amqp.Publishing{
-			ContentType:  "text/plain",
-			Body:         []byte(message),
-			DeliveryMode: amqp.Persistent, // make message persistent
-		},
+		exchange,   // exchange
+		routingKey, // routing key
+		true,       // mandatory: return the message if it matches no queue
+		false,      // immediate
+		publishing,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to publish message: %w", err)
 	}
 
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		// A late return or confirm would be mistaken for the next message's, so drop the channel
+		ch.Close()
+		r.listeners.forget(ch)
+		return fmt.Errorf("failed to wait for publisher confirm: %w", err)
+	}
+
+	// The broker sends basic.return before the confirm, so it is already buffered
+	select {
+	case ret := <-listener.returns:
+		return fmt.Errorf("%w: exchange %q, routing key %q (%d %s)",
+			ErrUnroutable, ret.Exchange, ret.RoutingKey, ret.ReplyCode, ret.ReplyText)
+	default:
+	}
+
+	if !acked {
+		return r.nackError(ch, listener, exchange, routingKey, publishing.Headers)
+	}
+
 	return nil
 }
+
+// nackError explains a nacked publish: the channel was closed, for example because
+// the exchange does not exist, or a reject-publish queue on the route is full. The
+// broker also nacks on internal errors, so a queue is only reported as full when it
+// really is at its length limit.
+func (r *RabbitMQ) nackError(ch *amqp.Channel, listener *publishListener, exchange, routingKey string, headers amqp.Table) error {
+	if amqpErr, closed := listener.closeError(); closed {
+		r.listeners.forget(ch)
+		if amqpErr != nil && amqpErr.Code == amqp.NotFound {
+			return fmt.Errorf("%w: %q", ErrExchangeNotFound, exchange)
+		}
+		return fmt.Errorf("failed to publish message: %w", amqpErr)
+	}
+
+	nacked := fmt.Errorf("failed to publish message: nacked by the broker")
+	r.mu.RLock()
+	topology := r.topology
+	r.mu.RUnlock()
+	queues, err := topology.route(exchange, routingKey, headers)
+	if err != nil {
+		return nacked
+	}
+
+	// A queue with x-overflow reject-publish nacks messages once it is full
+	for _, queue := range queues {
+		limits := topology.QueueLimits(queue)
+		if !limits.Overflow.rejects() {
+			continue
+		}
+		full, err := r.queueAtLimit(queue, limits)
+		if err != nil {
+			log.Printf("Could not check whether queue %s is full: %v", queue, err)
+		}
+		if full {
+			return queueFullError(queue, limits.Overflow)
+		}
+	}
+	return nacked
+}
+
+// publishListener holds the notifications registered on one publish channel
+type publishListener struct {
+	returns chan amqp.Return
+	closed  chan *amqp.Error
+
+	// isClosed and closeErr remember the closure once it has been received
+	isClosed bool
+	closeErr *amqp.Error
+}
+
+// closeError reports whether the channel was closed and with which error.
+// Only the goroutine that borrowed the channel may call it.
+func (l *publishListener) closeError() (*amqp.Error, bool) {
+	if !l.isClosed {
+		select {
+		case amqpErr := <-l.closed:
+			l.isClosed, l.closeErr = true, amqpErr
+		default:
+			return nil, false
+		}
+	}
+	return l.closeErr, true
+}
+
+// publishListeners keeps the listener of every open publish channel.
+// Listeners can only be registered once per channel, so they are created
+// by the pool's init function and looked up on each publish.
+type publishListeners struct {
+	mu sync.Mutex
+	m  map[*amqp.Channel]*publishListener
+}
+
+func newPublishListeners() *publishListeners {
+	return &publishListeners{m: make(map[*amqp.Channel]*publishListener)}
+}
+
+// register puts a publish channel into confirm mode and listens for returns and closure
+func (l *publishListeners) register(ch *amqp.Channel) error {
+	// Enable publisher confirmations
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("failed to enable publisher confirmations: %w", err)
+	}
+
+	listener := &publishListener{
+		returns: ch.NotifyReturn(make(chan amqp.Return, 1)),
+		closed:  ch.NotifyClose(make(chan *amqp.Error, 1)),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.m[ch] = listener
+	return nil
+}
+
+func (l *publishListeners) get(ch *amqp.Channel) *publishListener {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.m[ch]
+}
+
+// forget drops the listener of a closed channel
+func (l *publishListeners) forget(ch *amqp.Channel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.m, ch)
+}
+
+// reset drops every listener once the publish connection is gone
+func (l *publishListeners) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.m = make(map[*amqp.Channel]*publishListener)
+}
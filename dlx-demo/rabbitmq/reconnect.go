@@ -0,0 +1,89 @@
+package rabbitmq
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Backoff computes jittered exponential delays between reconnection attempts
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// DefaultBackoff starts at half a second and caps at 30 seconds
+var DefaultBackoff = Backoff{
+	Initial: 500 * time.Millisecond,
+	Max:     30 * time.Second,
+}
+
+// Delay returns the wait before the given attempt (starting at 1).
+// Half of the exponential delay is fixed and the other half is random,
+// so several clients restarting together do not hit the broker in lockstep.
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := b.Max
+	if attempt < 32 {
+		if exp := b.Initial << (attempt - 1); exp > 0 && exp < b.Max {
+			d = exp
+		}
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// watch waits for either connection to close and starts reconnecting.
+// Channel-level failures are handled by the pools, which reopen closed channels.
+func (r *RabbitMQ) watch(publishConn, consumeConn *amqp.Connection) {
+	publishClosed := publishConn.NotifyClose(make(chan *amqp.Error, 1))
+	consumeClosed := consumeConn.NotifyClose(make(chan *amqp.Error, 1))
+
+	var reason *amqp.Error
+	select {
+	case <-r.done:
+		return
+	case reason = <-publishClosed:
+	case reason = <-consumeClosed:
+	}
+
+	// A nil reason means the close was requested by Close()
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+
+	log.Printf("RabbitMQ connection lost: %v", reason)
+
+	// Tear down both connections so they are re-established together
+	r.mu.Lock()
+	r.state = StateReconnecting
+	r.release()
+	r.mu.Unlock()
+
+	r.reconnect()
+}
+
+// reconnect retries connect with jittered exponential backoff until it succeeds or Close is called
+func (r *RabbitMQ) reconnect() {
+	for attempt := 1; ; attempt++ {
+		delay := r.backoff.Delay(attempt)
+		log.Printf("Reconnecting to RabbitMQ in %s (attempt %d)", delay.Round(time.Millisecond), attempt)
+
+		select {
+		case <-r.done:
+			return
+		case <-time.After(delay):
+		}
+
+		if err := r.connect(); err != nil {
+			log.Printf("Reconnect attempt %d failed: %v", attempt, err)
+			continue
+		}
+
+		log.Printf("Reconnected to RabbitMQ after %d attempt(s)", attempt)
+		return
+	}
+}
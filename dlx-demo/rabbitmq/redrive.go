@@ -0,0 +1,212 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ErrInvalidRedrive is returned when redrive options are out of range
+var ErrInvalidRedrive = errors.New("invalid redrive request")
+
+// Redrive outcomes of a single DLQ message
+const (
+	RedriveMoved   = "moved"
+	RedriveSkipped = "skipped"
+	RedriveFailed  = "failed"
+)
+
+// RedriveOptions selects which DLQ messages are moved and where.
+// Every filter that is set must match for a message to be moved.
+type RedriveOptions struct {
+	// MaxMessages caps how many messages are moved; zero moves every match
+	MaxMessages int `json:"max_messages,omitempty"`
+
+	// Queue receives the messages; by default each goes back to the queue
+	// it was dead-lettered from (its latest x-death record)
+	Queue string `json:"queue,omitempty"`
+
+	// Headers must all be present with the same value (compared as text)
+	Headers map[string]interface{} `json:"headers,omitempty"`
+
+	// BodyContains must be a substring of the body
+	BodyContains string `json:"body_contains,omitempty"`
+
+	// Reason must be the reason of the latest dead-lettering
+	Reason DeathReason `json:"reason,omitempty"`
+
+	// DryRun reports what would be moved and leaves the DLQ untouched
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// Validate checks the count and the death reason
+func (o RedriveOptions) Validate() error {
+	if o.MaxMessages < 0 {
+		return fmt.Errorf("%w: max_messages must not be negative", ErrInvalidRedrive)
+	}
+	if o.Reason != "" {
+		known := false
+		for _, reason := range DeathReasons {
+			known = known || reason == o.Reason
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown death reason %q", ErrInvalidRedrive, o.Reason)
+		}
+	}
+	return nil
+}
+
+// matches applies the filters to a DLQ message
+func (o RedriveOptions) matches(d *Delivery) bool {
+	if o.BodyContains != "" && !strings.Contains(d.Body, o.BodyContains) {
+		return false
+	}
+	if o.Reason != "" && (len(d.Deaths) == 0 || d.Deaths[0].Reason != o.Reason) {
+		return false
+	}
+	for name, want := range o.Headers {
+		got, ok := d.Headers[name]
+		if !ok || fmt.Sprint(got) != fmt.Sprint(want) {
+			return false
+		}
+	}
+	return true
+}
+
+// plan decides what happens to a DLQ message: skipped when it does not match,
+// otherwise moved to the requested queue or back to its original one
+func (o RedriveOptions) plan(d *Delivery, dlqName string) RedriveItem {
+	item := RedriveItem{MessageID: d.MessageID, Action: RedriveSkipped}
+	if len(d.Deaths) > 0 {
+		item.Reason = d.Deaths[0].Reason
+	}
+	if !o.matches(d) {
+		return item
+	}
+
+	item.Queue = o.Queue
+	if item.Queue == "" && len(d.Deaths) > 0 {
+		item.Queue = d.Deaths[0].Queue
+	}
+	switch {
+	case item.Queue == "":
+		item.Action = RedriveFailed
+		item.Error = "message has no x-death record to find its original queue; name a queue"
+	case item.Queue == dlqName:
+		item.Action = RedriveFailed
+		item.Error = "cannot redrive into the DLQ itself"
+	default:
+		item.Action = RedriveMoved
+	}
+	return item
+}
+
+// RedriveItem is what happened to one DLQ message
+type RedriveItem struct {
+	MessageID string      `json:"message_id,omitempty"`
+	Action    string      `json:"action"` // RedriveMoved, RedriveSkipped or RedriveFailed
+	Queue     string      `json:"queue,omitempty"`
+	Reason    DeathReason `json:"reason,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// RedriveResult reports a redrive. In a dry run Moved counts the messages
+// that would have been moved.
+type RedriveResult struct {
+	DryRun   bool          `json:"dry_run"`
+	Moved    int           `json:"moved"`
+	Skipped  int           `json:"skipped"`
+	Failed   int           `json:"failed"`
+	Messages []RedriveItem `json:"messages"`
+}
+
+func (r *RedriveResult) add(item RedriveItem) {
+	switch item.Action {
+	case RedriveMoved:
+		r.Moved++
+	case RedriveSkipped:
+		r.Skipped++
+	case RedriveFailed:
+		r.Failed++
+	}
+	r.Messages = append(r.Messages, item)
+}
+
+// Redrive moves matching DLQ messages back to their original queue or to opts.Queue.
+// Each message is republished, properties and x-death included, and acked on the
+// DLQ only once the broker confirms the copy, so a failure can duplicate it but
+// never lose it. Messages left behind keep their place in the DLQ.
+func (r *RabbitMQ) Redrive(opts RedriveOptions) (*RedriveResult, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	pool, ch, err := r.borrowConsume(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.put(ch)
+
+	// Only the messages present now are scanned, so requeued ones are not seen twice
+	q, err := ch.QueueDeclarePassive(r.DLQName, true, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect queue %s: %w", r.DLQName, err)
+	}
+
+	// Messages that stay in the DLQ are held unacked until the scan ends,
+	// then returned all together
+	var held []uint64
+	defer func() {
+		for _, tag := range held {
+			ch.Nack(tag, false, true)
+		}
+	}()
+
+	result := &RedriveResult{DryRun: opts.DryRun, Messages: []RedriveItem{}}
+	for scanned := 0; scanned < q.Messages; scanned++ {
+		if opts.MaxMessages > 0 && result.Moved >= opts.MaxMessages {
+			break
+		}
+
+		msg, ok, err := ch.Get(
+			r.DLQName, // queue
+			false,     // auto-ack
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from %s: %w", r.DLQName, err)
+		}
+		if !ok {
+			break
+		}
+
+		item := opts.plan(newDelivery(msg), r.DLQName)
+		if item.Action != RedriveMoved || opts.DryRun {
+			held = append(held, msg.DeliveryTag)
+			result.add(item)
+			continue
+		}
+
+		if err := r.publish(republishing(msg), "", item.Queue); err != nil {
+			held = append(held, msg.DeliveryTag)
+			item.Action, item.Error = RedriveFailed, err.Error()
+			result.add(item)
+			continue
+		}
+		if err := ch.Ack(msg.DeliveryTag, false); err != nil {
+			// The copy is already in the target queue; the original returns to the DLQ
+			item.Action, item.Error = RedriveFailed, fmt.Sprintf("moved but not removed from the DLQ: %v", err)
+			result.add(item)
+			continue
+		}
+		result.add(item)
+	}
+
+	log.Printf("DLQ redrive (dry run: %t): %d moved, %d skipped, %d failed",
+		result.DryRun, result.Moved, result.Skipped, result.Failed)
+	return result, nil
+}
@@ -0,0 +1,313 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// ErrRetriesDisabled is returned by the retry endpoints when no retry delays are configured
+	ErrRetriesDisabled = errors.New("delayed retries are not enabled")
+
+	// ErrParkingLotEmpty is returned when the parking-lot queue is empty
+	ErrParkingLotEmpty = errors.New("no messages available in parking lot")
+)
+
+// Actions reported in a RejectResult
+const (
+	RejectDeadLettered = "dead-lettered" // sent to the DLQ through the DLX
+	RejectRetry        = "retry"         // waiting in a retry tier
+	RejectParked       = "parked"        // retries exhausted, moved to the parking lot
+)
+
+// RetryTier is one delay step. Its wait queue has no consumers: messages sit there
+// for Delay (x-message-ttl) and are then dead-lettered back to the main queue.
+type RetryTier struct {
+	Delay      time.Duration `json:"-"`
+	Name       string        `json:"delay"`
+	Queue      string        `json:"queue"`
+	RoutingKey string        `json:"routing_key"`
+}
+
+// RetryPolicy sends a rejected message through increasingly long wait queues
+// before parking it for good. The number of retries a message already had is
+// read from the x-death records left by the wait queues.
+type RetryPolicy struct {
+	QueueName  string
+	Exchange   string
+	Tiers      []RetryTier
+	ParkingLot string
+}
+
+// NewRetryPolicy builds the retry tiers of queueName, one per delay, in order
+func NewRetryPolicy(queueName string, delays []time.Duration) (*RetryPolicy, error) {
+	if len(delays) == 0 {
+		return nil, errors.New("at least one retry delay is required")
+	}
+
+	p := &RetryPolicy{
+		QueueName:  queueName,
+		Exchange:   queueName + ".retry",
+		ParkingLot: queueName + ".parking-lot",
+	}
+	seen := make(map[string]bool)
+	for _, delay := range delays {
+		if delay < time.Millisecond {
+			return nil, fmt.Errorf("retry delay %s must be at least 1ms", delay)
+		}
+		name := tierName(delay)
+		if seen[name] {
+			return nil, fmt.Errorf("retry delay %s is listed twice", name)
+		}
+		seen[name] = true
+		p.Tiers = append(p.Tiers, RetryTier{
+			Delay:      delay,
+			Name:       name,
+			Queue:      p.Exchange + "." + name,
+			RoutingKey: name,
+		})
+	}
+	return p, nil
+}
+
+// ParseRetryDelays parses a comma-separated list of durations such as "5s,30s,5m"
+func ParseRetryDelays(list string) ([]time.Duration, error) {
+	var delays []time.Duration
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		delay, err := time.ParseDuration(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry delay %q: %w", item, err)
+		}
+		delays = append(delays, delay)
+	}
+	return delays, nil
+}
+
+// tierName formats a delay the short way people write it: 5s, 30s, 5m, 1h, 250ms
+func tierName(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	default:
+		return fmt.Sprintf("%dms", d/time.Millisecond)
+	}
+}
+
+// Topology declares the retry exchange, one wait queue per tier and the parking lot
+func (p *RetryPolicy) Topology() *Topology {
+	t := &Topology{
+		Exchanges: []ExchangeSpec{{Name: p.Exchange, Type: amqp.ExchangeDirect}},
+	}
+	for _, tier := range p.Tiers {
+		t.Queues = append(t.Queues, QueueSpec{
+			Name: tier.Queue,
+			Arguments: map[string]interface{}{
+				"x-message-ttl": tier.Delay.Milliseconds(),
+				// Expired messages go back to the main queue through the default exchange
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": p.QueueName,
+			},
+		})
+		t.Bindings = append(t.Bindings, BindingSpec{Source: p.Exchange, Destination: tier.Queue, RoutingKey: tier.RoutingKey})
+	}
+	t.Queues = append(t.Queues, QueueSpec{Name: p.ParkingLot})
+	t.Bindings = append(t.Bindings, BindingSpec{Source: p.Exchange, Destination: p.ParkingLot, RoutingKey: p.ParkingLot})
+	return t
+}
+
+// Retries counts how many retry tiers the message has already been through
+func (p *RetryPolicy) Retries(headers amqp.Table) int {
+	retries := 0
+	for _, death := range parseDeaths(headers) {
+		if death.Reason != DeathExpired {
+			continue
+		}
+		for _, tier := range p.Tiers {
+			if death.Queue == tier.Queue {
+				retries += int(death.Count)
+			}
+		}
+	}
+	return retries
+}
+
+// next decides where a rejected message goes: the tier for its next retry,
+// or nil once every tier has been used and the message must be parked
+func (p *RetryPolicy) next(headers amqp.Table) (*RetryTier, int) {
+	retries := p.Retries(headers)
+	if retries >= len(p.Tiers) {
+		return nil, retries
+	}
+	return &p.Tiers[retries], retries + 1
+}
+
+// result describes the step taken for a message sent to tier (parked when nil)
+func (p *RetryPolicy) result(delivery *Delivery, tier *RetryTier, attempt int) *RejectResult {
+	if tier == nil {
+		return &RejectResult{
+			Action:     RejectParked,
+			Queue:      p.ParkingLot,
+			Attempt:    attempt,
+			MaxRetries: len(p.Tiers),
+			Delivery:   delivery,
+		}
+	}
+	return &RejectResult{
+		Action:     RejectRetry,
+		Queue:      tier.Queue,
+		Attempt:    attempt,
+		MaxRetries: len(p.Tiers),
+		Delay:      tier.Name,
+		Delivery:   delivery,
+	}
+}
+
+// RejectResult reports what RejectMessage did with a message
+type RejectResult struct {
+	Action string `json:"action"` // RejectDeadLettered, RejectRetry or RejectParked
+	Queue  string `json:"queue"`  // where the message went
+
+	// Attempt is the retry being scheduled (1 for the first), or the retries
+	// used once parked; MaxRetries is the number of tiers
+	Attempt    int    `json:"attempt,omitempty"`
+	MaxRetries int    `json:"max_retries,omitempty"`
+	Delay      string `json:"delay,omitempty"`
+
+	Delivery *Delivery `json:"-"` // the rejected message, as received
+}
+
+// RetryStatus shows the messages waiting in every retry tier and in the parking lot
+type RetryStatus struct {
+	Enabled    bool              `json:"enabled"`
+	Exchange   string            `json:"exchange,omitempty"`
+	Tiers      []RetryTierStatus `json:"tiers,omitempty"`
+	ParkingLot *QueueStatus      `json:"parking_lot,omitempty"`
+}
+
+// RetryTierStatus is a retry tier with the number of messages waiting in it
+type RetryTierStatus struct {
+	RetryTier
+	Messages int `json:"messages"`
+}
+
+// QueueStatus is a queue with its ready message count
+type QueueStatus struct {
+	Queue    string `json:"queue"`
+	Messages int    `json:"messages"`
+}
+
+// status builds a RetryStatus from a function returning each queue's message count
+func (p *RetryPolicy) status(count func(queue string) (int, error)) (*RetryStatus, error) {
+	if p == nil {
+		return &RetryStatus{Enabled: false}, nil
+	}
+
+	status := &RetryStatus{Enabled: true, Exchange: p.Exchange}
+	for _, tier := range p.Tiers {
+		n, err := count(tier.Queue)
+		if err != nil {
+			return nil, err
+		}
+		status.Tiers = append(status.Tiers, RetryTierStatus{RetryTier: tier, Messages: n})
+	}
+	n, err := count(p.ParkingLot)
+	if err != nil {
+		return nil, err
+	}
+	status.ParkingLot = &QueueStatus{Queue: p.ParkingLot, Messages: n}
+	return status, nil
+}
+
+// retryMessage takes a message from the main queue and publishes a copy, with its
+// headers and x-death history, to its next retry tier or the parking lot.
+// The original is acked only once the copy is confirmed, so a failure can
+// duplicate the message but never lose it.
+func (r *RabbitMQ) retryMessage() (*RejectResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	pool, ch, err := r.borrowConsume(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.put(ch)
+
+	msg, ok, err := ch.Get(
+		r.QueueName, // queue
+		false,       // auto-ack
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume message: %w", err)
+	}
+	if !ok {
+		return nil, ErrNoMessages
+	}
+
+	tier, attempt := r.retries.next(msg.Headers)
+	routingKey := r.retries.ParkingLot
+	if tier != nil {
+		routingKey = tier.RoutingKey
+	}
+
+	if err := r.publish(republishing(msg), r.retries.Exchange, routingKey); err != nil {
+		ch.Nack(msg.DeliveryTag, false, true)
+		return nil, fmt.Errorf("failed to move message to %s: %w", routingKey, err)
+	}
+	if err := ch.Ack(msg.DeliveryTag, false); err != nil {
+		return nil, fmt.Errorf("failed to ack message: %w", err)
+	}
+
+	result := r.retries.result(newDelivery(msg), tier, attempt)
+	if tier != nil {
+		log.Printf("Message scheduled for retry %d/%d in %s: %s", attempt, len(r.retries.Tiers), tier.Name, msg.Body)
+	} else {
+		log.Printf("Retries exhausted, message parked in %s: %s", r.retries.ParkingLot, msg.Body)
+	}
+	return result, nil
+}
+
+// RetryStatus reports the messages waiting in each retry tier and in the parking lot
+func (r *RabbitMQ) RetryStatus() (*RetryStatus, error) {
+	if r.retries == nil {
+		return r.retries.status(nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	pool, ch, err := r.borrowConsume(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.put(ch)
+
+	return r.retries.status(func(queue string) (int, error) {
+		q, err := ch.QueueDeclarePassive(queue, true, false, false, false, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to inspect queue %s: %w", queue, err)
+		}
+		return q.Messages, nil
+	})
+}
+
+// ConsumeFromParkingLot consumes a message whose retries ran out
+func (r *RabbitMQ) ConsumeFromParkingLot() (*Delivery, error) {
+	if r.retries == nil {
+		return nil, ErrRetriesDisabled
+	}
+	return r.consumeFrom(r.retries.ParkingLot, ErrParkingLotEmpty)
+}
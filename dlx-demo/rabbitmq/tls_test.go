@@ -0,0 +1,288 @@
+package rabbitmq
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPKI is a CA with a server certificate for 127.0.0.1 and a client
+// certificate, written as PEM files the way generate-certs.sh lays them out
+type testPKI struct {
+	dir        string
+	caFile     string
+	serverCert tls.Certificate
+	clientCert string
+	clientKey  string
+	pool       *x509.CertPool
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey := newKey(t)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(serial int64, name string, usage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey) {
+		key := newKey(t)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der, key
+	}
+
+	p := &testPKI{dir: dir, pool: x509.NewCertPool()}
+	p.pool.AddCert(ca)
+	p.caFile = writePEM(t, dir, "ca_certificate.pem", "CERTIFICATE", caDER)
+
+	serverDER, serverKey := issue(2, "127.0.0.1", x509.ExtKeyUsageServerAuth)
+	p.serverCert = tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}
+
+	clientDER, clientKey := issue(3, "client", x509.ExtKeyUsageClientAuth)
+	p.clientCert = writePEM(t, dir, "client_certificate.pem", "CERTIFICATE", clientDER)
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.clientKey = writePEM(t, dir, "client_key.pem", "EC PRIVATE KEY", keyDER)
+	return p
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// startTLSBroker listens on 127.0.0.1 with tls.Listen and answers the AMQP
+// connection handshake, which is all dial needs to succeed
+func startTLSBroker(t *testing.T, pki *testPKI, requireClientCert bool) string {
+	t.Helper()
+	cfg := &tls.Config{Certificates: []tls.Certificate{pki.serverCert}}
+	if requireClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.ClientCAs = pki.pool
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveAMQPHandshake(conn)
+		}
+	}()
+	return fmt.Sprintf("amqps://guest:guest@%s/", ln.Addr())
+}
+
+// serveAMQPHandshake runs connection.start/tune/open and answers connection.close
+func serveAMQPHandshake(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return
+	}
+
+	// connection.start: version 0-9, no server properties, PLAIN, en_US
+	start := []byte{0, 9, 0, 0, 0, 0}
+	start = appendLongString(start, "PLAIN")
+	start = appendLongString(start, "en_US")
+	writeMethod(conn, 10, 10, start)
+	readMethod(r, 10, 11) // start-ok
+
+	// connection.tune: no channel limit, 128 KiB frames, no heartbeat
+	writeMethod(conn, 10, 30, []byte{0, 0, 0, 2, 0, 0, 0, 0})
+	readMethod(r, 10, 31) // tune-ok
+	readMethod(r, 10, 40) // open
+	writeMethod(conn, 10, 41, []byte{0})
+
+	if readMethod(r, 10, 50) { // close
+		writeMethod(conn, 10, 51, nil)
+	}
+}
+
+func appendLongString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func writeMethod(w io.Writer, class, method uint16, args []byte) {
+	payload := binary.BigEndian.AppendUint16(nil, class)
+	payload = binary.BigEndian.AppendUint16(payload, method)
+	payload = append(payload, args...)
+
+	frame := []byte{1, 0, 0} // method frame on channel 0
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(payload)))
+	frame = append(frame, payload...)
+	w.Write(append(frame, 0xCE))
+}
+
+// readMethod skips frames until the given method arrives and reports whether it did
+func readMethod(r *bufio.Reader, class, method uint16) bool {
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return false
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[3:])+1)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return false
+		}
+		if header[0] == 1 && len(body) >= 4 &&
+			binary.BigEndian.Uint16(body) == class && binary.BigEndian.Uint16(body[2:]) == method {
+			return true
+		}
+	}
+}
+
+func TestTLSDialWithCA(t *testing.T) {
+	pki := newTestPKI(t)
+	url := startTLSBroker(t, pki, false)
+
+	cfg, err := (&TLSConfig{CAFile: pki.caFile}).Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	conn, err := dial(url, cfg)
+	if err != nil {
+		t.Fatalf("dial with the CA: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestTLSDialWithoutCA(t *testing.T) {
+	pki := newTestPKI(t)
+	url := startTLSBroker(t, pki, false)
+
+	// The system roots do not trust the test CA
+	cfg, err := (&TLSConfig{}).Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	conn, err := dial(url, cfg)
+	if err == nil {
+		conn.Close()
+		t.Fatal("dial succeeded without the CA")
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	if !errors.As(err, &unknownAuthority) {
+		t.Errorf("dial error = %v, want an unknown authority error", err)
+	}
+}
+
+func TestTLSDialMutual(t *testing.T) {
+	pki := newTestPKI(t)
+	url := startTLSBroker(t, pki, true)
+
+	t.Run("without client certificate", func(t *testing.T) {
+		cfg, err := (&TLSConfig{CAFile: pki.caFile}).Build()
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		if conn, err := dial(url, cfg); err == nil {
+			conn.Close()
+			t.Fatal("dial succeeded without a client certificate")
+		}
+	})
+
+	t.Run("with client certificate", func(t *testing.T) {
+		cfg, err := (&TLSConfig{CAFile: pki.caFile, CertFile: pki.clientCert, KeyFile: pki.clientKey}).Build()
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		conn, err := dial(url, cfg)
+		if err != nil {
+			t.Fatalf("dial with a client certificate: %v", err)
+		}
+		conn.Close()
+	})
+}
+
+func TestTLSBuildErrors(t *testing.T) {
+	pki := newTestPKI(t)
+	empty := filepath.Join(pki.dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  TLSConfig
+	}{
+		{"missing CA file", TLSConfig{CAFile: filepath.Join(pki.dir, "missing.pem")}},
+		{"CA file without certificates", TLSConfig{CAFile: empty}},
+		{"certificate without key", TLSConfig{CertFile: pki.clientCert}},
+		{"unsupported min version", TLSConfig{MinVersion: "1.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.cfg.Build(); err == nil {
+				t.Error("Build succeeded")
+			}
+		})
+	}
+}
@@ -101,7 +101,7 @@ Write-Host "Check RabbitMQ Management UI:" -ForegroundColor Yellow
 Write-Host "  URL: http://localhost:15672"
 Write-Host "  User: guest / Password: guest"
 Write-Host "  You should see:"
-Write-Host "    - Queue 'messages' (main queue with DLX configured)"
-Write-Host "    - Queue 'messages.dlq' (Dead Letter Queue)"
+Write-Host "    - Queue 'messages-dlx' (main queue with DLX configured)"
+Write-Host "    - Queue 'messages-dlx.dlq' (Dead Letter Queue)"
 Write-Host "    - Exchange 'dlx.exchange' (Dead Letter Exchange)"
 Write-Host ""
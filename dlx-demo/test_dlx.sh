@@ -109,7 +109,7 @@ echo -e "${YELLOW}Check RabbitMQ Management UI:${NC}"
 echo -e "  URL: http://localhost:15672"
 echo -e "  User: guest / Password: guest"
 echo -e "  You should see:"
-echo -e "    - Queue 'messages' (main queue with DLX configured)"
-echo -e "    - Queue 'messages.dlq' (Dead Letter Queue)"
+echo -e "    - Queue 'messages-dlx' (main queue with DLX configured)"
+echo -e "    - Queue 'messages-dlx.dlq' (Dead Letter Queue)"
 echo -e "    - Exchange 'dlx.exchange' (Dead Letter Exchange)"
 echo ""
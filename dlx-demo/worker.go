@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"rabbitmq-dlx-demo/rabbitmq"
+	"strings"
+)
+
+// startWorker processes the service queue in the background when concurrency is positive.
+// The returned channel is closed once the worker has stopped after ctx is canceled.
+func startWorker(ctx context.Context, rmq *rabbitmq.RabbitMQ, queueName string, concurrency, prefetch int) <-chan struct{} {
+	done := make(chan struct{})
+	if concurrency <= 0 {
+		close(done)
+		return done
+	}
+
+	worker := rabbitmq.NewWorker(rmq, rabbitmq.WorkerConfig{})
+	if err := worker.Handle(queueName, processMessage, rabbitmq.HandlerOptions{
+		Concurrency: concurrency,
+		Prefetch:    prefetch,
+	}); err != nil {
+		log.Fatalf("Failed to register worker handler: %v", err)
+	}
+
+	go func() {
+		defer close(done)
+		if err := worker.Run(ctx); err != nil {
+			log.Printf("Worker failed: %v", err)
+		}
+	}()
+	return done
+}
+
+// processMessage is the example handler run by the worker. Messages whose body
+// contains "fail" fail permanently and are dead-lettered to the DLQ.
+func processMessage(ctx context.Context, d rabbitmq.Delivery) error {
+	if strings.Contains(d.Body, "fail") {
+		return rabbitmq.Permanent(fmt.Errorf("cannot process message %s", d.MessageID))
+	}
+	log.Printf("Worker processed message %s: %s", d.MessageID, d.Body)
+	return nil
+}
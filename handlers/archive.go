@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"rabbitmq-service/rabbitmq"
+	"strconv"
+)
+
+// ImportResponse reports how many archive records were imported
+type ImportResponse struct {
+	Status  string                 `json:"status"`
+	Message string                 `json:"message"`
+	Result  *rabbitmq.ImportResult `json:"result"`
+}
+
+// ExportHandler handles GET requests that download a queue as an NDJSON archive.
+// The number of exported messages is sent in the X-Export-Count trailer.
+func (h *Handler) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts := rabbitmq.ExportOptions{
+		Queue: r.URL.Query().Get("queue"),
+		Mode:  rabbitmq.ExportMode(r.URL.Query().Get("mode")),
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "limit must be a number", http.StatusBadRequest)
+			return
+		}
+		opts.Limit = n
+	}
+
+	out := &archiveWriter{w: w, queue: opts.Queue}
+	result, err := h.Broker.Export(out, opts)
+	if err != nil {
+		log.Printf("Error exporting queue: %v", err)
+		if out.started {
+			// Part of the archive is already sent; destructive exports kept every message
+			w.Header().Set("X-Export-Error", err.Error())
+			return
+		}
+		http.Error(w, err.Error(), archiveErrorStatus(err))
+		return
+	}
+
+	out.start()
+	w.Header().Set("X-Export-Count", strconv.Itoa(result.Exported))
+}
+
+// ImportHandler handles POST requests with an NDJSON archive to publish into a queue
+func (h *Handler) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts := rabbitmq.ImportOptions{Queue: r.URL.Query().Get("queue")}
+	if value := r.URL.Query().Get("scan"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "scan must be a number", http.StatusBadRequest)
+			return
+		}
+		opts.Scan = n
+	}
+
+	result, err := h.Broker.Import(r.Body, opts)
+	if err != nil {
+		log.Printf("Error importing archive: %v", err)
+		http.Error(w, err.Error(), archiveErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ImportResponse{
+		Status:  "success",
+		Message: fmt.Sprintf("Imported %d message(s), %d duplicate(s), %d failed", result.Imported, result.Duplicates, result.Failed),
+		Result:  result,
+	})
+}
+
+// archiveErrorStatus maps export and import errors to 400, 404, 503 or 500
+func archiveErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, rabbitmq.ErrInvalidArchive):
+		return http.StatusBadRequest
+	case errors.Is(err, rabbitmq.ErrQueueNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, rabbitmq.ErrNotConnected):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// archiveWriter sends the NDJSON headers with the first record, so an export
+// that fails before writing anything can still answer with an error status
+type archiveWriter struct {
+	w       http.ResponseWriter
+	queue   string
+	started bool
+}
+
+func (a *archiveWriter) start() {
+	if a.started {
+		return
+	}
+	a.started = true
+	filename := a.queue
+	if filename == "" {
+		filename = "export"
+	}
+	a.w.Header().Set("Content-Type", "application/x-ndjson")
+	a.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".ndjson"))
+	a.w.Header().Set("Trailer", "X-Export-Count, X-Export-Error")
+	a.w.WriteHeader(http.StatusOK)
+}
+
+func (a *archiveWriter) Write(p []byte) (int, error) {
+	a.start()
+	return a.w.Write(p)
+}
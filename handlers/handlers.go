@@ -2,28 +2,79 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"rabbitmq-service/rabbitmq"
+	"sync"
+	"time"
 )
 
 type Handler struct {
-	RabbitMQ *rabbitmq.RabbitMQ
+	Broker rabbitmq.Broker
+
+	// Prefetch is the default number of unacknowledged deliveries per stream
+	Prefetch int
+
+	// streams holds the open subscriptions of /stream by stream ID
+	streams sync.Map
 }
 
 type PublishRequest struct {
 	Message string `json:"message"`
+
+	// Exchange and RoutingKey default to the queue through the default exchange
+	Exchange   string `json:"exchange,omitempty"`
+	RoutingKey string `json:"routing_key,omitempty"`
+
+	// AMQP message properties; all optional
+	ContentType     string                 `json:"content_type,omitempty"`
+	ContentEncoding string                 `json:"content_encoding,omitempty"`
+	MessageID       string                 `json:"message_id,omitempty"`
+	CorrelationID   string                 `json:"correlation_id,omitempty"`
+	ReplyTo         string                 `json:"reply_to,omitempty"`
+	Priority        int                    `json:"priority,omitempty"`
+	Expiration      string                 `json:"expiration,omitempty"` // TTL in milliseconds
+	Timestamp       time.Time              `json:"timestamp,omitempty"`
+	Type            string                 `json:"type,omitempty"`
+	AppID           string                 `json:"app_id,omitempty"`
+	Headers         map[string]interface{} `json:"headers,omitempty"`
+}
+
+// message maps the request onto the message to publish
+func (req PublishRequest) message() rabbitmq.Message {
+	return rabbitmq.Message{
+		Body:            req.Message,
+		ContentType:     req.ContentType,
+		ContentEncoding: req.ContentEncoding,
+		MessageID:       req.MessageID,
+		CorrelationID:   req.CorrelationID,
+		ReplyTo:         req.ReplyTo,
+		Priority:        req.Priority,
+		Expiration:      req.Expiration,
+		Timestamp:       req.Timestamp,
+		Type:            req.Type,
+		AppID:           req.AppID,
+		Headers:         req.Headers,
+	}
 }
 
 type PublishResponse struct {
-	Status  string `json:"status"`
-	Message string `json:"message"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	MessageID  string `json:"message_id,omitempty"`
+	Exchange   string `json:"exchange,omitempty"`
+	RoutingKey string `json:"routing_key,omitempty"`
 }
 
 type ConsumeResponse struct {
 	Status  string `json:"status"`
 	Message string `json:"message,omitempty"`
 	Error   string `json:"error,omitempty"`
+
+	// Delivery carries the properties and delivery metadata of the consumed message
+	Delivery *rabbitmq.Delivery `json:"delivery,omitempty"`
 }
 
 // PublishHandler handles POST requests to publish messages
@@ -45,17 +96,53 @@ func (h *Handler) PublishHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Publish message to RabbitMQ
-	if err := h.RabbitMQ.PublishMessage(req.Message); err != nil {
+	messageID, err := h.Broker.PublishMessage(req.message(), rabbitmq.PublishOptions{
+		Exchange:   req.Exchange,
+		RoutingKey: req.RoutingKey,
+	})
+	if err != nil {
 		log.Printf("Error publishing message: %v", err)
-		http.Error(w, "Failed to publish message", http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, rabbitmq.ErrUnroutable):
+			// The broker returned the mandatory message: no queue is bound for it
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(PublishResponse{
+				Status:     "unroutable",
+				Message:    err.Error(),
+				Exchange:   req.Exchange,
+				RoutingKey: req.RoutingKey,
+			})
+		case errors.Is(err, rabbitmq.ErrQueueFull):
+			// The queue is at its length limit with x-overflow reject-publish
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(PublishResponse{
+				Status:     "queue_full",
+				Message:    err.Error(),
+				Exchange:   req.Exchange,
+				RoutingKey: req.RoutingKey,
+			})
+		case errors.Is(err, rabbitmq.ErrInvalidMessage):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, rabbitmq.ErrExchangeNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, rabbitmq.ErrNotConnected):
+			http.Error(w, "RabbitMQ is reconnecting, try again later", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "Failed to publish message", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	log.Printf("Published message: %s", req.Message)
+	log.Printf("Published message %s: %s", messageID, req.Message)
 
 	response := PublishResponse{
-		Status:  "success",
-		Message: "Message published successfully",
+		Status:     "success",
+		Message:    "Message published successfully",
+		MessageID:  messageID,
+		Exchange:   req.Exchange,
+		RoutingKey: req.RoutingKey,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -63,6 +150,166 @@ func (h *Handler) PublishHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// PublishBatchRequest is the body of POST /publish/batch
+type PublishBatchRequest struct {
+	// Mode is best-effort (the default) or all-or-nothing
+	Mode     string           `json:"mode,omitempty"`
+	Messages []PublishRequest `json:"messages"`
+}
+
+type PublishBatchResponse struct {
+	Status  string                        `json:"status"`
+	Message string                        `json:"message"`
+	Mode    rabbitmq.BatchMode            `json:"mode"`
+	Acked   int                           `json:"acked"`
+	Failed  int                           `json:"failed"`
+	Results []rabbitmq.BatchMessageResult `json:"results"`
+}
+
+// PublishBatchHandler handles POST requests to publish several messages at once.
+// It answers 200 when every message was acked, 207 with the status of each message
+// when some failed, and 409 when an all-or-nothing batch was rolled back.
+func (h *Handler) PublishBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PublishBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mode := rabbitmq.BatchMode(req.Mode)
+	if mode == "" {
+		mode = rabbitmq.BatchBestEffort
+	}
+
+	batch := make([]rabbitmq.BatchMessage, len(req.Messages))
+	for i, msg := range req.Messages {
+		if msg.Message == "" {
+			http.Error(w, fmt.Sprintf("Message %d cannot be empty", i), http.StatusBadRequest)
+			return
+		}
+		batch[i] = rabbitmq.BatchMessage{
+			Message: msg.message(),
+			Options: rabbitmq.PublishOptions{Exchange: msg.Exchange, RoutingKey: msg.RoutingKey},
+		}
+	}
+
+	result, err := h.Broker.PublishBatch(batch, mode)
+	if err != nil {
+		log.Printf("Error publishing batch: %v", err)
+		switch {
+		case errors.Is(err, rabbitmq.ErrInvalidBatch), errors.Is(err, rabbitmq.ErrInvalidMessage):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, rabbitmq.ErrNotConnected):
+			http.Error(w, "RabbitMQ is reconnecting, try again later", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "Failed to publish batch", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	status, code := "success", http.StatusOK
+	message := fmt.Sprintf("%d message(s) published", result.Acked)
+	switch {
+	case result.Failed == 0:
+	case result.RolledBack:
+		status, code = "rolled_back", http.StatusConflict
+		message = "Transaction rolled back, no message was published"
+	default:
+		status, code = "partial", http.StatusMultiStatus
+		message = fmt.Sprintf("%d of %d message(s) published", result.Acked, len(result.Results))
+	}
+	log.Printf("Published batch (%s): %d acked, %d failed", mode, result.Acked, result.Failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(PublishBatchResponse{
+		Status:  status,
+		Message: message,
+		Mode:    result.Mode,
+		Acked:   result.Acked,
+		Failed:  result.Failed,
+		Results: result.Results,
+	})
+}
+
+// DeclareExchangeHandler handles POST requests to declare a direct, fanout, topic or headers exchange
+func (h *Handler) DeclareExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var spec rabbitmq.ExchangeSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Broker.DeclareExchange(spec); err != nil {
+		log.Printf("Error declaring exchange: %v", err)
+		http.Error(w, err.Error(), topologyErrorStatus(err))
+		return
+	}
+
+	log.Printf("Declared %s exchange: %s", spec.Type, spec.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(PublishResponse{
+		Status:  "success",
+		Message: "Exchange declared: " + spec.Name,
+	})
+}
+
+// BindHandler handles POST requests to bind a queue or exchange to an exchange
+func (h *Handler) BindHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var spec rabbitmq.BindingSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Broker.Bind(spec); err != nil {
+		log.Printf("Error binding: %v", err)
+		http.Error(w, err.Error(), topologyErrorStatus(err))
+		return
+	}
+
+	log.Printf("Bound %s to exchange %s with key %q", spec.Destination, spec.Source, spec.RoutingKey)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(PublishResponse{
+		Status:  "success",
+		Message: "Bound " + spec.Destination + " to exchange " + spec.Source,
+	})
+}
+
+// topologyErrorStatus maps declaration errors to 400 (invalid), 409 (conflicts with the broker) or 503
+func topologyErrorStatus(err error) int {
+	var conflict *rabbitmq.TopologyError
+	switch {
+	case errors.Is(err, rabbitmq.ErrInvalidTopology):
+		return http.StatusBadRequest
+	case errors.As(err, &conflict):
+		return http.StatusConflict
+	case errors.Is(err, rabbitmq.ErrNotConnected):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // ConsumeHandler handles GET requests to consume messages
 func (h *Handler) ConsumeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -71,24 +318,29 @@ func (h *Handler) ConsumeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Consume message from RabbitMQ
-	message, err := h.RabbitMQ.ConsumeMessage()
+	delivery, err := h.Broker.ConsumeMessage()
 	if err != nil {
 		log.Printf("Error consuming message: %v", err)
 		response := ConsumeResponse{
 			Status: "error",
 			Error:  err.Error(),
 		}
+		code := http.StatusOK
+		if errors.Is(err, rabbitmq.ErrNotConnected) {
+			code = http.StatusServiceUnavailable
+		}
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
+		w.WriteHeader(code)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	log.Printf("Consumed message: %s", message)
+	log.Printf("Consumed message: %s", delivery.Body)
 
 	response := ConsumeResponse{
-		Status:  "success",
-		Message: message,
+		Status:   "success",
+		Message:  delivery.Body,
+		Delivery: delivery,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"rabbitmq-service/rabbitmq"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testQueue = "messages"
+
+func newTestHandler(t *testing.T) (*Handler, *rabbitmq.MemoryBroker) {
+	t.Helper()
+	broker := rabbitmq.NewMemoryBroker(testQueue)
+	return &Handler{Broker: broker, Prefetch: 1}, broker
+}
+
+// serve runs handler on a request with a JSON body and decodes the JSON answer into out
+func serve(t *testing.T, handler http.HandlerFunc, method, target string, body, out interface{}) int {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(method, target, &payload))
+	if out != nil && strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+			t.Fatalf("decoding the %s %s response: %v", method, target, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestPublishAndConsume(t *testing.T) {
+	h, broker := newTestHandler(t)
+
+	var published PublishResponse
+	code := serve(t, h.PublishHandler, http.MethodPost, "/publish",
+		PublishRequest{Message: "hello", MessageID: "msg-1", Headers: map[string]interface{}{"source": "test"}}, &published)
+	if code != http.StatusOK || published.Status != "success" || published.MessageID != "msg-1" {
+		t.Fatalf("publish = %d %+v, want 200 with message ID msg-1", code, published)
+	}
+	if got := broker.Messages(); len(got) != 1 || got[0] != "hello" {
+		t.Fatalf("queue = %v, want [hello]", got)
+	}
+
+	var consumed ConsumeResponse
+	code = serve(t, h.ConsumeHandler, http.MethodGet, "/consume", nil, &consumed)
+	if code != http.StatusOK || consumed.Message != "hello" {
+		t.Fatalf("consume = %d %+v, want 200 with hello", code, consumed)
+	}
+	d := consumed.Delivery
+	if d == nil || d.MessageID != "msg-1" || d.RoutingKey != testQueue || d.Headers["source"] != "test" {
+		t.Errorf("delivery = %+v, want msg-1 routed to %s with its headers", d, testQueue)
+	}
+
+	code = serve(t, h.ConsumeHandler, http.MethodGet, "/consume", nil, &consumed)
+	if code != http.StatusOK || consumed.Status != "error" || consumed.Error != rabbitmq.ErrNoMessages.Error() {
+		t.Errorf("consume on an empty queue = %d %+v, want an error status", code, consumed)
+	}
+}
+
+func TestPublishErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, h *Handler, broker *rabbitmq.MemoryBroker)
+		req    PublishRequest
+		code   int
+		status string
+	}{
+		{
+			name: "empty message",
+			req:  PublishRequest{},
+			code: http.StatusBadRequest,
+		},
+		{
+			name:   "no queue for the routing key",
+			req:    PublishRequest{Message: "lost", RoutingKey: "missing"},
+			code:   http.StatusUnprocessableEntity,
+			status: "unroutable",
+		},
+		{
+			name: "exchange without matching bindings",
+			setup: func(t *testing.T, h *Handler, _ *rabbitmq.MemoryBroker) {
+				if code := serve(t, h.DeclareExchangeHandler, http.MethodPost, "/exchanges",
+					rabbitmq.ExchangeSpec{Name: "events", Type: "direct"}, nil); code != http.StatusCreated {
+					t.Fatalf("declare exchange = %d, want 201", code)
+				}
+			},
+			req:    PublishRequest{Message: "lost", Exchange: "events", RoutingKey: "nobody"},
+			code:   http.StatusUnprocessableEntity,
+			status: "unroutable",
+		},
+		{
+			name: "unknown exchange",
+			req:  PublishRequest{Message: "lost", Exchange: "missing"},
+			code: http.StatusNotFound,
+		},
+		{
+			name: "queue full",
+			setup: func(t *testing.T, _ *Handler, broker *rabbitmq.MemoryBroker) {
+				if err := broker.SetQueueLimits(rabbitmq.QueueLimits{MaxLength: 1, Overflow: rabbitmq.OverflowRejectPublish}); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := broker.PublishMessage(rabbitmq.Message{Body: "first"}, rabbitmq.PublishOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			req:    PublishRequest{Message: "second"},
+			code:   http.StatusTooManyRequests,
+			status: "queue_full",
+		},
+		{
+			name: "not connected",
+			setup: func(t *testing.T, _ *Handler, broker *rabbitmq.MemoryBroker) {
+				broker.SetState(rabbitmq.StateReconnecting)
+			},
+			req:  PublishRequest{Message: "later"},
+			code: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, broker := newTestHandler(t)
+			if tt.setup != nil {
+				tt.setup(t, h, broker)
+			}
+			before := len(broker.Messages())
+
+			var resp PublishResponse
+			code := serve(t, h.PublishHandler, http.MethodPost, "/publish", tt.req, &resp)
+			if code != tt.code || resp.Status != tt.status {
+				t.Errorf("publish = %d %q, want %d %q", code, resp.Status, tt.code, tt.status)
+			}
+			if got := len(broker.Messages()); got != before {
+				t.Errorf("queue holds %d message(s) after a failed publish, want %d", got, before)
+			}
+		})
+	}
+}
+
+func TestPublishThroughBinding(t *testing.T) {
+	h, broker := newTestHandler(t)
+	if code := serve(t, h.DeclareExchangeHandler, http.MethodPost, "/exchanges",
+		rabbitmq.ExchangeSpec{Name: "events", Type: "topic"}, nil); code != http.StatusCreated {
+		t.Fatalf("declare exchange = %d, want 201", code)
+	}
+	if code := serve(t, h.BindHandler, http.MethodPost, "/bindings",
+		rabbitmq.BindingSpec{Source: "events", Destination: testQueue, RoutingKey: "orders.*"}, nil); code != http.StatusCreated {
+		t.Fatalf("bind = %d, want 201", code)
+	}
+	if code := serve(t, h.DeclareExchangeHandler, http.MethodPost, "/exchanges",
+		rabbitmq.ExchangeSpec{Name: "events", Type: "fanout"}, nil); code != http.StatusConflict {
+		t.Errorf("redeclaring with another type = %d, want 409", code)
+	}
+
+	if code := serve(t, h.PublishHandler, http.MethodPost, "/publish",
+		PublishRequest{Message: "created", Exchange: "events", RoutingKey: "orders.created"}, nil); code != http.StatusOK {
+		t.Fatalf("publish = %d, want 200", code)
+	}
+	if got := broker.Messages(); len(got) != 1 || got[0] != "created" {
+		t.Errorf("queue = %v, want [created]", got)
+	}
+}
+
+func TestPublishBatch(t *testing.T) {
+	batch := func(mode string) PublishBatchRequest {
+		return PublishBatchRequest{Mode: mode, Messages: []PublishRequest{
+			{Message: "one"},
+			{Message: "lost", RoutingKey: "missing"},
+			{Message: "three"},
+		}}
+	}
+
+	t.Run("best-effort", func(t *testing.T) {
+		h, broker := newTestHandler(t)
+		var resp PublishBatchResponse
+		code := serve(t, h.PublishBatchHandler, http.MethodPost, "/publish/batch", batch(""), &resp)
+		if code != http.StatusMultiStatus || resp.Acked != 2 || resp.Failed != 1 {
+			t.Fatalf("batch = %d %+v, want 207 with 2 acked and 1 failed", code, resp)
+		}
+		if resp.Results[1].Status != rabbitmq.BatchReturned {
+			t.Errorf("unroutable message status = %q, want %q", resp.Results[1].Status, rabbitmq.BatchReturned)
+		}
+		if got := broker.Messages(); len(got) != 2 {
+			t.Errorf("queue = %v, want the two routable messages", got)
+		}
+	})
+
+	t.Run("all-or-nothing", func(t *testing.T) {
+		h, broker := newTestHandler(t)
+		var resp PublishBatchResponse
+		code := serve(t, h.PublishBatchHandler, http.MethodPost, "/publish/batch", batch(string(rabbitmq.BatchAllOrNothing)), &resp)
+		if code != http.StatusConflict || resp.Status != "rolled_back" || resp.Acked != 0 {
+			t.Fatalf("batch = %d %+v, want 409 rolled back", code, resp)
+		}
+		if got := broker.Messages(); len(got) != 0 {
+			t.Errorf("queue = %v after a rollback, want it empty", got)
+		}
+	})
+
+	t.Run("duplicate message ID", func(t *testing.T) {
+		h, broker := newTestHandler(t)
+		dup := PublishBatchRequest{Messages: []PublishRequest{
+			{Message: "one", MessageID: "o-1"},
+			{Message: "two", MessageID: "o-1"},
+		}}
+		if code := serve(t, h.PublishBatchHandler, http.MethodPost, "/publish/batch", dup, nil); code != http.StatusBadRequest {
+			t.Errorf("batch = %d, want 400", code)
+		}
+		if got := broker.Messages(); len(got) != 0 {
+			t.Errorf("queue = %v after an invalid batch, want it empty", got)
+		}
+	})
+}
+
+func TestExportUnknownQueue(t *testing.T) {
+	h, _ := newTestHandler(t)
+	if code := serve(t, h.ExportHandler, http.MethodGet, "/export?queue=missing", nil, nil); code != http.StatusNotFound {
+		t.Errorf("export of an unknown queue = %d, want 404", code)
+	}
+	if code := serve(t, h.ImportHandler, http.MethodPost, "/import?queue=missing", nil, nil); code != http.StatusNotFound {
+		t.Errorf("import into an unknown queue = %d, want 404", code)
+	}
+}
+
+func TestImportDuplicates(t *testing.T) {
+	archive := `{"queue":"messages","message_id":"m-1","body":"hello again"}
+{"queue":"messages","message_id":"m-2","body":"world"}
+{"queue":"messages","message_id":"m-2","body":"world"}
+`
+	importArchive := func(h *Handler, target string) (int, ImportResponse) {
+		var resp ImportResponse
+		rec := httptest.NewRecorder()
+		h.ImportHandler(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(archive)))
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, resp
+	}
+
+	for _, tt := range []struct {
+		target      string
+		imported    int
+		duplicates  int
+		redelivered bool
+	}{
+		// Without a scan only the archive is deduplicated and the queue is not read
+		{target: "/import", imported: 2, duplicates: 1},
+		// A scan finds m-1 in the queue, and requeues it
+		{target: "/import?scan=1", imported: 1, duplicates: 2, redelivered: true},
+	} {
+		h, broker := newTestHandler(t)
+		if _, err := broker.PublishMessage(rabbitmq.Message{Body: "hello", MessageID: "m-1"}, rabbitmq.PublishOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		code, resp := importArchive(h, tt.target)
+		if code != http.StatusOK || resp.Result.Imported != tt.imported || resp.Result.Duplicates != tt.duplicates {
+			t.Fatalf("%s = %d %+v, want %d imported and %d duplicates", tt.target, code, resp.Result, tt.imported, tt.duplicates)
+		}
+		var consumed ConsumeResponse
+		serve(t, h.ConsumeHandler, http.MethodGet, "/consume", nil, &consumed)
+		if d := consumed.Delivery; d == nil || d.MessageID != "m-1" || d.Redelivered != tt.redelivered {
+			t.Errorf("%s: first message = %+v, want m-1 with redelivered %t", tt.target, d, tt.redelivered)
+		}
+	}
+
+	h, _ := newTestHandler(t)
+	if code, _ := importArchive(h, "/import?scan=-1"); code != http.StatusBadRequest {
+		t.Errorf("negative scan = %d, want 400", code)
+	}
+}
+
+// sseEvent is one Server-Sent Event read from /stream
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readEvents forwards the events of an SSE stream until it ends
+func readEvents(resp *http.Response) <-chan sseEvent {
+	events := make(chan sseEvent)
+	go func() {
+		defer close(events)
+		var ev sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				ev.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			case line == "" && ev.name != "":
+				events <- ev
+				ev = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent, name string, out interface{}) {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatalf("stream ended while waiting for a %s event", name)
+		}
+		if ev.name != name {
+			t.Fatalf("got a %s event, want %s", ev.name, name)
+		}
+		if err := json.Unmarshal([]byte(ev.data), out); err != nil {
+			t.Fatalf("decoding the %s event: %v", name, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no %s event", name)
+	}
+}
+
+func noEvent(t *testing.T, events <-chan sseEvent) {
+	t.Helper()
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected %s event: %s", ev.name, ev.data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStreamAck(t *testing.T) {
+	h, broker := newTestHandler(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", h.StreamHandler)
+	mux.HandleFunc("/stream/ack", h.StreamAckHandler)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for _, body := range []string{"first", "second"} {
+		if _, err := broker.PublishMessage(rabbitmq.Message{Body: body}, rabbitmq.PublishOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/stream?prefetch=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream = %d, want 200", resp.StatusCode)
+	}
+	events := readEvents(resp)
+
+	var subscribed struct {
+		StreamID string `json:"stream_id"`
+		Prefetch int    `json:"prefetch"`
+	}
+	nextEvent(t, events, "subscribed", &subscribed)
+	if subscribed.Prefetch != 1 {
+		t.Errorf("prefetch = %d, want 1", subscribed.Prefetch)
+	}
+
+	ack := func(tag uint64) int {
+		body := fmt.Sprintf(`{"stream_id":%q,"delivery_tag":%d}`, subscribed.StreamID, tag)
+		resp, err := http.Post(server.URL+"/stream/ack", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	var first rabbitmq.StreamDelivery
+	nextEvent(t, events, "message", &first)
+	if first.Body != "first" {
+		t.Fatalf("first delivery = %q, want first", first.Body)
+	}
+	// With a prefetch of 1 the second message waits for the ack
+	noEvent(t, events)
+
+	if code := ack(first.DeliveryTag); code != http.StatusOK {
+		t.Fatalf("ack = %d, want 200", code)
+	}
+	if code := ack(first.DeliveryTag); code != http.StatusNotFound {
+		t.Errorf("second ack of the same tag = %d, want 404", code)
+	}
+
+	var second rabbitmq.StreamDelivery
+	nextEvent(t, events, "message", &second)
+	if second.Body != "second" {
+		t.Fatalf("second delivery = %q, want second", second.Body)
+	}
+
+	// Closing the stream requeues the unacknowledged delivery
+	resp.Body.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for got := broker.Messages(); len(got) != 1 || got[0] != "second"; got = broker.Messages() {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue = %v after the stream closed, want [second]", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamAckUnknownStream(t *testing.T) {
+	h, _ := newTestHandler(t)
+	code := serve(t, h.StreamAckHandler, http.MethodPost, "/stream/ack",
+		SettleRequest{StreamID: "missing", DeliveryTag: 1}, nil)
+	if code != http.StatusNotFound {
+		t.Errorf("ack on an unknown stream = %d, want 404", code)
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"rabbitmq-service/rabbitmq"
+	"strconv"
+	"time"
+)
+
+// streamHeartbeat is how often an idle stream sends a comment to keep proxies from closing it
+const streamHeartbeat = 15 * time.Second
+
+// SettleRequest acknowledges or rejects a delivery received on a stream
+type SettleRequest struct {
+	StreamID    string `json:"stream_id"`
+	DeliveryTag uint64 `json:"delivery_tag"`
+
+	// Requeue only applies to /stream/nack
+	Requeue bool `json:"requeue,omitempty"`
+}
+
+// StreamHandler handles GET requests that stream deliveries as Server-Sent Events.
+// The first event carries the stream ID used to ack and nack deliveries;
+// deliveries left unsettled when the client disconnects are requeued.
+func (h *Handler) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	prefetch := h.Prefetch
+	if value := r.URL.Query().Get("prefetch"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > 65535 {
+			http.Error(w, "prefetch must be between 1 and 65535", http.StatusBadRequest)
+			return
+		}
+		prefetch = n
+	}
+
+	sub, err := h.Broker.Subscribe(prefetch)
+	if err != nil {
+		log.Printf("Error subscribing: %v", err)
+		if errors.Is(err, rabbitmq.ErrNotConnected) {
+			http.Error(w, "RabbitMQ is reconnecting, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "Failed to subscribe", http.StatusInternalServerError)
+		return
+	}
+
+	streamID := newStreamID()
+	h.streams.Store(streamID, sub)
+	defer func() {
+		h.streams.Delete(streamID)
+		if err := sub.Close(); err != nil {
+			log.Printf("Error closing stream %s: %v", streamID, err)
+		}
+		log.Printf("Stream %s closed", streamID)
+	}()
+
+	log.Printf("Stream %s opened with prefetch %d", streamID, prefetch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	writeEvent(w, "", "subscribed", map[string]interface{}{
+		"stream_id": streamID,
+		"prefetch":  prefetch,
+	})
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	deliveries := sub.Deliveries()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case d, ok := <-deliveries:
+			if !ok {
+				// The channel was closed by the broker or the connection was lost
+				writeEvent(w, "", "end", map[string]string{"reason": "subscription closed"})
+				flusher.Flush()
+				return
+			}
+			writeEvent(w, strconv.FormatUint(d.DeliveryTag, 10), "message", d)
+			flusher.Flush()
+		}
+	}
+}
+
+// StreamAckHandler handles POST requests to acknowledge a delivery received on a stream
+func (h *Handler) StreamAckHandler(w http.ResponseWriter, r *http.Request) {
+	h.settle(w, r, func(sub rabbitmq.Subscription, req SettleRequest) error {
+		return sub.Ack(req.DeliveryTag)
+	}, "acknowledged")
+}
+
+// StreamNackHandler handles POST requests to reject a delivery received on a stream,
+// requeueing it when requested
+func (h *Handler) StreamNackHandler(w http.ResponseWriter, r *http.Request) {
+	h.settle(w, r, func(sub rabbitmq.Subscription, req SettleRequest) error {
+		return sub.Nack(req.DeliveryTag, req.Requeue)
+	}, "rejected")
+}
+
+func (h *Handler) settle(w http.ResponseWriter, r *http.Request, settle func(rabbitmq.Subscription, SettleRequest) error, action string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SettleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	value, ok := h.streams.Load(req.StreamID)
+	if !ok {
+		http.Error(w, "Unknown stream: "+req.StreamID, http.StatusNotFound)
+		return
+	}
+
+	if err := settle(value.(rabbitmq.Subscription), req); err != nil {
+		log.Printf("Error settling delivery %d on stream %s: %v", req.DeliveryTag, req.StreamID, err)
+		switch {
+		case errors.Is(err, rabbitmq.ErrUnknownDeliveryTag):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, rabbitmq.ErrNotConnected):
+			http.Error(w, "RabbitMQ is reconnecting, try again later", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "Failed to settle delivery", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("Delivery %d on stream %s %s", req.DeliveryTag, req.StreamID, action)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(PublishResponse{
+		Status:  "success",
+		Message: fmt.Sprintf("Delivery %d %s", req.DeliveryTag, action),
+	})
+}
+
+// writeEvent writes one Server-Sent Event with a JSON payload
+func writeEvent(w http.ResponseWriter, id, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", event, err)
+		return
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+}
+
+func newStreamID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
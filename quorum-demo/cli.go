@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"rabbitmq-quorum-demo/management"
+	"rabbitmq-quorum-demo/rabbitmq"
+	"strings"
+	"time"
+)
+
+const commandUsage = `Usage:
+  go run . export [-queue name] [-mode copy|destructive] [-limit n] [-o file]
+  go run . import [-queue name] [-i file] [-scan n]
+  go run . bench [-n count] [-size bytes] [-mode serial|pipelined|both]
+  go run . replicas add|remove -node rabbit@rabbitmq-node3 [-queue name]
+  go run . rebalance
+
+Without -o or -i the archive is written to stdout or read from stdin.
+bench publishes to the service queue and leaves the messages there.
+replicas and rebalance go through the management API (RABBITMQ_MANAGEMENT_URLS).
+The connection settings come from the same environment variables as the service.`
+
+// runCommand runs the command in args against the configured broker and returns
+// the process exit code
+func runCommand(args []string) int {
+	// Membership commands only talk to the management API
+	if args[0] == "replicas" || args[0] == "rebalance" {
+		if err := adminCommand(args, os.Stdout); err != nil {
+			log.Printf("%s failed: %v", args[0], err)
+			return 1
+		}
+		return 0
+	}
+
+	var run func([]string, *rabbitmq.RabbitMQ) error
+	switch args[0] {
+	case "export":
+		run = exportCommand
+	case "import":
+		run = importCommand
+	case "bench":
+		run = benchCommand
+	default:
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return 2
+	}
+
+	// These commands work on queues the service already declared, so the cluster
+	// size check, which only matters when a queue is created, is left to the service
+	cfg, _ := configFromEnv()
+	cfg.ClusterSize = nil
+
+	rmq, err := rabbitmq.NewRabbitMQWithQuorum(cfg)
+	if err != nil {
+		log.Printf("Failed to initialize RabbitMQ with Quorum Queue: %v", err)
+		return 1
+	}
+	defer rmq.Close()
+
+	if err := run(args[1:], rmq); err != nil {
+		log.Printf("%s failed: %v", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// exportCommand writes a queue to an NDJSON archive
+func exportCommand(args []string, rmq *rabbitmq.RabbitMQ) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	queue := flags.String("queue", "", "queue to export (default: the service queue)")
+	mode := flags.String("mode", string(rabbitmq.ExportCopy), "copy leaves the messages in the queue, destructive removes them")
+	limit := flags.Int("limit", 0, "maximum number of messages (0 exports all of them)")
+	output := flags.String("o", "", "archive file (default: stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create archive: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	_, err := rmq.Export(out, rabbitmq.ExportOptions{
+		Queue: *queue,
+		Mode:  rabbitmq.ExportMode(*mode),
+		Limit: *limit,
+	})
+	return err
+}
+
+// importCommand publishes the records of an NDJSON archive
+func importCommand(args []string, rmq *rabbitmq.RabbitMQ) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	queue := flags.String("queue", "", "target queue (default: the queue each record was exported from)")
+	input := flags.String("i", "", "archive file (default: stdin)")
+	scan := flags.Int("scan", 0, "ready messages of the target queue to read for duplicates; they are requeued (default: none)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return fmt.Errorf("failed to open archive: %w", err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	result, err := rmq.Import(in, rabbitmq.ImportOptions{Queue: *queue, Scan: *scan})
+	if err != nil {
+		return err
+	}
+	for _, failure := range result.Errors {
+		log.Printf("Line %d (%s): %s", failure.Line, failure.MessageID, failure.Error)
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("%d record(s) failed", result.Failed)
+	}
+	return nil
+}
+
+// benchCommand measures confirmed publish throughput: serial waits for each confirm
+// before the next publish, pipelined keeps up to the confirm window in flight
+func benchCommand(args []string, rmq *rabbitmq.RabbitMQ) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	count := flags.Int("n", 5000, "messages to publish per mode")
+	size := flags.Int("size", 256, "message body size in bytes")
+	mode := flags.String("mode", "both", "serial, pipelined or both")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *count <= 0 || *size <= 0 {
+		return fmt.Errorf("-n and -size must be positive")
+	}
+
+	var modes []string
+	switch *mode {
+	case "serial", "pipelined":
+		modes = []string{*mode}
+	case "both":
+		modes = []string{"serial", "pipelined"}
+	default:
+		return fmt.Errorf("unknown mode %q (want serial, pipelined or both)", *mode)
+	}
+
+	body := strings.Repeat("x", *size)
+	rates := make(map[string]float64)
+	for _, m := range modes {
+		start := time.Now()
+		if err := benchPublish(rmq, body, *count, m == "pipelined"); err != nil {
+			return fmt.Errorf("%s: %w", m, err)
+		}
+		elapsed := time.Since(start)
+		rates[m] = float64(*count) / elapsed.Seconds()
+		fmt.Printf("%-10s %d messages of %d bytes in %s (%.0f msg/s)\n", m+":", *count, *size, elapsed.Round(time.Millisecond), rates[m])
+	}
+	if len(rates) == 2 {
+		fmt.Printf("speedup:   %.1fx\n", rates["pipelined"]/rates["serial"])
+	}
+	return nil
+}
+
+// benchPublish publishes count confirmed messages, waiting for each confirm
+// right away or, pipelined, only after all of them have been sent
+func benchPublish(rmq *rabbitmq.RabbitMQ, body string, count int, pipelined bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	pending := make([]*rabbitmq.PendingConfirm, 0, count)
+	for i := 0; i < count; i++ {
+		p, err := rmq.PublishAsync(rabbitmq.Message{Body: body})
+		if err != nil {
+			return err
+		}
+		if !pipelined {
+			if err := p.Wait(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		pending = append(pending, p)
+	}
+	for _, p := range pending {
+		if err := p.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adminCommand adds or removes a member of a quorum queue, or rebalances the queue
+// leaders, through the first management node that answers, and reports to out
+func adminCommand(args []string, out io.Writer) error {
+	cfg, clients := configFromEnv()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if args[0] == "rebalance" {
+		err := management.Failover(ctx, clients, func(c *management.Client) error {
+			return c.RebalanceQueues(ctx)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "Queue leader rebalance started")
+		return nil
+	}
+
+	if len(args) < 2 || args[1] != "add" && args[1] != "remove" {
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return fmt.Errorf("want replicas add or replicas remove")
+	}
+	flags := flag.NewFlagSet("replicas "+args[1], flag.ContinueOnError)
+	node := flags.String("node", "", "cluster node of the member, e.g. rabbit@rabbitmq-node3")
+	queue := flags.String("queue", cfg.QueueName, "quorum queue")
+	if err := flags.Parse(args[2:]); err != nil {
+		return err
+	}
+	if *node == "" {
+		return fmt.Errorf("-node is required")
+	}
+
+	vhost := vhostOf(cfg.URLs)
+	var replication *management.Replication
+	err := management.Failover(ctx, clients, func(c *management.Client) (err error) {
+		if args[1] == "add" {
+			replication, err = c.AddMember(ctx, vhost, *queue, *node)
+		} else {
+			replication, err = c.RemoveMember(ctx, vhost, *queue, *node)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "queue:   %s\n", *queue)
+	fmt.Fprintf(out, "leader:  %s\n", replication.Leader)
+	fmt.Fprintf(out, "members: %s\n", strings.Join(replication.Members, ", "))
+	fmt.Fprintf(out, "online:  %s\n", strings.Join(replication.Online, ", "))
+	if replication.Warning != "" {
+		fmt.Fprintf(out, "warning: %s\n", replication.Warning)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"rabbitmq-quorum-demo/management"
+	"rabbitmq-quorum-demo/management/managementtest"
+)
+
+// newAdminEnv points the management settings at a managementtest server
+func newAdminEnv(t *testing.T) *managementtest.Server {
+	t.Helper()
+	server := managementtest.NewServer()
+	t.Cleanup(server.Close)
+	t.Setenv("RABBITMQ_MANAGEMENT_URLS", server.URL)
+	t.Setenv("RABBITMQ_MANAGEMENT_USER", managementtest.Username)
+	t.Setenv("RABBITMQ_MANAGEMENT_PASSWORD", managementtest.Password)
+	t.Setenv("RABBITMQ_QUEUE_NAME", "")
+	t.Setenv("RABBITMQ_URLS", "")
+	t.Setenv("RABBITMQ_URL", "")
+	t.Setenv("TOPOLOGY_FILE", "")
+	t.Setenv("QUEUE_LIMITS", "")
+	return server
+}
+
+func TestReplicasCommand(t *testing.T) {
+	newAdminEnv(t)
+
+	var out bytes.Buffer
+	if err := adminCommand([]string{"replicas", "remove", "-node", "rabbit@rabbitmq-node3"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "members: rabbit@rabbitmq-node1, rabbit@rabbitmq-node2\n") {
+		t.Errorf("remove printed:\n%s\nwant the two remaining members", out.String())
+	}
+
+	out.Reset()
+	if err := adminCommand([]string{"replicas", "add", "-node", "rabbit@rabbitmq-node3", "-queue", "orders-quorum"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "queue:   orders-quorum\n") || !strings.Contains(out.String(), "rabbit@rabbitmq-node3") {
+		t.Errorf("add printed:\n%s\nwant node3 back in orders-quorum", out.String())
+	}
+}
+
+func TestReplicasCommandErrors(t *testing.T) {
+	server := newAdminEnv(t)
+	var out bytes.Buffer
+
+	if err := adminCommand([]string{"replicas", "remove"}, &out); err == nil {
+		t.Error("replicas without -node succeeded")
+	}
+	if err := adminCommand([]string{"replicas", "move", "-node", "rabbit@rabbitmq-node3"}, &out); err == nil {
+		t.Error("replicas move succeeded")
+	}
+
+	err := server.UpdateQueue("/", "orders-quorum", func(q *management.Queue) {
+		q.Online = []string{"rabbit@rabbitmq-node1", "rabbit@rabbitmq-node2"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = adminCommand([]string{"replicas", "remove", "-node", "rabbit@rabbitmq-node1"}, &out)
+	if !errors.Is(err, management.ErrMembershipConflict) {
+		t.Errorf("removal that loses quorum = %v, want ErrMembershipConflict", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("failed commands printed:\n%s", out.String())
+	}
+}
+
+func TestRebalanceCommand(t *testing.T) {
+	server := newAdminEnv(t)
+
+	var out bytes.Buffer
+	if err := adminCommand([]string{"rebalance"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "Queue leader rebalance started\n" {
+		t.Errorf("rebalance printed %q", out.String())
+	}
+	requests := server.Requests()
+	if len(requests) != 1 || requests[0].Method != http.MethodPost || requests[0].Path != "/api/rebalance/queues" {
+		t.Errorf("requests = %+v, want POST /api/rebalance/queues", requests)
+	}
+
+	t.Setenv("RABBITMQ_MANAGEMENT_URLS", "")
+	if err := adminCommand([]string{"rebalance"}, &out); !errors.Is(err, management.ErrNoClients) {
+		t.Errorf("rebalance without a management API = %v, want ErrNoClients", err)
+	}
+}
+
+func TestConfigFromEnvManagementClients(t *testing.T) {
+	newAdminEnv(t)
+
+	cfg, clients := configFromEnv()
+	if len(clients) != 1 || cfg.ClusterSize == nil || cfg.QueueBytes == nil {
+		t.Fatalf("config = %d client(s), cluster size set %t, queue bytes set %t; want both hooks on one client",
+			len(clients), cfg.ClusterSize != nil, cfg.QueueBytes != nil)
+	}
+
+	t.Setenv("RABBITMQ_QUORUM_CHECK_CLUSTER_SIZE", "false")
+	cfg, clients = configFromEnv()
+	if len(clients) != 1 || cfg.ClusterSize != nil || cfg.QueueBytes == nil {
+		t.Errorf("with the check disabled: %d client(s), cluster size set %t; want the clients without the check",
+			len(clients), cfg.ClusterSize != nil)
+	}
+}
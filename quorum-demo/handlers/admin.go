@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"rabbitmq-quorum-demo/management"
+)
+
+// ReplicaRequest names the node of a quorum queue member to add or remove
+type ReplicaRequest struct {
+	Node  string `json:"node"`            // e.g. rabbit@rabbitmq-node3
+	Queue string `json:"queue,omitempty"` // default: the service queue
+}
+
+// ReplicasHandler handles POST requests that add a member of the queue on a node
+// and DELETE requests that remove one, through the management API
+func (h *Handler) ReplicasHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(h.Management) == 0 {
+		respondWithError(w, management.ErrNoClients.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	var req ReplicaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Node == "" {
+		respondWithError(w, "node is required", http.StatusBadRequest)
+		return
+	}
+	if req.Queue == "" {
+		req.Queue = h.QueueName
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), managementTimeout)
+	defer cancel()
+
+	var replication *management.Replication
+	err := management.Failover(ctx, h.Management, func(c *management.Client) (err error) {
+		if r.Method == http.MethodPost {
+			replication, err = c.AddMember(ctx, h.VHost, req.Queue, req.Node)
+		} else {
+			replication, err = c.RemoveMember(ctx, h.VHost, req.Queue, req.Node)
+		}
+		return err
+	})
+	if err != nil {
+		log.Printf("Error changing the members of %s: %v", req.Queue, err)
+		respondWithError(w, err.Error(), managementErrorStatus(err))
+		return
+	}
+
+	message := "Member added on " + req.Node
+	if r.Method == http.MethodDelete {
+		message = "Member removed from " + req.Node
+	}
+	log.Printf("Queue %s: %s", req.Queue, message)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{
+		Status:  "success",
+		Message: message,
+		Data:    replication,
+	})
+}
+
+// RebalanceHandler handles POST requests that spread the queue leaders over the
+// running nodes. RabbitMQ moves them in the background, so it answers 202.
+func (h *Handler) RebalanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(h.Management) == 0 {
+		respondWithError(w, management.ErrNoClients.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), managementTimeout)
+	defer cancel()
+
+	err := management.Failover(ctx, h.Management, func(c *management.Client) error {
+		return c.RebalanceQueues(ctx)
+	})
+	if err != nil {
+		log.Printf("Error rebalancing queue leaders: %v", err)
+		respondWithError(w, err.Error(), managementErrorStatus(err))
+		return
+	}
+
+	log.Printf("Queue leader rebalance started")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(Response{
+		Status:  "accepted",
+		Message: "Queue leader rebalance started",
+	})
+}
+
+// managementErrorStatus maps management API errors to an HTTP status; a management
+// node that fails or cannot be reached surfaces as 502
+func managementErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, management.ErrUnknownNode):
+		return http.StatusBadRequest
+	case errors.Is(err, management.ErrMembershipConflict), errors.Is(err, management.ErrNotQuorum):
+		return http.StatusConflict
+	case errors.Is(err, management.ErrNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"rabbitmq-quorum-demo/management"
+	"rabbitmq-quorum-demo/management/managementtest"
+)
+
+const (
+	node1 = "rabbit@rabbitmq-node1"
+	node2 = "rabbit@rabbitmq-node2"
+	node3 = "rabbit@rabbitmq-node3"
+)
+
+// newAdminHandler returns a handler whose management API is a managementtest server
+func newAdminHandler(t *testing.T) (*Handler, *managementtest.Server) {
+	t.Helper()
+	server := managementtest.NewServer()
+	t.Cleanup(server.Close)
+	h, _ := newTestHandler(t)
+	h.Management = []*management.Client{server.NewClient()}
+	return h, server
+}
+
+// members reads the member list of the replication summary in a response
+func members(resp Response) []interface{} {
+	data, _ := resp.Data.(map[string]interface{})
+	list, _ := data["members"].([]interface{})
+	return list
+}
+
+func TestReplicasHandler(t *testing.T) {
+	h, _ := newAdminHandler(t)
+
+	code, resp := serve(t, h.ReplicasHandler, http.MethodDelete, "/admin/replicas", ReplicaRequest{Node: node3})
+	if code != http.StatusOK || len(members(resp)) != 2 {
+		t.Fatalf("remove = %d %+v, want 200 with 2 members", code, resp)
+	}
+
+	code, resp = serve(t, h.ReplicasHandler, http.MethodPost, "/admin/replicas", ReplicaRequest{Node: node3, Queue: testQueue})
+	if code != http.StatusOK || len(members(resp)) != 3 {
+		t.Fatalf("add = %d %+v, want 200 with 3 members", code, resp)
+	}
+}
+
+func TestReplicasHandlerErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, server *managementtest.Server)
+		method string
+		req    ReplicaRequest
+		code   int
+	}{
+		{
+			name:   "no node",
+			method: http.MethodPost,
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "unknown node",
+			method: http.MethodPost,
+			req:    ReplicaRequest{Node: "rabbit@elsewhere"},
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "already a member",
+			method: http.MethodPost,
+			req:    ReplicaRequest{Node: node1},
+			code:   http.StatusConflict,
+		},
+		{
+			name: "removal would lose quorum",
+			setup: func(t *testing.T, server *managementtest.Server) {
+				err := server.UpdateQueue("/", testQueue, func(q *management.Queue) { q.Online = []string{node1, node2} })
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			method: http.MethodDelete,
+			req:    ReplicaRequest{Node: node1},
+			code:   http.StatusConflict,
+		},
+		{
+			name: "classic queue",
+			setup: func(t *testing.T, server *managementtest.Server) {
+				if err := server.UpdateQueue("/", testQueue, func(q *management.Queue) { q.Type = "classic" }); err != nil {
+					t.Fatal(err)
+				}
+			},
+			method: http.MethodPost,
+			req:    ReplicaRequest{Node: node3},
+			code:   http.StatusConflict,
+		},
+		{
+			name:   "unknown queue",
+			method: http.MethodDelete,
+			req:    ReplicaRequest{Node: node3, Queue: "missing"},
+			code:   http.StatusNotFound,
+		},
+		{
+			name:   "wrong method",
+			method: http.MethodGet,
+			code:   http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, server := newAdminHandler(t)
+			if tt.setup != nil {
+				tt.setup(t, server)
+			}
+			if code, resp := serve(t, h.ReplicasHandler, tt.method, "/admin/replicas", tt.req); code != tt.code {
+				t.Errorf("%s = %d %+v, want %d", tt.method, code, resp, tt.code)
+			}
+			for _, req := range server.Requests() {
+				if req.Method != http.MethodGet {
+					t.Errorf("a refused change reached the management API: %s %s", req.Method, req.Path)
+				}
+			}
+		})
+	}
+}
+
+func TestRebalanceHandler(t *testing.T) {
+	h, server := newAdminHandler(t)
+
+	code, resp := serve(t, h.RebalanceHandler, http.MethodPost, "/admin/rebalance", nil)
+	if code != http.StatusAccepted || resp.Status != "accepted" {
+		t.Fatalf("rebalance = %d %+v, want 202 accepted", code, resp)
+	}
+	requests := server.Requests()
+	if len(requests) != 1 || requests[0].Method != http.MethodPost || requests[0].Path != "/api/rebalance/queues" {
+		t.Errorf("requests = %+v, want POST /api/rebalance/queues", requests)
+	}
+
+	if code, _ := serve(t, h.RebalanceHandler, http.MethodGet, "/admin/rebalance", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET = %d, want 405", code)
+	}
+}
+
+func TestAdminWithoutManagement(t *testing.T) {
+	h, _ := newTestHandler(t)
+	if code, _ := serve(t, h.ReplicasHandler, http.MethodPost, "/admin/replicas", ReplicaRequest{Node: node3}); code != http.StatusServiceUnavailable {
+		t.Errorf("replicas without a management API = %d, want 503", code)
+	}
+	if code, _ := serve(t, h.RebalanceHandler, http.MethodPost, "/admin/rebalance", nil); code != http.StatusServiceUnavailable {
+		t.Errorf("rebalance without a management API = %d, want 503", code)
+	}
+
+	// A management API that cannot be reached is a bad gateway
+	down := managementtest.NewServer()
+	h.Management = []*management.Client{down.NewClient()}
+	down.Close()
+	if code, _ := serve(t, h.RebalanceHandler, http.MethodPost, "/admin/rebalance", nil); code != http.StatusBadGateway {
+		t.Errorf("rebalance with the management API down = %d, want 502", code)
+	}
+}
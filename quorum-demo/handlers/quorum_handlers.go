@@ -1,25 +1,92 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"rabbitmq-quorum-demo/management"
 	"rabbitmq-quorum-demo/rabbitmq"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Handler struct {
-	RabbitMQ *rabbitmq.RabbitMQ
+	Broker rabbitmq.Broker
+
+	// Management reaches the management API of the cluster nodes, tried in order.
+	// When set, /stats reports the replicas of the queue and /admin can change them.
+	Management []*management.Client
+	// VHost is the virtual host of the queue ("/" when empty)
+	VHost string
+	// QueueName is the queue the /admin endpoints manage when a request names none
+	QueueName string
 }
 
+// managementTimeout bounds the management API calls of one request
+const managementTimeout = 5 * time.Second
+
 type PublishRequest struct {
 	Message string `json:"message"`
+
+	// AMQP message properties; all optional
+	ContentType     string                 `json:"content_type,omitempty"`
+	ContentEncoding string                 `json:"content_encoding,omitempty"`
+	MessageID       string                 `json:"message_id,omitempty"`
+	CorrelationID   string                 `json:"correlation_id,omitempty"`
+	ReplyTo         string                 `json:"reply_to,omitempty"`
+	Priority        int                    `json:"priority,omitempty"`
+	Expiration      string                 `json:"expiration,omitempty"` // TTL in milliseconds
+	Timestamp       time.Time              `json:"timestamp,omitempty"`
+	Type            string                 `json:"type,omitempty"`
+	AppID           string                 `json:"app_id,omitempty"`
+	Headers         map[string]interface{} `json:"headers,omitempty"`
 }
 
-type Response struct {
+// message maps the request onto the message to publish
+func (req PublishRequest) message() rabbitmq.Message {
+	return rabbitmq.Message{
+		Body:            req.Message,
+		ContentType:     req.ContentType,
+		ContentEncoding: req.ContentEncoding,
+		MessageID:       req.MessageID,
+		CorrelationID:   req.CorrelationID,
+		ReplyTo:         req.ReplyTo,
+		Priority:        req.Priority,
+		Expiration:      req.Expiration,
+		Timestamp:       req.Timestamp,
+		Type:            req.Type,
+		AppID:           req.AppID,
+		Headers:         req.Headers,
+	}
+}
+
+type PublishResponse struct {
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	MessageID  string `json:"message_id,omitempty"`
+	Exchange   string `json:"exchange,omitempty"`
+	RoutingKey string `json:"routing_key,omitempty"`
+}
+
+type ConsumeResponse struct {
 	Status  string `json:"status"`
 	Message string `json:"message,omitempty"`
 	Error   string `json:"error,omitempty"`
-	Data    any    `json:"data,omitempty"`
+}
+
+type Response struct {
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Data      any    `json:"data,omitempty"`
+
+	// Delivery carries the properties and delivery metadata of a consumed message
+	Delivery *rabbitmq.Delivery `json:"delivery,omitempty"`
 }
 
 // PublishHandler handles POST requests to publish messages with confirmation
@@ -41,14 +108,111 @@ func (h *Handler) PublishHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Publish message with confirmation
-	if err := h.RabbitMQ.PublishWithConfirmation(req.Message); err != nil {
+	messageID, err := h.Broker.PublishWithConfirmation(req.message())
+	if err != nil {
 		log.Printf("Error publishing message: %v", err)
-		respondWithError(w, "Failed to publish message: "+err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, rabbitmq.ErrInvalidMessage) {
+			respondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, rabbitmq.ErrQueueFull) {
+			// The queue is at its length limit with x-overflow reject-publish
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(Response{
+				Status: "queue_full",
+				Error:  err.Error(),
+			})
+			return
+		}
+		var unroutable *rabbitmq.UnroutableError
+		if errors.As(err, &unroutable) {
+			// The broker confirmed the mandatory message but returned it: no queue took it
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(Response{
+				Status:    "unroutable",
+				MessageID: unroutable.MessageID,
+				Error:     err.Error(),
+				Data:      unroutable,
+			})
+			return
+		}
+		respondWithError(w, "Failed to publish message: "+err.Error(), errorStatus(err, http.StatusInternalServerError))
 		return
 	}
 
-	log.Printf("Published and confirmed: %s", req.Message)
-	respondWithSuccess(w, "Message published and confirmed by broker")
+	log.Printf("Published and confirmed %s: %s", messageID, req.Message)
+	respondWithSuccess(w, "Message published and confirmed by broker", messageID)
+}
+
+// PublishBatchRequest is the body of POST /publish/batch
+type PublishBatchRequest struct {
+	// Mode is best-effort (the default) or all-or-nothing
+	Mode     string           `json:"mode,omitempty"`
+	Messages []PublishRequest `json:"messages"`
+}
+
+// PublishBatchHandler handles POST requests to publish several messages at once.
+// It answers 200 when every message was acked, 207 with the status of each message
+// when some failed, and 409 when an all-or-nothing batch was rolled back.
+func (h *Handler) PublishBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PublishBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mode := rabbitmq.BatchMode(req.Mode)
+	if mode == "" {
+		mode = rabbitmq.BatchBestEffort
+	}
+
+	messages := make([]rabbitmq.Message, len(req.Messages))
+	for i, msg := range req.Messages {
+		if msg.Message == "" {
+			respondWithError(w, fmt.Sprintf("Message %d cannot be empty", i), http.StatusBadRequest)
+			return
+		}
+		messages[i] = msg.message()
+	}
+
+	result, err := h.Broker.PublishBatch(messages, mode)
+	if err != nil {
+		log.Printf("Error publishing batch: %v", err)
+		if errors.Is(err, rabbitmq.ErrInvalidBatch) || errors.Is(err, rabbitmq.ErrInvalidMessage) {
+			respondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		respondWithError(w, "Failed to publish batch: "+err.Error(), errorStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	status, code := "success", http.StatusOK
+	message := fmt.Sprintf("%d message(s) published and confirmed by broker", result.Acked)
+	switch {
+	case result.Failed == 0:
+	case result.RolledBack:
+		status, code = "rolled_back", http.StatusConflict
+		message = "Transaction rolled back, no message was published"
+	default:
+		status, code = "partial", http.StatusMultiStatus
+		message = fmt.Sprintf("%d of %d message(s) published and confirmed by broker", result.Acked, len(result.Results))
+	}
+	log.Printf("Published batch (%s): %d acked, %d failed", mode, result.Acked, result.Failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(Response{
+		Status:  status,
+		Message: message,
+		Data:    result,
+	})
 }
 
 // ConsumeHandler handles GET requests to consume messages with ACK
@@ -59,40 +223,87 @@ func (h *Handler) ConsumeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Consume message and acknowledge
-	message, err := h.RabbitMQ.ConsumeAndAck()
+	delivery, err := h.Broker.ConsumeAndAck()
 	if err != nil {
 		log.Printf("Error consuming message: %v", err)
-		respondWithError(w, err.Error(), http.StatusNotFound)
+		respondWithError(w, err.Error(), errorStatus(err, http.StatusNotFound))
 		return
 	}
 
-	log.Printf("Consumed and acknowledged: %s", message)
-	respondWithMessage(w, message)
+	log.Printf("Consumed and acknowledged (delivery count %d): %s", delivery.DeliveryCount, delivery.Body)
+	respondWithDelivery(w, delivery.Body, delivery)
 }
 
-// ConsumeWithFailureHandler handles POST requests to consume and NACK messages
+// ConsumeWithFailureHandler handles POST requests to consume a message and fail it.
+// ?action= is requeue (default), dead-letter or discard.
 func (h *Handler) ConsumeWithFailureHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Consume message and reject it (simulate processing failure)
-	message, err := h.RabbitMQ.ConsumeAndNack(true) // requeue = true
+	action := rabbitmq.FailAction(r.URL.Query().Get("action"))
+	if action == "" {
+		action = rabbitmq.FailRequeue
+	}
+
+	// Consume message and settle it as a processing failure
+	result, err := h.Broker.ConsumeAndFail(action)
 	if err != nil {
 		log.Printf("Error consuming message: %v", err)
-		respondWithError(w, err.Error(), http.StatusNotFound)
+		status := http.StatusNotFound
+		switch {
+		case errors.Is(err, rabbitmq.ErrInvalidFailAction):
+			status = http.StatusBadRequest
+		case errors.Is(err, rabbitmq.ErrNoDeadLetter):
+			status = http.StatusConflict
+		}
+		respondWithError(w, err.Error(), errorStatus(err, status))
 		return
 	}
 
-	log.Printf("Consumed and rejected (requeued): %s", message)
+	message := fmt.Sprintf("Message consumed and failed (%s): %s", result.Outcome, result.Delivery.Body)
+	switch {
+	case result.Action == rabbitmq.FailRequeue && result.Outcome != rabbitmq.OutcomeRequeued:
+		message = fmt.Sprintf("Message exceeded the delivery limit of %d and was %s: %s",
+			result.DeliveryLimit, strings.ReplaceAll(string(result.Outcome), "_", "-"), result.Delivery.Body)
+	case result.Outcome == rabbitmq.OutcomeDeadLettered:
+		message = fmt.Sprintf("Message consumed and dead-lettered to %s: %s", result.Queue, result.Delivery.Body)
+	}
+
+	log.Printf("Consumed and failed (%s, delivery count %d): %s", result.Outcome, result.Delivery.DeliveryCount, result.Delivery.Body)
+	setDeliveryCount(w, result.Delivery)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(Response{
-		Status:  "success",
-		Message: "Message consumed and rejected (requeued): " + message,
+		Status:   "success",
+		Message:  message,
+		Data:     result,
+		Delivery: result.Delivery,
 	})
 }
 
+// ConsumeFromDLQHandler handles GET requests to consume a message from the Dead Letter Queue
+func (h *Handler) ConsumeFromDLQHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	delivery, err := h.Broker.ConsumeFromDLQ()
+	if err != nil {
+		log.Printf("Error consuming from DLQ: %v", err)
+		status := http.StatusNotFound
+		if errors.Is(err, rabbitmq.ErrNoDeadLetter) {
+			status = http.StatusConflict
+		}
+		respondWithError(w, err.Error(), errorStatus(err, status))
+		return
+	}
+
+	log.Printf("Consumed from DLQ: %s", delivery.Body)
+	respondWithDelivery(w, delivery.Body, delivery)
+}
+
 // StatsHandler handles GET requests to show queue statistics
 func (h *Handler) StatsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -101,18 +312,40 @@ func (h *Handler) StatsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get queue info
-	queueInfo, err := rabbitmq.GetQueueInfo(h.RabbitMQ.Channel, h.RabbitMQ.QueueName)
+	queueInfo, err := h.Broker.QueueInfo()
 	if err != nil {
 		log.Printf("Error getting queue info: %v", err)
-		respondWithError(w, "Failed to get queue stats", http.StatusInternalServerError)
+		respondWithError(w, "Failed to get queue stats", errorStatus(err, http.StatusInternalServerError))
 		return
 	}
 
 	stats := map[string]interface{}{
-		"queue_name": h.RabbitMQ.QueueName,
-		"queue_type": "quorum",
-		"messages":   queueInfo.Messages,
-		"consumers":  queueInfo.Consumers,
+		"queue_name":  queueInfo.Name,
+		"queue_type":  "quorum",
+		"messages":    queueInfo.Messages,
+		"consumers":   queueInfo.Consumers,
+		"active_node": h.Broker.ActiveNode(),
+		"nodes":       h.Broker.Nodes(),
+	}
+	policy := h.Broker.PoisonPolicy()
+	stats["delivery_limit"] = policy.DeliveryLimit
+	if policy.DeadLetterQueue != "" {
+		stats["dead_letter_queue"] = policy.DeadLetterQueue
+		stats["dead_letter_strategy"] = policy.Strategy
+	}
+
+	// Replica state comes from the management API; without it the stats above are still served
+	if len(h.Management) > 0 {
+		replication, err := h.replication(r.Context(), queueInfo.Name)
+		if err != nil {
+			log.Printf("Error getting replication state: %v", err)
+			stats["replication_error"] = err.Error()
+		} else {
+			if replication.Warning != "" {
+				log.Printf("WARNING: queue %s: %s", queueInfo.Name, replication.Warning)
+			}
+			stats["replication"] = replication
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -122,7 +355,29 @@ func (h *Handler) StatsHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// replication reads the replica state of queue from the first management node that answers
+func (h *Handler) replication(ctx context.Context, queue string) (*management.Replication, error) {
+	ctx, cancel := context.WithTimeout(ctx, managementTimeout)
+	defer cancel()
+
+	var replication *management.Replication
+	err := management.Failover(ctx, h.Management, func(c *management.Client) (err error) {
+		replication, err = c.Replication(ctx, h.VHost, queue)
+		return err
+	})
+	return replication, err
+}
+
 // Helper functions
+
+// errorStatus maps broker errors to an HTTP status, using 503 while RabbitMQ is reconnecting
+func errorStatus(err error, fallback int) int {
+	if errors.Is(err, rabbitmq.ErrNotConnected) {
+		return http.StatusServiceUnavailable
+	}
+	return fallback
+}
+
 func respondWithError(w http.ResponseWriter, error string, code int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -132,18 +387,26 @@ func respondWithError(w http.ResponseWriter, error string, code int) {
 	})
 }
 
-func respondWithSuccess(w http.ResponseWriter, message string) {
+func respondWithSuccess(w http.ResponseWriter, message, messageID string) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(Response{
-		Status:  "success",
-		Message: message,
+		Status:    "success",
+		Message:   message,
+		MessageID: messageID,
 	})
 }
 
-func respondWithMessage(w http.ResponseWriter, message string) {
+// setDeliveryCount exposes the x-delivery-count of a consumed message as a response header
+func setDeliveryCount(w http.ResponseWriter, delivery *rabbitmq.Delivery) {
+	w.Header().Set("X-Delivery-Count", strconv.Itoa(delivery.DeliveryCount))
+}
+
+func respondWithDelivery(w http.ResponseWriter, message string, delivery *rabbitmq.Delivery) {
+	setDeliveryCount(w, delivery)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(Response{
-		Status:  "success",
-		Message: message,
+		Status:   "success",
+		Message:  message,
+		Delivery: delivery,
 	})
 }
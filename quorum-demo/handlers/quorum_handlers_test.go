@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rabbitmq-quorum-demo/management"
+	"rabbitmq-quorum-demo/rabbitmq"
+	"strings"
+	"testing"
+)
+
+const testQueue = "orders-quorum"
+
+func newTestHandler(t *testing.T) (*Handler, *rabbitmq.MemoryBroker) {
+	t.Helper()
+	broker := rabbitmq.NewMemoryBroker(testQueue)
+	return &Handler{Broker: broker, QueueName: testQueue}, broker
+}
+
+// serve runs handler on a request with a JSON body and decodes the JSON answer into a Response
+func serve(t *testing.T, handler http.HandlerFunc, method, target string, body interface{}) (int, Response) {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(method, target, &payload))
+
+	var resp Response
+	if rec.Header().Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decoding the %s %s response: %v", method, target, err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestPublishAndConsume(t *testing.T) {
+	h, broker := newTestHandler(t)
+
+	code, resp := serve(t, h.PublishHandler, http.MethodPost, "/publish", PublishRequest{Message: "order 1", MessageID: "o-1"})
+	if code != http.StatusOK || resp.MessageID != "o-1" {
+		t.Fatalf("publish = %d %+v, want 200 with message ID o-1", code, resp)
+	}
+	if got := broker.Messages(); len(got) != 1 || got[0] != "order 1" {
+		t.Fatalf("queue = %v, want [order 1]", got)
+	}
+
+	code, resp = serve(t, h.ConsumeHandler, http.MethodGet, "/consume", nil)
+	if code != http.StatusOK || resp.Delivery == nil || resp.Delivery.MessageID != "o-1" {
+		t.Fatalf("consume = %d %+v, want o-1", code, resp)
+	}
+	if got := broker.Messages(); len(got) != 0 {
+		t.Errorf("queue = %v after an acked consume, want it empty", got)
+	}
+
+	if code, _ := serve(t, h.ConsumeHandler, http.MethodGet, "/consume", nil); code != http.StatusNotFound {
+		t.Errorf("consume on an empty queue = %d, want 404", code)
+	}
+}
+
+func TestPublishErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, broker *rabbitmq.MemoryBroker)
+		code   int
+		status string
+	}{
+		{
+			name:   "queue deleted",
+			setup:  func(t *testing.T, broker *rabbitmq.MemoryBroker) { broker.DeleteQueue() },
+			code:   http.StatusUnprocessableEntity,
+			status: "unroutable",
+		},
+		{
+			name: "queue full",
+			setup: func(t *testing.T, broker *rabbitmq.MemoryBroker) {
+				if err := broker.SetQueueLimits(rabbitmq.QueueLimits{MaxLength: 1, Overflow: rabbitmq.OverflowRejectPublish}); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := broker.PublishWithConfirmation(rabbitmq.Message{Body: "first"}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			code:   http.StatusTooManyRequests,
+			status: "queue_full",
+		},
+		{
+			name:   "not connected",
+			setup:  func(t *testing.T, broker *rabbitmq.MemoryBroker) { broker.SetState(rabbitmq.StateReconnecting) },
+			code:   http.StatusServiceUnavailable,
+			status: "error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, broker := newTestHandler(t)
+			tt.setup(t, broker)
+			before := len(broker.Messages())
+
+			code, resp := serve(t, h.PublishHandler, http.MethodPost, "/publish", PublishRequest{Message: "second"})
+			if code != tt.code || resp.Status != tt.status {
+				t.Errorf("publish = %d %q, want %d %q", code, resp.Status, tt.code, tt.status)
+			}
+			if got := len(broker.Messages()); got != before {
+				t.Errorf("queue holds %d message(s) after a failed publish, want %d", got, before)
+			}
+		})
+	}
+
+	t.Run("empty message", func(t *testing.T) {
+		h, _ := newTestHandler(t)
+		if code, _ := serve(t, h.PublishHandler, http.MethodPost, "/publish", PublishRequest{}); code != http.StatusBadRequest {
+			t.Errorf("publish = %d, want 400", code)
+		}
+	})
+}
+
+func TestPublishUnroutableToAlternateExchange(t *testing.T) {
+	h, broker := newTestHandler(t)
+	broker.SetAlternateExchange("unroutable")
+	broker.DeleteQueue()
+
+	code, resp := serve(t, h.PublishHandler, http.MethodPost, "/publish", PublishRequest{Message: "lost", MessageID: "o-1"})
+	if code != http.StatusUnprocessableEntity || resp.MessageID != "o-1" {
+		t.Fatalf("publish = %d %+v, want 422 for o-1", code, resp)
+	}
+	data, _ := resp.Data.(map[string]interface{})
+	if data["alternate_exchange"] != "unroutable" {
+		t.Errorf("data = %v, want the alternate exchange", resp.Data)
+	}
+	if got := broker.UnroutableMessages(); len(got) != 1 || got[0] != "lost" {
+		t.Errorf("alternate exchange got %v, want [lost]", got)
+	}
+}
+
+func TestPublishBatchAllOrNothing(t *testing.T) {
+	h, broker := newTestHandler(t)
+	if err := broker.SetQueueLimits(rabbitmq.QueueLimits{MaxLength: 1, Overflow: rabbitmq.OverflowRejectPublish}); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := PublishBatchRequest{Mode: string(rabbitmq.BatchAllOrNothing), Messages: []PublishRequest{
+		{Message: "one"},
+		{Message: "two"},
+	}}
+	code, resp := serve(t, h.PublishBatchHandler, http.MethodPost, "/publish/batch", batch)
+	if code != http.StatusConflict || resp.Status != "rolled_back" {
+		t.Fatalf("batch = %d %+v, want 409 rolled back", code, resp)
+	}
+	if got := broker.Messages(); len(got) != 0 {
+		t.Errorf("queue = %v after a rollback, want it empty", got)
+	}
+}
+
+func TestPublishBatchToDeletedQueue(t *testing.T) {
+	h, broker := newTestHandler(t)
+	broker.SetAlternateExchange("unroutable")
+	broker.DeleteQueue()
+
+	batch := PublishBatchRequest{Mode: string(rabbitmq.BatchAllOrNothing), Messages: []PublishRequest{
+		{Message: "one"},
+		{Message: "two"},
+	}}
+	code, resp := serve(t, h.PublishBatchHandler, http.MethodPost, "/publish/batch", batch)
+	if code != http.StatusConflict || resp.Status != "rolled_back" {
+		t.Fatalf("batch = %d %+v, want 409 rolled back", code, resp)
+	}
+	// Nothing was published, so nothing reached the alternate exchange either
+	if got := broker.UnroutableMessages(); len(got) != 0 {
+		t.Errorf("alternate exchange got %v, want nothing", got)
+	}
+}
+
+func TestPublishBatchDuplicateMessageID(t *testing.T) {
+	h, broker := newTestHandler(t)
+	batch := PublishBatchRequest{Messages: []PublishRequest{
+		{Message: "one", MessageID: "o-1"},
+		{Message: "two", MessageID: "o-1"},
+	}}
+
+	if code, _ := serve(t, h.PublishBatchHandler, http.MethodPost, "/publish/batch", batch); code != http.StatusBadRequest {
+		t.Errorf("batch = %d, want 400", code)
+	}
+	if got := broker.Messages(); len(got) != 0 {
+		t.Errorf("queue = %v after an invalid batch, want it empty", got)
+	}
+}
+
+func TestConsumeWithFailure(t *testing.T) {
+	h, broker := newTestHandler(t)
+	if err := broker.SetPoisonPolicy(2, rabbitmq.DeadLetterConfig{Strategy: rabbitmq.DeadLetterAtMostOnce}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := broker.PublishWithConfirmation(rabbitmq.Message{Body: "poison"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requeued until the delivery limit, then dead-lettered
+	for i, outcome := range []rabbitmq.FailOutcome{rabbitmq.OutcomeRequeued, rabbitmq.OutcomeRequeued, rabbitmq.OutcomeDeadLettered} {
+		code, resp := serve(t, h.ConsumeWithFailureHandler, http.MethodPost, "/consume/fail", nil)
+		data, _ := resp.Data.(map[string]interface{})
+		if code != http.StatusOK || data["outcome"] != string(outcome) {
+			t.Fatalf("failure %d = %d %+v, want %s", i+1, code, resp.Data, outcome)
+		}
+		if resp.Delivery.DeliveryCount != i {
+			t.Errorf("failure %d: delivery count = %d, want %d", i+1, resp.Delivery.DeliveryCount, i)
+		}
+	}
+
+	code, resp := serve(t, h.ConsumeFromDLQHandler, http.MethodGet, "/dlq/consume", nil)
+	if code != http.StatusOK || resp.Delivery == nil || resp.Delivery.Body != "poison" {
+		t.Fatalf("DLQ consume = %d %+v, want poison", code, resp)
+	}
+	if code, _ := serve(t, h.ConsumeFromDLQHandler, http.MethodGet, "/dlq/consume", nil); code != http.StatusNotFound {
+		t.Errorf("consume on an empty DLQ = %d, want 404", code)
+	}
+
+	if code, _ := serve(t, h.ConsumeWithFailureHandler, http.MethodPost, "/consume/fail?action=explode", nil); code != http.StatusBadRequest {
+		t.Errorf("unknown action = %d, want 400", code)
+	}
+}
+
+func TestConsumeFromDLQWithoutDeadLetter(t *testing.T) {
+	h, _ := newTestHandler(t)
+	if code, _ := serve(t, h.ConsumeFromDLQHandler, http.MethodGet, "/dlq/consume", nil); code != http.StatusConflict {
+		t.Errorf("DLQ consume without a DLQ = %d, want 409", code)
+	}
+}
+
+func TestStatsWithoutManagement(t *testing.T) {
+	h, broker := newTestHandler(t)
+	if _, err := broker.PublishWithConfirmation(rabbitmq.Message{Body: "one"}); err != nil {
+		t.Fatal(err)
+	}
+
+	code, resp := serve(t, h.StatsHandler, http.MethodGet, "/stats", nil)
+	stats, _ := resp.Data.(map[string]interface{})
+	if code != http.StatusOK || stats["queue_name"] != testQueue || stats["messages"] != float64(1) {
+		t.Fatalf("stats = %d %+v, want one message in %s", code, resp.Data, testQueue)
+	}
+	if _, ok := stats["replication"]; ok {
+		t.Errorf("stats report replication without a management API: %v", stats["replication"])
+	}
+}
+
+func TestStatsReplication(t *testing.T) {
+	tests := []struct {
+		name           string
+		online         []string
+		faultTolerance float64
+		warning        bool
+	}{
+		{name: "all online", online: []string{node1, node2, node3}, faultTolerance: 1},
+		{name: "majority online", online: []string{node1, node2}, faultTolerance: 0},
+		{name: "minority online", online: []string{node1}, faultTolerance: 0, warning: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, server := newAdminHandler(t)
+			if err := server.UpdateQueue("/", testQueue, func(q *management.Queue) { q.Online = tt.online }); err != nil {
+				t.Fatal(err)
+			}
+
+			code, resp := serve(t, h.StatsHandler, http.MethodGet, "/stats", nil)
+			stats, _ := resp.Data.(map[string]interface{})
+			replication, _ := stats["replication"].(map[string]interface{})
+			if code != http.StatusOK || replication == nil {
+				t.Fatalf("stats = %d %+v, want the replication state", code, resp.Data)
+			}
+			online, _ := replication["online"].([]interface{})
+			members, _ := replication["members"].([]interface{})
+			offline, _ := replication["offline"].([]interface{})
+			if replication["leader"] != node1 || len(members) != 3 || len(online) != len(tt.online) || len(offline) != 3-len(tt.online) {
+				t.Errorf("replication = %+v, want %d of 3 members online led by %s", replication, len(tt.online), node1)
+			}
+			if replication["majority"] != float64(2) || replication["fault_tolerance"] != tt.faultTolerance {
+				t.Errorf("majority %v, fault tolerance %v; want 2 and %v", replication["majority"], replication["fault_tolerance"], tt.faultTolerance)
+			}
+			if warning, _ := replication["warning"].(string); (warning != "") != tt.warning {
+				t.Errorf("warning = %q, want one: %t", warning, tt.warning)
+			}
+		})
+	}
+
+	// The queue stats are still served when the management API fails
+	h, server := newAdminHandler(t)
+	server.HandleError(http.MethodGet, "/api/queues/%2F/orders-quorum", http.StatusInternalServerError, "boom")
+	code, resp := serve(t, h.StatsHandler, http.MethodGet, "/stats", nil)
+	stats, _ := resp.Data.(map[string]interface{})
+	if code != http.StatusOK || stats["queue_name"] != testQueue || stats["replication_error"] == nil || stats["replication"] != nil {
+		t.Errorf("stats with a failing management API = %d %+v, want the queue stats and a replication error", code, resp.Data)
+	}
+}
+
+func TestExportUnknownQueue(t *testing.T) {
+	h, _ := newTestHandler(t)
+	if code, _ := serve(t, h.ExportHandler, http.MethodGet, "/export?queue=missing", nil); code != http.StatusNotFound {
+		t.Errorf("export of an unknown queue = %d, want 404", code)
+	}
+}
+
+// importArchive posts archive to the import handler and returns the import result
+func importArchive(t *testing.T, h *Handler, target, archive string) (int, map[string]interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ImportHandler(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(archive)))
+	var resp Response
+	if rec.Header().Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decoding the import response: %v", err)
+		}
+	}
+	result, _ := resp.Data.(map[string]interface{})
+	return rec.Code, result
+}
+
+func TestImportDuplicates(t *testing.T) {
+	archive := `{"queue":"orders-quorum","message_id":"o-1","body":"order 1 again"}
+{"queue":"orders-quorum","message_id":"o-2","body":"order 2"}
+{"queue":"orders-quorum","message_id":"o-2","body":"order 2"}
+`
+	newBroker := func(t *testing.T) (*Handler, *rabbitmq.MemoryBroker) {
+		h, broker := newTestHandler(t)
+		if err := broker.SetPoisonPolicy(1, rabbitmq.DeadLetterConfig{Strategy: rabbitmq.DeadLetterAtMostOnce}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := broker.PublishWithConfirmation(rabbitmq.Message{Body: "order 1", MessageID: "o-1"}); err != nil {
+			t.Fatal(err)
+		}
+		return h, broker
+	}
+
+	t.Run("archive only", func(t *testing.T) {
+		h, broker := newBroker(t)
+		code, result := importArchive(t, h, "/import", archive)
+		if code != http.StatusOK || result["imported"] != float64(2) || result["duplicates"] != float64(1) {
+			t.Fatalf("import = %d %+v, want 2 imported and the repeated o-2 skipped", code, result)
+		}
+
+		// The queue is not read, so the message already there is left untouched
+		code, resp := serve(t, h.ConsumeHandler, http.MethodGet, "/consume", nil)
+		if code != http.StatusOK || resp.Delivery.Body != "order 1" || resp.Delivery.Redelivered || resp.Delivery.DeliveryCount != 0 {
+			t.Errorf("first message = %d %+v, want order 1 never redelivered", code, resp.Delivery)
+		}
+		if got := broker.Messages(); len(got) != 2 {
+			t.Errorf("queue = %v, want both imported records", got)
+		}
+	})
+
+	t.Run("scan", func(t *testing.T) {
+		h, broker := newBroker(t)
+		code, result := importArchive(t, h, "/import?scan=1", archive)
+		if code != http.StatusOK || result["imported"] != float64(1) || result["duplicates"] != float64(2) {
+			t.Fatalf("import = %d %+v, want o-1 found in the queue", code, result)
+		}
+		if got := broker.Messages(); len(got) != 2 || got[0] != "order 1" {
+			t.Fatalf("queue = %v, want order 1 back at the head", got)
+		}
+
+		// Each scan returns the message to the queue; past x-delivery-limit it is dead-lettered
+		if code, _ := importArchive(t, h, "/import?queue=orders-quorum&scan=1", ""); code != http.StatusOK {
+			t.Fatalf("second scan = %d, want 200", code)
+		}
+		if got := broker.DLQMessages(); len(got) != 1 || got[0] != "order 1" {
+			t.Errorf("DLQ = %v after two scans, want order 1 dead-lettered", got)
+		}
+	})
+
+	h, _ := newTestHandler(t)
+	for _, target := range []string{"/import?scan=-1", "/import?scan=all"} {
+		if code, _ := importArchive(t, h, target, archive); code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", target, code)
+		}
+	}
+}
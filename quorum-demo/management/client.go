@@ -0,0 +1,272 @@
+// Package management is a client for the RabbitMQ management HTTP API
+// (the rabbitmq_management plugin, port 15672 by default). It reads the
+// cluster state that AMQP does not expose: nodes, quorum queue replicas,
+// connections, channels, exchanges, bindings and policies.
+package management
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultVHost is the virtual host used when a method is given an empty one
+const DefaultVHost = "/"
+
+// DefaultTimeout bounds a request when Config.Timeout is not set
+const DefaultTimeout = 10 * time.Second
+
+// ErrNotFound matches an APIError for a missing object (404) with errors.Is
+var ErrNotFound = errors.New("not found")
+
+// APIError is returned when the management API answers with an error status
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Reason     string // the "reason" (or "error") field of the response, when present
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("management API %s %s: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// Is makes errors.Is(err, ErrNotFound) true for a 404 and
+// errors.Is(err, ErrMembershipConflict) true for a 409
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrMembershipConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// Config holds the settings used to reach the management API
+type Config struct {
+	// URL is the base URL of the management plugin, e.g. http://localhost:15672
+	URL      string
+	Username string
+	Password string
+
+	// Timeout bounds each request (DefaultTimeout when zero)
+	Timeout time.Duration
+
+	// HTTPClient replaces the default client, e.g. to configure TLS
+	HTTPClient *http.Client
+}
+
+// Client calls the management API of one node; any node reports the whole cluster
+type Client struct {
+	baseURL  string
+	username string
+	password string
+	http     *http.Client
+}
+
+// NewClient creates a client for the management API at cfg.URL
+func NewClient(cfg Config) (*Client, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid management URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid management URL %q: want http(s)://host:port", cfg.URL)
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+		httpClient = &http.Client{Timeout: timeout}
+	}
+	return &Client{
+		baseURL:  strings.TrimSuffix(u.String(), "/"),
+		username: cfg.Username,
+		password: cfg.Password,
+		http:     httpClient,
+	}, nil
+}
+
+// Overview returns the cluster-wide totals and versions
+func (c *Client) Overview(ctx context.Context) (*Overview, error) {
+	var overview Overview
+	if err := c.get(ctx, &overview, "overview"); err != nil {
+		return nil, err
+	}
+	return &overview, nil
+}
+
+// Nodes lists the cluster nodes, running or not
+func (c *Client) Nodes(ctx context.Context) ([]Node, error) {
+	var nodes []Node
+	if err := c.get(ctx, &nodes, "nodes"); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// Node returns one cluster node, such as rabbit@rabbitmq-node1
+func (c *Client) Node(ctx context.Context, name string) (*Node, error) {
+	var node Node
+	if err := c.get(ctx, &node, "nodes", name); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// Queues lists the queues of vhost
+func (c *Client) Queues(ctx context.Context, vhost string) ([]Queue, error) {
+	var queues []Queue
+	if err := c.get(ctx, &queues, "queues", vhostOrDefault(vhost)); err != nil {
+		return nil, err
+	}
+	return queues, nil
+}
+
+// Queue returns one queue, with its leader and members when it is a quorum queue
+func (c *Client) Queue(ctx context.Context, vhost, name string) (*Queue, error) {
+	var queue Queue
+	if err := c.get(ctx, &queue, "queues", vhostOrDefault(vhost), name); err != nil {
+		return nil, err
+	}
+	return &queue, nil
+}
+
+// Connections lists the client connections of the cluster
+func (c *Client) Connections(ctx context.Context) ([]Connection, error) {
+	var connections []Connection
+	if err := c.get(ctx, &connections, "connections"); err != nil {
+		return nil, err
+	}
+	return connections, nil
+}
+
+// Channels lists the channels of every connection
+func (c *Client) Channels(ctx context.Context) ([]Channel, error) {
+	var channels []Channel
+	if err := c.get(ctx, &channels, "channels"); err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+// Exchanges lists the exchanges of vhost, including the default and amq.* ones
+func (c *Client) Exchanges(ctx context.Context, vhost string) ([]Exchange, error) {
+	var exchanges []Exchange
+	if err := c.get(ctx, &exchanges, "exchanges", vhostOrDefault(vhost)); err != nil {
+		return nil, err
+	}
+	return exchanges, nil
+}
+
+// Bindings lists the bindings of vhost
+func (c *Client) Bindings(ctx context.Context, vhost string) ([]Binding, error) {
+	var bindings []Binding
+	if err := c.get(ctx, &bindings, "bindings", vhostOrDefault(vhost)); err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
+
+// QueueBindings lists the bindings whose destination is the named queue
+func (c *Client) QueueBindings(ctx context.Context, vhost, queue string) ([]Binding, error) {
+	var bindings []Binding
+	if err := c.get(ctx, &bindings, "queues", vhostOrDefault(vhost), queue, "bindings"); err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
+
+// Policies lists the policies of vhost
+func (c *Client) Policies(ctx context.Context, vhost string) ([]Policy, error) {
+	var policies []Policy
+	if err := c.get(ctx, &policies, "policies", vhostOrDefault(vhost)); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// get decodes the JSON answer of GET /api/{segments...} into out
+func (c *Client) get(ctx context.Context, out interface{}, segments ...string) error {
+	return c.do(ctx, http.MethodGet, nil, out, segments...)
+}
+
+// do sends a request to /api/{segments...}, escaping each segment so a vhost
+// such as "/" stays one segment, and decodes the answer into out when it is not nil
+func (c *Client) do(ctx context.Context, method string, body, out interface{}, segments ...string) error {
+	escaped := make([]string, len(segments))
+	for i, s := range segments {
+		escaped[i] = url.PathEscape(s)
+	}
+	path := "/api/" + strings.Join(escaped, "/")
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request body: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to build management request: %w", err)
+	}
+	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("management API %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode}
+		var reason struct {
+			Error  string `json:"error"`
+			Reason string `json:"reason"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&reason) == nil {
+			apiErr.Reason = reason.Reason
+			if apiErr.Reason == "" {
+				apiErr.Reason = reason.Error
+			}
+		}
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("management API %s %s: invalid response: %w", method, path, err)
+	}
+	return nil
+}
+
+func vhostOrDefault(vhost string) string {
+	if vhost == "" {
+		return DefaultVHost
+	}
+	return vhost
+}
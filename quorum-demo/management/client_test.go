@@ -0,0 +1,319 @@
+package management_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"rabbitmq-quorum-demo/management"
+	"rabbitmq-quorum-demo/management/managementtest"
+)
+
+const (
+	queue = "orders-quorum"
+	node1 = "rabbit@rabbitmq-node1"
+	node2 = "rabbit@rabbitmq-node2"
+	node3 = "rabbit@rabbitmq-node3"
+)
+
+func newTestServer(t *testing.T) (*managementtest.Server, *management.Client) {
+	t.Helper()
+	server := managementtest.NewServer()
+	t.Cleanup(server.Close)
+	return server, server.NewClient()
+}
+
+func TestNodesAndQueues(t *testing.T) {
+	server, client := newTestServer(t)
+	ctx := context.Background()
+
+	nodes, err := client.Nodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 3 || nodes[0].Name != node1 || !nodes[0].Running {
+		t.Errorf("nodes = %+v, want the three running nodes", nodes)
+	}
+
+	queues, err := client.Queues(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queues) != 2 || queues[0].Name != queue || !queues[0].IsQuorum() {
+		t.Errorf("queues = %+v, want %s and its DLQ", queues, queue)
+	}
+
+	q, err := client.Queue(ctx, "", queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Leader != node1 || len(q.Members) != 3 || len(q.Online) != 3 || q.Messages != 71 || q.MessageBytesReady != 4288 {
+		t.Errorf("queue = %+v, want the fixture led by %s", q, node1)
+	}
+
+	// The default vhost is escaped as a single path segment
+	requests := server.Requests()
+	if last := requests[len(requests)-1]; last.Path != "/api/queues/%2F/orders-quorum" {
+		t.Errorf("queue request path = %s, want /api/queues/%%2F/orders-quorum", last.Path)
+	}
+}
+
+func TestQuorumStatus(t *testing.T) {
+	_, client := newTestServer(t)
+
+	members, err := client.QuorumStatus(context.Background(), "/", queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 3 {
+		t.Fatalf("members = %+v, want 3", members)
+	}
+	leader := members[0]
+	if leader.Node != node1 || leader.RaftState != "leader" || leader.Membership != "voter" {
+		t.Errorf("first member = %+v, want the voter leader on %s", leader, node1)
+	}
+	if leader.Term == nil || *leader.Term != 3 || leader.CommitIndex == nil || *leader.CommitIndex != 2431 {
+		t.Errorf("leader counters = term %v commit %v, want 3 and 2431", leader.Term, leader.CommitIndex)
+	}
+}
+
+func TestQuorumStatusColumns(t *testing.T) {
+	server, client := newTestServer(t)
+	// Some versions report the counters as strings; a stopped member reports none
+	server.Handle(http.MethodGet, "/api/queues/quorum/%2F/orders-quorum/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{"Node Name": "rabbit@rabbitmq-node1", "Raft State": "leader", "Term": "7", "Commit Index": 12},
+			{"Node Name": "rabbit@rabbitmq-node2", "Raft State": "noproc"}
+		]`))
+	})
+
+	members, err := client.QuorumStatus(context.Background(), "/", queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].Term == nil || *members[0].Term != 7 || *members[0].CommitIndex != 12 {
+		t.Fatalf("members = %+v, want term 7 and commit index 12 on the leader", members)
+	}
+	if down := members[1]; down.RaftState != "noproc" || down.Term != nil || down.CommitIndex != nil {
+		t.Errorf("stopped member = %+v, want noproc without counters", down)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	server, client := newTestServer(t)
+	ctx := context.Background()
+
+	r, err := client.Replication(ctx, "/", queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Leader != node1 || r.Majority != 2 || r.FaultTolerance != 1 || r.Warning != "" || len(r.Raft) != 3 {
+		t.Errorf("replication = %+v, want 3 members tolerating 1 failure", r)
+	}
+	if r.Term == nil || *r.Term != 3 {
+		t.Errorf("term = %v, want the leader's term 3", r.Term)
+	}
+
+	// With two members down the queue is below its majority
+	err = server.UpdateQueue("/", queue, func(q *management.Queue) { q.Online = []string{node1} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = client.Replication(ctx, "/", queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Offline) != 2 || r.FaultTolerance != 0 || r.Warning == "" {
+		t.Errorf("replication = %+v, want 2 offline members and a warning", r)
+	}
+	if r.Raft[1].RaftState != "noproc" {
+		t.Errorf("offline member Raft state = %q, want noproc", r.Raft[1].RaftState)
+	}
+}
+
+func TestReplicationWithoutQuorumStatusRoute(t *testing.T) {
+	server, client := newTestServer(t)
+	server.HandleError(http.MethodGet, "/api/queues/quorum/%2F/orders-quorum/status", http.StatusNotFound, "Not Found")
+
+	r, err := client.Replication(context.Background(), "/", queue)
+	if err != nil {
+		t.Fatalf("replication = %v, want the members without Raft counters", err)
+	}
+	if len(r.Members) != 3 || r.Raft != nil || r.Term != nil {
+		t.Errorf("replication = %+v, want members only", r)
+	}
+}
+
+func TestReplicationNotQuorum(t *testing.T) {
+	server, client := newTestServer(t)
+	if err := server.UpdateQueue("/", queue, func(q *management.Queue) { q.Type = "classic" }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Replication(context.Background(), "/", queue); !errors.Is(err, management.ErrNotQuorum) {
+		t.Errorf("replication of a classic queue = %v, want ErrNotQuorum", err)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	server, client := newTestServer(t)
+	ctx := context.Background()
+
+	_, err := client.Queue(ctx, "/", "missing")
+	var apiErr *management.APIError
+	if !errors.Is(err, management.ErrNotFound) || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Reason != "Not Found" {
+		t.Errorf("missing queue = %v, want a 404 APIError matching ErrNotFound", err)
+	}
+	if errors.Is(err, management.ErrMembershipConflict) {
+		t.Errorf("a 404 matches ErrMembershipConflict: %v", err)
+	}
+
+	server.HandleError(http.MethodDelete, "/api/queues/quorum/%2F/orders-quorum/replicas/delete", http.StatusConflict, "membership change in progress")
+	err = client.DeleteQuorumReplica(ctx, "/", queue, node3)
+	if !errors.Is(err, management.ErrMembershipConflict) || errors.Is(err, management.ErrNotFound) {
+		t.Errorf("409 = %v, want ErrMembershipConflict only", err)
+	}
+	if !errors.As(err, &apiErr) || apiErr.Reason != "membership change in progress" {
+		t.Errorf("409 reason = %v, want the reason of the answer", err)
+	}
+
+	wrong := managementtest.NewServer()
+	defer wrong.Close()
+	config := wrong.Config()
+	config.Password = "wrong"
+	other, err := management.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Nodes(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong password = %v, want a 401 APIError", err)
+	}
+}
+
+func TestMembership(t *testing.T) {
+	server, client := newTestServer(t)
+	ctx := context.Background()
+
+	r, err := client.RemoveMember(ctx, "/", queue, node3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Members) != 2 || r.Majority != 2 {
+		t.Errorf("after removing %s = %+v, want 2 members", node3, r)
+	}
+	if _, err := client.RemoveMember(ctx, "/", queue, node3); !errors.Is(err, management.ErrMembershipConflict) {
+		t.Errorf("removing a non-member = %v, want ErrMembershipConflict", err)
+	}
+
+	r, err = client.AddMember(ctx, "/", queue, node3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Members) != 3 || r.Members[2] != node3 {
+		t.Errorf("after adding %s = %+v, want it back", node3, r)
+	}
+	if _, err := client.AddMember(ctx, "/", queue, node3); !errors.Is(err, management.ErrMembershipConflict) {
+		t.Errorf("adding a member twice = %v, want ErrMembershipConflict", err)
+	}
+	if _, err := client.AddMember(ctx, "/", queue, "rabbit@elsewhere"); !errors.Is(err, management.ErrUnknownNode) {
+		t.Errorf("adding an unknown node = %v, want ErrUnknownNode", err)
+	}
+
+	if err := server.UpdateNode(node3, func(n *management.Node) { n.Running = false }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RemoveMember(ctx, "/", queue, node3); err != nil {
+		t.Fatalf("removing a stopped member = %v, want it removed", err)
+	}
+	if _, err := client.AddMember(ctx, "/", queue, node3); !errors.Is(err, management.ErrMembershipConflict) {
+		t.Errorf("adding a stopped node = %v, want ErrMembershipConflict", err)
+	}
+}
+
+func TestRemoveMemberKeepsQuorum(t *testing.T) {
+	server, client := newTestServer(t)
+	if err := server.UpdateQueue("/", queue, func(q *management.Queue) { q.Online = []string{node1, node2} }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two members would remain with one online, below their majority of 2
+	_, err := client.RemoveMember(context.Background(), "/", queue, node1)
+	if !errors.Is(err, management.ErrMembershipConflict) {
+		t.Fatalf("removal that loses quorum = %v, want ErrMembershipConflict", err)
+	}
+	for _, req := range server.Requests() {
+		if req.Method == http.MethodDelete {
+			t.Errorf("the refused removal reached the API: %s %s", req.Method, req.Path)
+		}
+	}
+
+	// The offline member can go: two online members remain, a majority of 2
+	if _, err := client.RemoveMember(context.Background(), "/", queue, node3); err != nil {
+		t.Errorf("removing the offline member = %v, want it removed", err)
+	}
+}
+
+func TestRebalanceQueues(t *testing.T) {
+	server, client := newTestServer(t)
+	ctx := context.Background()
+	if err := server.UpdateQueue("/", queue+".dlq", func(q *management.Queue) { q.Leader, q.Node = node1, node1 }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.RebalanceQueues(ctx); err != nil {
+		t.Fatal(err)
+	}
+	orders, err := client.Queue(ctx, "/", queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dlq, err := client.Queue(ctx, "/", queue+".dlq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orders.Leader == dlq.Leader {
+		t.Errorf("both queues are led by %s after a rebalance", orders.Leader)
+	}
+
+	// RabbitMQ accepts the rebalance and moves the leaders in the background
+	server.Handle(http.MethodPost, "/api/rebalance/queues", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	if err := client.RebalanceQueues(ctx); err != nil {
+		t.Errorf("rebalance answered 202 = %v, want nil", err)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	down := managementtest.NewServer()
+	downClient := down.NewClient()
+	down.Close()
+	_, up := newTestServer(t)
+	ctx := context.Background()
+
+	var called []*management.Client
+	err := management.Failover(ctx, []*management.Client{downClient, up}, func(c *management.Client) error {
+		called = append(called, c)
+		_, err := c.Nodes(ctx)
+		return err
+	})
+	if err != nil || len(called) != 2 {
+		t.Errorf("failover = %v after %d call(s), want the second node to answer", err, len(called))
+	}
+
+	// A node that answers with an error is not skipped
+	called = nil
+	err = management.Failover(ctx, []*management.Client{up, downClient}, func(c *management.Client) error {
+		called = append(called, c)
+		_, err := c.Queue(ctx, "/", "missing")
+		return err
+	})
+	if !errors.Is(err, management.ErrNotFound) || len(called) != 1 {
+		t.Errorf("failover = %v after %d call(s), want the 404 of the first node", err, len(called))
+	}
+
+	if err := management.Failover(ctx, nil, func(*management.Client) error { return nil }); !errors.Is(err, management.ErrNoClients) {
+		t.Errorf("failover without clients = %v, want ErrNoClients", err)
+	}
+}
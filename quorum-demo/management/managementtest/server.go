@@ -0,0 +1,515 @@
+// Package managementtest is a local stand-in for the RabbitMQ management API.
+// It serves the JSON of the 3-node docker-compose cluster (rabbit@rabbitmq-node1..3
+// with the orders-quorum queue and the Raft status of its members) from fixtures,
+// so the management client and the code built on it run without a cluster. Tests
+// and demos can change the cluster state or answer a route by hand.
+package managementtest
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"rabbitmq-quorum-demo/management"
+)
+
+// Credentials accepted by the server, the defaults of the rabbitmq image
+const (
+	Username = "guest"
+	Password = "guest"
+)
+
+//go:embed fixtures/*.json
+var fixtures embed.FS
+
+// object is one decoded fixture entry; unknown fields are kept as served by the API
+type object = map[string]interface{}
+
+// Request is a request the server received
+type Request struct {
+	Method string
+	Path   string // escaped, e.g. /api/queues/%2F/orders-quorum
+	Body   []byte
+}
+
+// Server is an httptest server answering the management API routes the client uses.
+// Adding or removing a quorum queue member and rebalancing the leaders change the
+// served state, so the effect of an admin call can be read back.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	overview object
+	objects  map[string][]object // by route: nodes, queues, connections, ...
+	raft     []quorumStatus
+	handlers map[string]http.HandlerFunc
+	requests []Request
+}
+
+// quorumStatus holds the recorded Raft rows of one quorum queue
+type quorumStatus struct {
+	VHost  string   `json:"vhost"`
+	Name   string   `json:"name"`
+	Status []object `json:"status"`
+}
+
+// lists are the fixtures served as arrays, named after their route
+var lists = []string{"nodes", "queues", "connections", "channels", "exchanges", "bindings", "policies"}
+
+// vhostScoped are the lists that can be filtered by vhost, as in /api/queues/{vhost}
+var vhostScoped = map[string]bool{"queues": true, "exchanges": true, "bindings": true, "policies": true}
+
+// NewServer starts a server loaded with the cluster fixtures; Close it when done
+func NewServer() *Server {
+	s := &Server{
+		objects:  make(map[string][]object),
+		handlers: make(map[string]http.HandlerFunc),
+	}
+	mustLoad("overview", &s.overview)
+	for _, name := range lists {
+		var list []object
+		mustLoad(name, &list)
+		s.objects[name] = list
+	}
+	mustLoad("quorum_status", &s.raft)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func mustLoad(name string, out interface{}) {
+	data, err := fixtures.ReadFile("fixtures/" + name + ".json")
+	if err != nil {
+		panic(fmt.Sprintf("managementtest: %v", err))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		panic(fmt.Sprintf("managementtest: fixture %s: %v", name, err))
+	}
+}
+
+// Config returns the client settings that reach this server
+func (s *Server) Config() management.Config {
+	return management.Config{URL: s.URL, Username: Username, Password: Password}
+}
+
+// NewClient returns a management client for this server
+func (s *Server) NewClient() *management.Client {
+	client, err := management.NewClient(s.Config())
+	if err != nil {
+		panic(fmt.Sprintf("managementtest: %v", err))
+	}
+	return client
+}
+
+// Handle answers method and path (escaped, e.g. /api/queues/%2F/orders-quorum)
+// with h instead of the fixtures
+func (s *Server) Handle(method, path string, h http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method+" "+path] = h
+}
+
+// HandleError answers method and path with status and an API error body
+func (s *Server) HandleError(method, path string, status int, reason string) {
+	s.Handle(method, path, func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, status, reason)
+	})
+}
+
+// Requests returns the requests received so far, in order
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// UpdateQueue changes a queue of the fixtures, e.g. to take a member offline.
+// Fields the management types do not model are kept.
+func (s *Server) UpdateQueue(vhost, name string, update func(q *management.Queue)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj := find(s.objects["queues"], func(o object) bool { return o["vhost"] == vhost && o["name"] == name })
+	if obj == nil {
+		return fmt.Errorf("queue %q in vhost %q: %w", name, vhost, management.ErrNotFound)
+	}
+	return updateObject(obj, func(v interface{}) { update(v.(*management.Queue)) }, &management.Queue{})
+}
+
+// UpdateNode changes a node of the fixtures, e.g. to stop it or raise an alarm
+func (s *Server) UpdateNode(name string, update func(n *management.Node)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj := find(s.objects["nodes"], func(o object) bool { return o["name"] == name })
+	if obj == nil {
+		return fmt.Errorf("node %q: %w", name, management.ErrNotFound)
+	}
+	return updateObject(obj, func(v interface{}) { update(v.(*management.Node)) }, &management.Node{})
+}
+
+// updateObject decodes obj into typed, applies update and merges the result back into obj
+func updateObject(obj object, update func(interface{}), typed interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, typed); err != nil {
+		return err
+	}
+	update(typed)
+
+	if data, err = json.Marshal(typed); err != nil {
+		return err
+	}
+	var fields object
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for k, v := range fields {
+		obj[k] = v
+	}
+	return nil
+}
+
+func find(list []object, match func(object) bool) object {
+	for _, o := range list {
+		if match(o) {
+			return o
+		}
+	}
+	return nil
+}
+
+func filter(list []object, match func(object) bool) []object {
+	out := []object{}
+	for _, o := range list {
+		if match(o) {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if user, pass, ok := r.BasicAuth(); !ok || user != Username || pass != Password {
+		WriteError(w, http.StatusUnauthorized, "Login failed")
+		return
+	}
+
+	path := r.URL.EscapedPath()
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: path, Body: body})
+	h := s.handlers[r.Method+" "+path]
+	s.mu.Unlock()
+
+	if h != nil {
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		h(w, r)
+		return
+	}
+
+	segments, err := split(path)
+	if err != nil || len(segments) < 2 || segments[0] != "api" {
+		WriteError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		s.mu.Lock()
+		status, reason := s.change(r.Method, segments[1:], body)
+		s.mu.Unlock()
+		if status >= http.StatusBadRequest {
+			WriteError(w, status, reason)
+			return
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	s.mu.Lock()
+	answer := s.route(segments[1:])
+	var data []byte
+	if answer != nil {
+		data, err = json.Marshal(answer)
+	}
+	s.mu.Unlock()
+
+	switch {
+	case answer == nil:
+		WriteError(w, http.StatusNotFound, "Not Found")
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, err.Error())
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// route returns the answer for a GET of /api/{segments...}, or nil for a 404
+func (s *Server) route(segments []string) interface{} {
+	kind := segments[0]
+	if kind == "overview" && len(segments) == 1 {
+		return s.overview
+	}
+	list, ok := s.objects[kind]
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case len(segments) == 1:
+		return list
+	case kind == "nodes" && len(segments) == 2:
+		return nilIfMissing(find(list, func(o object) bool { return o["name"] == segments[1] }))
+	case !vhostScoped[kind]:
+		return nil
+	}
+
+	if kind == "queues" && len(segments) == 5 && segments[1] == "quorum" && segments[4] == "status" {
+		return nilIfEmpty(s.quorumStatus(segments[2], segments[3]))
+	}
+
+	vhost := segments[1]
+	switch {
+	case len(segments) == 2:
+		return filter(list, func(o object) bool { return o["vhost"] == vhost })
+	case len(segments) == 3 && (kind == "queues" || kind == "exchanges"):
+		return nilIfMissing(find(list, func(o object) bool { return o["vhost"] == vhost && o["name"] == segments[2] }))
+	case len(segments) == 4 && kind == "queues" && segments[3] == "bindings":
+		queue := find(list, func(o object) bool { return o["vhost"] == vhost && o["name"] == segments[2] })
+		if queue == nil {
+			return nil
+		}
+		return filter(s.objects["bindings"], func(o object) bool {
+			return o["vhost"] == vhost && o["destination"] == segments[2] && o["destination_type"] == "queue"
+		})
+	}
+	return nil
+}
+
+// change applies a write route to the fixtures: adding or removing a quorum queue
+// member and rebalancing the leaders. It returns the status to answer and, for an
+// error, its reason.
+func (s *Server) change(method string, segments []string, body []byte) (int, string) {
+	if method == http.MethodPost && len(segments) == 2 && segments[0] == "rebalance" && segments[1] == "queues" {
+		s.rebalance()
+		return http.StatusNoContent, ""
+	}
+
+	if len(segments) != 6 || segments[0] != "queues" || segments[1] != "quorum" || segments[4] != "replicas" {
+		return http.StatusMethodNotAllowed, "Method Not Allowed"
+	}
+	vhost, name, action := segments[2], segments[3], segments[5]
+	add := method == http.MethodPost && action == "add"
+	if !add && (method != http.MethodDelete || action != "delete") {
+		return http.StatusMethodNotAllowed, "Method Not Allowed"
+	}
+
+	var req struct {
+		Node string `json:"node"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Node == "" {
+		return http.StatusBadRequest, "node is required"
+	}
+	queue := find(s.objects["queues"], func(o object) bool { return o["vhost"] == vhost && o["name"] == name })
+	if queue == nil || queue["type"] != "quorum" {
+		return http.StatusNotFound, "Not Found"
+	}
+	node := find(s.objects["nodes"], func(o object) bool { return o["name"] == req.Node })
+	if node == nil {
+		return http.StatusBadRequest, fmt.Sprintf("node %s is not a cluster member", req.Node)
+	}
+
+	members, online := stringList(queue["members"]), stringList(queue["online"])
+	isMember := indexOf(members, req.Node) >= 0
+	switch {
+	case add && isMember:
+		return http.StatusBadRequest, fmt.Sprintf("%s is already a member of %s", req.Node, name)
+	case add && node["running"] != true:
+		return http.StatusBadRequest, fmt.Sprintf("node %s is down", req.Node)
+	case add:
+		members = append(members, req.Node)
+		online = append(online, req.Node)
+	case !isMember:
+		return http.StatusBadRequest, fmt.Sprintf("%s is not a member of %s", req.Node, name)
+	case len(members) == 1:
+		return http.StatusBadRequest, "cannot remove the last member"
+	default:
+		members = without(members, req.Node)
+		online = without(online, req.Node)
+		if queue["leader"] == req.Node {
+			leader := ""
+			if len(online) > 0 {
+				leader = online[0]
+			}
+			queue["leader"], queue["node"] = leader, leader
+		}
+	}
+	queue["members"], queue["online"] = members, online
+	return http.StatusNoContent, ""
+}
+
+// rebalance moves the leader of each quorum queue to its online member that leads
+// the fewest queues so far; RabbitMQ does the same in the background
+func (s *Server) rebalance() {
+	running := make(map[string]bool)
+	for _, n := range s.objects["nodes"] {
+		if n["running"] == true {
+			running[n["name"].(string)] = true
+		}
+	}
+
+	leaders := make(map[string]int)
+	for _, q := range s.objects["queues"] {
+		if q["type"] != "quorum" {
+			continue
+		}
+		best := ""
+		for _, m := range stringList(q["online"]) {
+			if running[m] && (best == "" || leaders[m] < leaders[best]) {
+				best = m
+			}
+		}
+		if best != "" {
+			leaders[best]++
+			q["leader"], q["node"] = best, best
+		}
+	}
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func without(list []string, s string) []string {
+	if i := indexOf(list, s); i >= 0 {
+		return append(list[:i:i], list[i+1:]...)
+	}
+	return list
+}
+
+// quorumStatus returns the Raft rows of a quorum queue, following the leader and
+// online members of the queue so UpdateQueue changes show here too. Members that
+// are not online report noproc and no counters, as RabbitMQ does for a stopped node.
+func (s *Server) quorumStatus(vhost, name string) []object {
+	queue := find(s.objects["queues"], func(o object) bool { return o["vhost"] == vhost && o["name"] == name })
+	if queue == nil || queue["type"] != "quorum" {
+		return nil
+	}
+	var recorded []object
+	for _, q := range s.raft {
+		if q.VHost == vhost && q.Name == name {
+			recorded = q.Status
+		}
+	}
+
+	online := make(map[string]bool)
+	for _, node := range stringList(queue["online"]) {
+		online[node] = true
+	}
+	var template object
+	for _, row := range recorded {
+		if row["Raft State"] == "leader" {
+			template = row
+		}
+	}
+
+	rows := []object{}
+	for _, node := range stringList(queue["members"]) {
+		base := find(recorded, func(o object) bool { return o["Node Name"] == node })
+		if base == nil {
+			base = template
+		}
+		row := object{}
+		for k, v := range base {
+			row[k] = v
+		}
+		row["Node Name"] = node
+		switch {
+		case !online[node]:
+			row = object{"Node Name": node, "Raft State": "noproc", "Membership": "unknown"}
+		case node == queue["leader"]:
+			row["Raft State"] = "leader"
+		default:
+			row["Raft State"] = "follower"
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// stringList reads a list of strings, decoded from JSON or set by change
+func stringList(v interface{}) []string {
+	if list, ok := v.([]string); ok {
+		return append([]string(nil), list...)
+	}
+	items, _ := v.([]interface{})
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// nilIfEmpty keeps a missing list from being served as JSON null
+func nilIfEmpty(list []object) interface{} {
+	if list == nil {
+		return nil
+	}
+	return list
+}
+
+// nilIfMissing keeps a nil object from being served as JSON null
+func nilIfMissing(o object) interface{} {
+	if o == nil {
+		return nil
+	}
+	return o
+}
+
+// split unescapes each segment of an escaped path, so %2F stays inside its segment
+func split(path string) ([]string, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, p := range parts {
+		unescaped, err := url.PathUnescape(p)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = unescaped
+	}
+	return parts, nil
+}
+
+// WriteError writes an error body shaped like the management API's
+func WriteError(w http.ResponseWriter, status int, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": errorName(status), "reason": reason})
+}
+
+func errorName(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return "Object Not Found"
+	case http.StatusUnauthorized:
+		return "not_authorised"
+	case http.StatusBadRequest:
+		return "bad_request"
+	}
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
@@ -0,0 +1,143 @@
+package management
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ErrUnknownNode is returned for a node that is not part of the cluster
+var ErrUnknownNode = errors.New("unknown node")
+
+// ErrMembershipConflict is returned when a membership change does not fit the
+// current replicas, e.g. adding an existing member or removing one the majority needs
+var ErrMembershipConflict = errors.New("membership conflict")
+
+// ErrNoClients is returned by Failover when no management node is configured
+var ErrNoClients = errors.New("no management API configured")
+
+// AddQuorumReplica asks RabbitMQ to add a member of a quorum queue on node
+func (c *Client) AddQuorumReplica(ctx context.Context, vhost, name, node string) error {
+	return c.do(ctx, http.MethodPost, map[string]string{"node": node}, nil,
+		"queues", "quorum", vhostOrDefault(vhost), name, "replicas", "add")
+}
+
+// DeleteQuorumReplica asks RabbitMQ to remove the member of a quorum queue on node
+func (c *Client) DeleteQuorumReplica(ctx context.Context, vhost, name, node string) error {
+	return c.do(ctx, http.MethodDelete, map[string]string{"node": node}, nil,
+		"queues", "quorum", vhostOrDefault(vhost), name, "replicas", "delete")
+}
+
+// RebalanceQueues asks RabbitMQ to spread the queue leaders over the running nodes.
+// The broker moves them in the background after answering.
+func (c *Client) RebalanceQueues(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, struct{}{}, nil, "rebalance", "queues")
+}
+
+// AddMember adds a member of a quorum queue on node and returns the replicas afterwards.
+// The node must be running and not a member yet, and the queue must have a majority
+// online, since Raft commits a membership change like any other entry.
+func (c *Client) AddMember(ctx context.Context, vhost, queue, node string) (*Replication, error) {
+	replication, err := c.checkMembershipChange(ctx, vhost, queue, node)
+	if err != nil {
+		return nil, err
+	}
+	if contains(replication.Members, node) {
+		return nil, fmt.Errorf("%w: %s is already a member of %q", ErrMembershipConflict, node, queue)
+	}
+
+	if err := c.AddQuorumReplica(ctx, vhost, queue, node); err != nil {
+		return nil, err
+	}
+	return c.Replication(ctx, vhost, queue)
+}
+
+// RemoveMember removes the member of a quorum queue on node and returns the replicas
+// afterwards. It refuses to remove the last member, or one whose removal would leave
+// fewer online members than the new majority.
+func (c *Client) RemoveMember(ctx context.Context, vhost, queue, node string) (*Replication, error) {
+	replication, err := c.checkMembershipChange(ctx, vhost, queue, node)
+	if err != nil {
+		return nil, err
+	}
+	if !contains(replication.Members, node) {
+		return nil, fmt.Errorf("%w: %s is not a member of %q", ErrMembershipConflict, node, queue)
+	}
+	if len(replication.Members) == 1 {
+		return nil, fmt.Errorf("%w: %s is the last member of %q", ErrMembershipConflict, node, queue)
+	}
+
+	members, online := len(replication.Members)-1, len(replication.Online)
+	if contains(replication.Online, node) {
+		online--
+	}
+	if majority := members/2 + 1; online < majority {
+		return nil, fmt.Errorf("%w: removing %s would leave %d of %d members online, below the majority of %d",
+			ErrMembershipConflict, node, online, members, majority)
+	}
+
+	if err := c.DeleteQuorumReplica(ctx, vhost, queue, node); err != nil {
+		return nil, err
+	}
+	return c.Replication(ctx, vhost, queue)
+}
+
+// checkMembershipChange checks that node belongs to the cluster and, for a node
+// that is not a member yet, is running; it returns the current replicas
+func (c *Client) checkMembershipChange(ctx context.Context, vhost, queue, node string) (*Replication, error) {
+	if node == "" {
+		return nil, fmt.Errorf("%w: no node given", ErrUnknownNode)
+	}
+	nodes, err := c.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var target *Node
+	names := make([]string, len(nodes))
+	for i := range nodes {
+		names[i] = nodes[i].Name
+		if nodes[i].Name == node {
+			target = &nodes[i]
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("%w: %s (cluster nodes: %v)", ErrUnknownNode, node, names)
+	}
+
+	replication, err := c.Replication(ctx, vhost, queue)
+	if err != nil {
+		return nil, err
+	}
+	if replication.Warning != "" {
+		return nil, fmt.Errorf("%w: %s", ErrMembershipConflict, replication.Warning)
+	}
+	if !target.Running && !contains(replication.Members, node) {
+		return nil, fmt.Errorf("%w: node %s is not running", ErrMembershipConflict, node)
+	}
+	return replication, nil
+}
+
+// Failover calls fn with each client in turn, moving on only when a node cannot be
+// reached: any node that answers reports, and changes, the whole cluster
+func Failover(ctx context.Context, clients []*Client, fn func(c *Client) error) error {
+	err := ErrNoClients
+	for _, c := range clients {
+		err = fn(c)
+		var urlErr *url.Error
+		if !errors.As(err, &urlErr) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package management
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrNotQuorum is returned when the replica state of a queue that is not a quorum queue is requested
+var ErrNotQuorum = errors.New("not a quorum queue")
+
+// QuorumMember is the Raft state of one member of a quorum queue, as reported by
+// GET /api/queues/quorum/{vhost}/{name}/status (the rows of rabbitmq-queues quorum_status).
+// The counters are nil when the member did not report them, e.g. because it is down.
+type QuorumMember struct {
+	Node         string `json:"node"`
+	RaftState    string `json:"raft_state"` // leader, follower, noproc, ...
+	Membership   string `json:"membership,omitempty"`
+	Term         *int64 `json:"term,omitempty"`
+	CommitIndex  *int64 `json:"commit_index,omitempty"`
+	LastApplied  *int64 `json:"last_applied,omitempty"`
+	LastLogIndex *int64 `json:"last_log_index,omitempty"`
+}
+
+// UnmarshalJSON reads the column names the API uses, such as "Node Name" and "Commit Index"
+func (m *QuorumMember) UnmarshalJSON(data []byte) error {
+	var row map[string]interface{}
+	if err := json.Unmarshal(data, &row); err != nil {
+		return err
+	}
+	text := func(key string) string {
+		s, _ := row[key].(string)
+		return s
+	}
+	number := func(key string) *int64 {
+		switch v := row[key].(type) {
+		case float64:
+			n := int64(v)
+			return &n
+		case string:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return &n
+			}
+		}
+		return nil
+	}
+	*m = QuorumMember{
+		Node:         text("Node Name"),
+		RaftState:    text("Raft State"),
+		Membership:   text("Membership"),
+		Term:         number("Term"),
+		CommitIndex:  number("Commit Index"),
+		LastApplied:  number("Last Applied"),
+		LastLogIndex: number("Last Log Index"),
+	}
+	return nil
+}
+
+// QuorumStatus returns the Raft state of each member of a quorum queue. Older
+// RabbitMQ versions do not serve this route and answer 404 (ErrNotFound).
+func (c *Client) QuorumStatus(ctx context.Context, vhost, name string) ([]QuorumMember, error) {
+	var members []QuorumMember
+	if err := c.get(ctx, &members, "queues", "quorum", vhostOrDefault(vhost), name, "status"); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// Replication summarises the replicas of a quorum queue and how many more can fail
+type Replication struct {
+	Leader  string   `json:"leader"`
+	Members []string `json:"members"`
+	Online  []string `json:"online"`
+	Offline []string `json:"offline"`
+
+	// Majority is the number of online members the queue needs to stay available
+	Majority int `json:"majority"`
+	// FaultTolerance is the number of online members that can still fail
+	FaultTolerance int `json:"fault_tolerance"`
+
+	// Term and CommitIndex are the leader's, when the Raft status is available
+	Term        *int64         `json:"raft_term,omitempty"`
+	CommitIndex *int64         `json:"raft_commit_index,omitempty"`
+	Raft        []QuorumMember `json:"raft,omitempty"`
+
+	// Warning is set when fewer than a majority of members are online
+	Warning string `json:"warning,omitempty"`
+}
+
+// Replication reads the members of a quorum queue and, where the RabbitMQ version
+// serves it, their Raft status
+func (c *Client) Replication(ctx context.Context, vhost, name string) (*Replication, error) {
+	queue, err := c.Queue(ctx, vhost, name)
+	if err != nil {
+		return nil, err
+	}
+	if !queue.IsQuorum() {
+		return nil, fmt.Errorf("%w: queue %q is a %s queue", ErrNotQuorum, name, queue.Type)
+	}
+	replication := NewReplication(queue)
+
+	raft, err := c.QuorumStatus(ctx, vhost, name)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		// Not served by this RabbitMQ version: the counters are left out
+	case err != nil:
+		return nil, err
+	default:
+		replication.setRaft(raft)
+	}
+	return replication, nil
+}
+
+// NewReplication builds the replica summary of a quorum queue from its management data
+func NewReplication(queue *Queue) *Replication {
+	online := make(map[string]bool, len(queue.Online))
+	for _, node := range queue.Online {
+		online[node] = true
+	}
+
+	r := &Replication{
+		Leader:   queue.Leader,
+		Members:  append([]string{}, queue.Members...),
+		Online:   []string{},
+		Offline:  []string{},
+		Majority: len(queue.Members)/2 + 1,
+	}
+	for _, node := range queue.Members {
+		if online[node] {
+			r.Online = append(r.Online, node)
+		} else {
+			r.Offline = append(r.Offline, node)
+		}
+	}
+
+	if spare := len(r.Online) - r.Majority; spare >= 0 {
+		r.FaultTolerance = spare
+	} else {
+		r.Warning = fmt.Sprintf("only %d of %d members online, below the majority of %d: the queue cannot accept or deliver messages",
+			len(r.Online), len(r.Members), r.Majority)
+	}
+	return r
+}
+
+// setRaft records the members' Raft status and the leader's term and commit index
+func (r *Replication) setRaft(members []QuorumMember) {
+	r.Raft = members
+	for _, m := range members {
+		if m.RaftState == "leader" {
+			r.Term, r.CommitIndex = m.Term, m.CommitIndex
+			return
+		}
+	}
+	// No member reported itself as leader: fall back to the node the queue names
+	for _, m := range members {
+		if m.Node == r.Leader {
+			r.Term, r.CommitIndex = m.Term, m.CommitIndex
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package management
+
+// The types below keep the fields the services use; the API returns many more,
+// which are ignored when decoding.
+
+// Overview is the answer of GET /api/overview
+type Overview struct {
+	ClusterName       string       `json:"cluster_name"`
+	Node              string       `json:"node"` // the node that answered
+	ManagementVersion string       `json:"management_version"`
+	RabbitMQVersion   string       `json:"rabbitmq_version"`
+	ErlangVersion     string       `json:"erlang_version"`
+	QueueTotals       QueueTotals  `json:"queue_totals"`
+	ObjectTotals      ObjectTotals `json:"object_totals"`
+}
+
+// QueueTotals counts the messages of every queue in the cluster
+type QueueTotals struct {
+	Messages               int64 `json:"messages"`
+	MessagesReady          int64 `json:"messages_ready"`
+	MessagesUnacknowledged int64 `json:"messages_unacknowledged"`
+	MessageBytesReady      int64 `json:"message_bytes_ready"`
+}
+
+// ObjectTotals counts the objects of the cluster
+type ObjectTotals struct {
+	Connections int `json:"connections"`
+	Channels    int `json:"channels"`
+	Exchanges   int `json:"exchanges"`
+	Queues      int `json:"queues"`
+	Consumers   int `json:"consumers"`
+}
+
+// Node is a cluster node as reported by GET /api/nodes
+type Node struct {
+	Name          string   `json:"name"`
+	Type          string   `json:"type"` // disc or ram
+	Running       bool     `json:"running"`
+	Uptime        int64    `json:"uptime"` // milliseconds
+	MemUsed       int64    `json:"mem_used"`
+	MemLimit      int64    `json:"mem_limit"`
+	MemAlarm      bool     `json:"mem_alarm"`
+	DiskFree      int64    `json:"disk_free"`
+	DiskFreeLimit int64    `json:"disk_free_limit"`
+	DiskFreeAlarm bool     `json:"disk_free_alarm"`
+	FDUsed        int64    `json:"fd_used"`
+	FDTotal       int64    `json:"fd_total"`
+	Partitions    []string `json:"partitions"` // nodes this one cannot reach
+}
+
+// Queue is a queue as reported by GET /api/queues. Leader, Members and Online
+// are only set for quorum queues and streams.
+type Queue struct {
+	Name       string                 `json:"name"`
+	VHost      string                 `json:"vhost"`
+	Type       string                 `json:"type"` // classic, quorum or stream
+	Durable    bool                   `json:"durable"`
+	AutoDelete bool                   `json:"auto_delete"`
+	Exclusive  bool                   `json:"exclusive"`
+	Arguments  map[string]interface{} `json:"arguments"`
+	Policy     string                 `json:"policy,omitempty"`
+	State      string                 `json:"state"` // running, minority, ...
+	Node       string                 `json:"node"`  // the leader for quorum queues
+
+	Leader  string   `json:"leader,omitempty"`
+	Members []string `json:"members,omitempty"`
+	Online  []string `json:"online,omitempty"`
+
+	Messages               int64 `json:"messages"`
+	MessagesReady          int64 `json:"messages_ready"`
+	MessagesUnacknowledged int64 `json:"messages_unacknowledged"`
+	MessageBytesReady      int64 `json:"message_bytes_ready"`
+	Consumers              int   `json:"consumers"`
+	Memory                 int64 `json:"memory"`
+}
+
+// IsQuorum reports whether q is a quorum queue
+func (q Queue) IsQuorum() bool {
+	return q.Type == "quorum"
+}
+
+// Connection is a client connection as reported by GET /api/connections
+type Connection struct {
+	Name             string                 `json:"name"`
+	Node             string                 `json:"node"`
+	VHost            string                 `json:"vhost"`
+	User             string                 `json:"user"`
+	State            string                 `json:"state"`
+	Protocol         string                 `json:"protocol"`
+	Channels         int                    `json:"channels"`
+	PeerHost         string                 `json:"peer_host"`
+	PeerPort         int                    `json:"peer_port"`
+	SSL              bool                   `json:"ssl"`
+	ConnectedAt      int64                  `json:"connected_at"` // milliseconds since the epoch
+	ClientProperties map[string]interface{} `json:"client_properties"`
+}
+
+// Channel is a channel as reported by GET /api/channels
+type Channel struct {
+	Name                   string            `json:"name"`
+	Node                   string            `json:"node"`
+	Number                 int               `json:"number"`
+	VHost                  string            `json:"vhost"`
+	User                   string            `json:"user"`
+	State                  string            `json:"state"`
+	Confirm                bool              `json:"confirm"`
+	Transactional          bool              `json:"transactional"`
+	PrefetchCount          int               `json:"prefetch_count"`
+	ConsumerCount          int               `json:"consumer_count"`
+	MessagesUnacknowledged int64             `json:"messages_unacknowledged"`
+	MessagesUnconfirmed    int64             `json:"messages_unconfirmed"`
+	ConnectionDetails      ConnectionDetails `json:"connection_details"`
+}
+
+// ConnectionDetails names the connection a channel belongs to
+type ConnectionDetails struct {
+	Name     string `json:"name"`
+	PeerHost string `json:"peer_host"`
+	PeerPort int    `json:"peer_port"`
+}
+
+// Exchange is an exchange as reported by GET /api/exchanges
+type Exchange struct {
+	Name       string                 `json:"name"` // "" for the default exchange
+	VHost      string                 `json:"vhost"`
+	Type       string                 `json:"type"`
+	Durable    bool                   `json:"durable"`
+	AutoDelete bool                   `json:"auto_delete"`
+	Internal   bool                   `json:"internal"`
+	Arguments  map[string]interface{} `json:"arguments"`
+	Policy     string                 `json:"policy,omitempty"`
+}
+
+// Binding is a binding as reported by GET /api/bindings
+type Binding struct {
+	Source          string                 `json:"source"`
+	VHost           string                 `json:"vhost"`
+	Destination     string                 `json:"destination"`
+	DestinationType string                 `json:"destination_type"` // queue or exchange
+	RoutingKey      string                 `json:"routing_key"`
+	Arguments       map[string]interface{} `json:"arguments"`
+	PropertiesKey   string                 `json:"properties_key"`
+}
+
+// Policy is a policy as reported by GET /api/policies
+type Policy struct {
+	Name       string                 `json:"name"`
+	VHost      string                 `json:"vhost"`
+	Pattern    string                 `json:"pattern"`
+	ApplyTo    string                 `json:"apply-to"` // queues, exchanges, all, ...
+	Priority   int                    `json:"priority"`
+	Definition map[string]interface{} `json:"definition"`
+}
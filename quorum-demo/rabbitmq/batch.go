@@ -0,0 +1,279 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MaxBatchSize is the largest number of messages PublishBatch accepts at once
+const MaxBatchSize = 1000
+
+// batchTimeout bounds a whole batch, from the first publish to the last confirm or the commit
+const batchTimeout = 30 * time.Second
+
+// ErrInvalidBatch is returned for an empty or oversized batch, an unknown mode
+// or a message ID used by two messages of the batch
+var ErrInvalidBatch = errors.New("invalid batch")
+
+// BatchMode decides whether the messages of a batch succeed or fail together
+type BatchMode string
+
+const (
+	// BatchBestEffort pipelines the messages with publisher confirms; each one succeeds or fails on its own
+	BatchBestEffort BatchMode = "best-effort"
+	// BatchAllOrNothing publishes the messages in an AMQP transaction: all of them are committed or none
+	BatchAllOrNothing BatchMode = "all-or-nothing"
+)
+
+// BatchStatus is the outcome of one message of a batch
+type BatchStatus string
+
+const (
+	BatchAcked      BatchStatus = "acked"       // confirmed by the broker, or committed
+	BatchNacked     BatchStatus = "nacked"      // refused by the broker or not published
+	BatchReturned   BatchStatus = "returned"    // mandatory message that matched no queue
+	BatchTimedOut   BatchStatus = "timed_out"   // no confirm before the batch timed out
+	BatchRolledBack BatchStatus = "rolled_back" // discarded with the rest of its transaction
+)
+
+// BatchMessageResult is the outcome of one message, by its position in the batch
+type BatchMessageResult struct {
+	Index     int         `json:"index"`
+	MessageID string      `json:"message_id,omitempty"`
+	Status    BatchStatus `json:"status"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// BatchResult is the outcome of PublishBatch, with one result per message in batch order
+type BatchResult struct {
+	Mode    BatchMode            `json:"mode"`
+	Acked   int                  `json:"acked"`
+	Failed  int                  `json:"failed"`
+	Results []BatchMessageResult `json:"results"`
+
+	// RolledBack is set when an all-or-nothing batch published nothing
+	RolledBack bool `json:"rolled_back,omitempty"`
+}
+
+// prepareBatch checks the batch and builds every publishing before anything is sent,
+// so an invalid message fails the whole request. A return only carries the message ID
+// of its message, so the IDs of a batch must be unique to tell which one was returned.
+func prepareBatch(messages []Message, mode BatchMode) ([]amqp.Publishing, error) {
+	switch mode {
+	case BatchBestEffort, BatchAllOrNothing:
+	default:
+		return nil, fmt.Errorf("%w: unknown mode %q (want best-effort or all-or-nothing)", ErrInvalidBatch, mode)
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("%w: no messages", ErrInvalidBatch)
+	}
+	if len(messages) > MaxBatchSize {
+		return nil, fmt.Errorf("%w: %d messages exceed the limit of %d", ErrInvalidBatch, len(messages), MaxBatchSize)
+	}
+
+	publishings := make([]amqp.Publishing, len(messages))
+	seen := make(map[string]int, len(messages))
+	for i, msg := range messages {
+		publishing, err := msg.publishing()
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		if j, ok := seen[publishing.MessageId]; ok {
+			return nil, fmt.Errorf("%w: messages %d and %d have the same message ID %q", ErrInvalidBatch, j, i, publishing.MessageId)
+		}
+		seen[publishing.MessageId] = i
+		publishings[i] = publishing
+	}
+	return publishings, nil
+}
+
+func newBatchResult(mode BatchMode, publishings []amqp.Publishing) *BatchResult {
+	result := &BatchResult{Mode: mode, Results: make([]BatchMessageResult, len(publishings))}
+	for i, p := range publishings {
+		result.Results[i] = BatchMessageResult{Index: i, MessageID: p.MessageId}
+	}
+	return result
+}
+
+// set records the outcome of message i
+func (r *BatchResult) set(i int, status BatchStatus, err error) {
+	r.Results[i].Status = status
+	r.Results[i].Error = ""
+	if err != nil {
+		r.Results[i].Error = err.Error()
+	}
+}
+
+// rollBack marks the batch as rolled back and every message but failed (-1 for none) with it
+func (r *BatchResult) rollBack(failed int, err error) {
+	r.RolledBack = true
+	for i := range r.Results {
+		if i != failed {
+			r.set(i, BatchRolledBack, err)
+		}
+	}
+}
+
+// count tallies the acked and failed messages
+func (r *BatchResult) count() {
+	r.Acked, r.Failed = 0, 0
+	for _, res := range r.Results {
+		if res.Status == BatchAcked {
+			r.Acked++
+		} else {
+			r.Failed++
+		}
+	}
+}
+
+// PublishBatch publishes messages to the queue as mandatory and reports the outcome
+// of each one. Best-effort pipelines them through the confirm dispatchers of the
+// publish pool; all-or-nothing publishes them in a transaction on a dedicated
+// channel and commits only if all were sent.
+func (r *RabbitMQ) PublishBatch(messages []Message, mode BatchMode) (*BatchResult, error) {
+	publishings, err := prepareBatch(messages, mode)
+	if err != nil {
+		return nil, err
+	}
+	if state := r.State(); state != StateConnected {
+		return nil, fmt.Errorf("%w (state: %s)", ErrNotConnected, state)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), batchTimeout)
+	defer cancel()
+
+	result := newBatchResult(mode, publishings)
+	if mode == BatchAllOrNothing {
+		err = r.publishBatchTx(ctx, publishings, result)
+	} else {
+		r.publishBatchConfirmed(ctx, publishings, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.count()
+	return result, nil
+}
+
+// publishBatchConfirmed sends every message before waiting for the confirms.
+// The in-flight window of each channel still applies, so a large batch waits
+// for early confirms while it is being sent.
+func (r *RabbitMQ) publishBatchConfirmed(ctx context.Context, publishings []amqp.Publishing, result *BatchResult) {
+	pending := make([]*PendingConfirm, len(publishings))
+	for i, publishing := range publishings {
+		p, err := r.publishAsync(ctx, publishing, "", r.QueueName)
+		if err != nil {
+			result.set(i, BatchNacked, err)
+			continue
+		}
+		pending[i] = p
+	}
+
+	for i, p := range pending {
+		if p == nil {
+			continue
+		}
+		err := p.Wait(ctx)
+		switch {
+		case err == nil:
+			result.set(i, BatchAcked, nil)
+		case errors.Is(err, context.DeadlineExceeded):
+			result.set(i, BatchTimedOut, fmt.Errorf("no publisher confirm within %s", batchTimeout))
+		case errors.Is(err, ErrUnroutable):
+			result.set(i, BatchReturned, err)
+		default:
+			result.set(i, BatchNacked, err)
+		}
+	}
+}
+
+// publishBatchTx publishes the messages in a transaction on a channel of its own,
+// since a channel in confirm mode cannot use transactions. The queue is checked
+// first with a passive declare: a committed message that is returned cannot be
+// taken back, so a missing queue rolls the batch back before anything is sent.
+// A queue deleted between the check and the commit still returns its messages.
+func (r *RabbitMQ) publishBatchTx(ctx context.Context, publishings []amqp.Publishing, result *BatchResult) error {
+	r.mu.RLock()
+	conn, state := r.publishConn, r.state
+	r.mu.RUnlock()
+	if state != StateConnected {
+		return fmt.Errorf("%w (state: %s)", ErrNotConnected, state)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open a transaction channel: %w", err)
+	}
+	defer ch.Close()
+
+	if _, err := GetQueueInfo(ch, r.QueueName); err != nil {
+		var amqpErr *amqp.Error
+		if !errors.As(err, &amqpErr) || amqpErr.Code != amqp.NotFound {
+			return err
+		}
+		// The failed declare closed the channel, which is not reused
+		result.set(0, BatchReturned, &UnroutableError{
+			MessageID:  result.Results[0].MessageID,
+			RoutingKey: r.QueueName,
+			ReplyCode:  amqp.NoRoute,
+			ReplyText:  "NO_ROUTE",
+		})
+		result.rollBack(0, fmt.Errorf("transaction rolled back: message 0 cannot be routed"))
+		return nil
+	}
+
+	if err := ch.Tx(); err != nil {
+		return fmt.Errorf("failed to start a transaction: %w", err)
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, len(publishings)))
+
+	for i, publishing := range publishings {
+		err := ch.PublishWithContext(
+			ctx,
+			"",          // exchange
+			r.QueueName, // routing key (queue name)
+			true,        // mandatory - return message if not routable
+			false,       // immediate
+			publishing,  // persistent (required for quorum queues)
+		)
+		if err != nil {
+			ch.TxRollback()
+			result.set(i, BatchNacked, fmt.Errorf("failed to publish message: %w", err))
+			result.rollBack(i, fmt.Errorf("transaction rolled back: message %d failed", i))
+			return nil
+		}
+	}
+
+	if err := ch.TxCommit(); err != nil {
+		result.rollBack(-1, fmt.Errorf("transaction commit failed: %w", err))
+		return nil
+	}
+
+	for i := range result.Results {
+		result.set(i, BatchAcked, nil)
+	}
+
+	// The broker sends the returns of a transaction before commit-ok, so they are
+	// buffered: the queue was missing and the returned messages reached no queue
+	returned := make(map[string][]amqp.Return)
+	for done := false; !done; {
+		select {
+		case ret := <-returns:
+			returned[ret.MessageId] = append(returned[ret.MessageId], ret)
+		default:
+			done = true
+		}
+	}
+	for i := range result.Results {
+		id := result.Results[i].MessageID
+		if rets := returned[id]; len(rets) > 0 {
+			returned[id] = rets[1:]
+			result.set(i, BatchReturned, r.returned(rets[0]))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package rabbitmq
+
+import (
+	"io"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Broker is the set of queue operations the HTTP handlers depend on.
+// *RabbitMQ implements it against a real cluster and *MemoryBroker in process,
+// so handlers can be exercised with httptest and no running RabbitMQ.
+type Broker interface {
+	// PublishWithConfirmation publishes a message, waits for the broker ack
+	// and returns the message ID
+	PublishWithConfirmation(msg Message) (string, error)
+
+	// PublishBatch publishes several messages to the queue and reports the outcome
+	// of each one, best-effort or all-or-nothing
+	PublishBatch(messages []Message, mode BatchMode) (*BatchResult, error)
+
+	// ConsumeWithManualAck gets a message that must be settled with AckMessage or NackMessage
+	ConsumeWithManualAck() (*MessageWithTag, error)
+	AckMessage(msg *MessageWithTag) error
+	NackMessage(msg *MessageWithTag, requeue bool) error
+
+	// ConsumeAndAck and ConsumeAndNack get a message and settle it right away
+	ConsumeAndAck() (*Delivery, error)
+	ConsumeAndNack(requeue bool) (*Delivery, error)
+
+	// ConsumeAndFail gets a message and requeues, dead-letters or discards it
+	ConsumeAndFail(action FailAction) (*FailResult, error)
+
+	// ConsumeFromDLQ gets a message from the Dead Letter Queue with auto-ack
+	ConsumeFromDLQ() (*Delivery, error)
+
+	// PoisonPolicy reports the delivery limit and Dead Letter Queue of the queue
+	PoisonPolicy() PoisonPolicy
+
+	// QueueInfo reports the ready message and consumer counts of the queue
+	QueueInfo() (amqp.Queue, error)
+
+	// Export writes the ready messages of a queue to w as NDJSON archive records,
+	// copying them or, in destructive mode, removing them
+	Export(w io.Writer, opts ExportOptions) (*ExportResult, error)
+
+	// Import publishes NDJSON archive records with confirms, skipping message IDs already queued
+	Import(r io.Reader, opts ImportOptions) (*ImportResult, error)
+
+	// State, ActiveNode and Nodes describe the connection to the cluster
+	State() State
+	ActiveNode() string
+	Nodes() []NodeStatus
+}
+
+var (
+	_ Broker = (*RabbitMQ)(nil)
+	_ Broker = (*MemoryBroker)(nil)
+)
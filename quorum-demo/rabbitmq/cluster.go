@@ -0,0 +1,46 @@
+package rabbitmq
+
+import (
+	"net/url"
+)
+
+// NodeStatus describes one configured cluster endpoint
+type NodeStatus struct {
+	Address string `json:"address"`
+	Active  bool   `json:"active"`
+}
+
+// ActiveNode returns the host:port of the node the service is connected to,
+// or an empty string while disconnected
+func (r *RabbitMQ) ActiveNode() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.activeNode < 0 {
+		return ""
+	}
+	return nodeAddress(r.config.URLs[r.activeNode])
+}
+
+// Nodes lists the configured endpoints and marks the active one
+func (r *RabbitMQ) Nodes() []NodeStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	nodes := make([]NodeStatus, len(r.config.URLs))
+	for i, u := range r.config.URLs {
+		nodes[i] = NodeStatus{
+			Address: nodeAddress(u),
+			Active:  i == r.activeNode,
+		}
+	}
+	return nodes
+}
+
+// nodeAddress strips credentials and vhost from an AMQP URL so it can be logged and reported
+func nodeAddress(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "invalid-url"
+	}
+	return u.Host
+}
@@ -0,0 +1,323 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DefaultConfirmWindow is the number of unconfirmed publishes allowed per channel
+// when Config.ConfirmWindow is not set
+const DefaultConfirmWindow = 256
+
+// ErrConfirmLost is returned when the channel closes before the broker confirmed a message;
+// the message may or may not have been enqueued
+var ErrConfirmLost = errors.New("channel closed before the broker confirmed the message")
+
+// PendingConfirm is the future of a published message. It resolves when the
+// broker acks or nacks the message, or when its channel closes first.
+type PendingConfirm struct {
+	MessageID string
+
+	// DeliveryTag is the publish sequence number of the message on its channel
+	DeliveryTag uint64
+
+	confirm    *amqp.DeferredConfirmation
+	exchange   string
+	routingKey string
+	done       chan struct{}
+	err        error
+}
+
+// Done is closed once the confirm has been resolved
+func (p *PendingConfirm) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err is nil when the broker acked the message; only valid after Done is closed
+func (p *PendingConfirm) Err() error {
+	return p.err
+}
+
+// Wait blocks until the confirm is resolved or ctx is done. Giving up does not
+// cancel the publish: the dispatcher still settles the confirm in the background.
+func (p *PendingConfirm) Wait(ctx context.Context) error {
+	select {
+	case <-p.done:
+		return p.err
+	case <-ctx.Done():
+		return fmt.Errorf("timeout waiting for confirmation: %w", ctx.Err())
+	}
+}
+
+// confirmDispatcher resolves the publisher confirms of one confirm-mode channel.
+// Publishes on the channel are tracked in sequence-number order and at most
+// window of them are outstanding, so callers can pipeline without holding the channel.
+// It also reads the mandatory returns of the channel and matches them to their
+// publishes by message ID and destination: a returned message is acked but reached no queue.
+type confirmDispatcher struct {
+	ch        *amqp.Channel
+	slots     chan struct{}        // one entry per unconfirmed publish
+	pending   chan *PendingConfirm // unconfirmed publishes in publish order
+	nackError func(exchange, routingKey string) error
+	returned  func(ret amqp.Return) error
+
+	// returns holds the returns not yet matched to a confirm; only the run goroutine uses it
+	returns map[returnKey][]amqp.Return
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+// newConfirmDispatcher starts the dispatcher of ch; it stops when ch closes
+// and then calls onStop. nackError describes a nacked publish and returned
+// the outcome of a returned one.
+func newConfirmDispatcher(ch *amqp.Channel, window int, nackError func(exchange, routingKey string) error, returned func(ret amqp.Return) error, onStop func()) *confirmDispatcher {
+	if window <= 0 {
+		window = DefaultConfirmWindow
+	}
+	d := &confirmDispatcher{
+		ch:        ch,
+		slots:     make(chan struct{}, window),
+		pending:   make(chan *PendingConfirm, window),
+		nackError: nackError,
+		returned:  returned,
+		returns:   make(map[returnKey][]amqp.Return),
+	}
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	returns := ch.NotifyReturn(make(chan amqp.Return, window))
+	go d.run(closed, returns, onStop)
+	return d
+}
+
+// reserve waits for room in the in-flight window; it must be followed by track or release
+func (d *confirmDispatcher) reserve(ctx context.Context) error {
+	select {
+	case d.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for the confirm window: %w", ctx.Err())
+	}
+}
+
+// release frees a slot of the in-flight window
+func (d *confirmDispatcher) release() {
+	<-d.slots
+}
+
+// publish sends publishing as mandatory on the channel of d once the window has room
+// and returns the future of its confirm. The caller must hold the channel.
+func (d *confirmDispatcher) publish(ctx context.Context, publishing amqp.Publishing, exchange, routingKey string) (*PendingConfirm, error) {
+	// Wait for room in the channel's in-flight window
+	if err := d.reserve(ctx); err != nil {
+		return nil, err
+	}
+
+	// Publish the message and get a handle on its confirmation
+	confirm, err := d.ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		exchange,   // exchange
+		routingKey, // routing key (queue name)
+		true,       // mandatory - return message if not routable
+		false,      // immediate
+		publishing, // persistent (required for quorum queues)
+	)
+	if err != nil {
+		d.release()
+		return nil, fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	pending := &PendingConfirm{
+		MessageID:   publishing.MessageId,
+		DeliveryTag: confirm.DeliveryTag,
+		confirm:     confirm,
+		exchange:    exchange,
+		routingKey:  routingKey,
+		done:        make(chan struct{}),
+	}
+	d.track(pending)
+	return pending, nil
+}
+
+// track hands a published message to the dispatcher. It is called with the channel
+// still borrowed, so pending stays in sequence-number order, and never blocks
+// because every tracked message holds a reserved slot.
+func (d *confirmDispatcher) track(p *PendingConfirm) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		// The channel closed after the publish, so the broker has already nacked it
+		d.resolve(p, nil)
+		return
+	}
+	d.pending <- p
+}
+
+// run resolves the tracked confirms as they arrive until the channel closes
+func (d *confirmDispatcher) run(closed <-chan *amqp.Error, returns <-chan amqp.Return, onStop func()) {
+	defer onStop()
+	for {
+		select {
+		case p := <-d.pending:
+			d.resolve(p, d.wait(p, returns))
+		case ret, ok := <-returns:
+			if !ok {
+				// Closed with the channel; a nil channel is never selected
+				returns = nil
+				continue
+			}
+			d.record(ret)
+		case <-closed:
+			// The library nacks every outstanding confirm when the channel closes
+			d.mu.Lock()
+			d.stopped = true
+			d.mu.Unlock()
+			for {
+				select {
+				case p := <-d.pending:
+					d.resolve(p, d.take(p))
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// wait blocks until the confirm of p arrives and returns the return of p, if any.
+// Returns are collected meanwhile, since an unread one holds up the whole connection.
+func (d *confirmDispatcher) wait(p *PendingConfirm, returns <-chan amqp.Return) *amqp.Return {
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			d.record(ret)
+		case <-p.confirm.Done():
+			// The broker sends the return of a message before its ack, so it is buffered by now
+			for {
+				select {
+				case ret, ok := <-returns:
+					if !ok {
+						return d.take(p)
+					}
+					d.record(ret)
+				default:
+					return d.take(p)
+				}
+			}
+		}
+	}
+}
+
+// returnKey identifies the publish a return belongs to
+type returnKey struct {
+	messageID  string
+	exchange   string
+	routingKey string
+}
+
+// record keeps a return until the confirm of its message is resolved
+func (d *confirmDispatcher) record(ret amqp.Return) {
+	key := returnKey{ret.MessageId, ret.Exchange, ret.RoutingKey}
+	d.returns[key] = append(d.returns[key], ret)
+}
+
+// take removes and returns the oldest unmatched return of p
+func (d *confirmDispatcher) take(p *PendingConfirm) *amqp.Return {
+	key := returnKey{p.MessageID, p.exchange, p.routingKey}
+	rets := d.returns[key]
+	if len(rets) == 0 {
+		return nil
+	}
+	ret := rets[0]
+	if len(rets) == 1 {
+		delete(d.returns, key)
+	} else {
+		d.returns[key] = rets[1:]
+	}
+	return &ret
+}
+
+// resolve settles the future of p, whose confirm has arrived, and frees its slot.
+// ret is the return of p when the broker could not route it.
+func (d *confirmDispatcher) resolve(p *PendingConfirm, ret *amqp.Return) {
+	<-p.confirm.Done()
+	switch {
+	case p.confirm.Acked() && ret != nil:
+		// Diverting the message publishes on another channel, which must not hold up this one
+		d.release()
+		go func() {
+			p.err = d.returned(*ret)
+			close(p.done)
+		}()
+		return
+	case p.confirm.Acked():
+	case d.ch.IsClosed():
+		p.err = ErrConfirmLost
+	default:
+		// Telling a full queue from other nacks inspects the queue, which must not hold up this channel
+		d.release()
+		go func() {
+			p.err = d.nackError(p.exchange, p.routingKey)
+			close(p.done)
+		}()
+		return
+	}
+	close(p.done)
+	d.release()
+}
+
+// initPublishChannel puts a pooled publish channel into confirm mode and starts its dispatcher
+func (r *RabbitMQ) initPublishChannel(ch *amqp.Channel) error {
+	if err := enableConfirms(ch); err != nil {
+		return err
+	}
+	d := newConfirmDispatcher(ch, r.config.ConfirmWindow, r.nackError, r.returned, func() {
+		r.dispatchers.Delete(ch)
+	})
+	r.dispatchers.Store(ch, d)
+	return nil
+}
+
+// dispatcher returns the confirm dispatcher of a borrowed publish channel
+func (r *RabbitMQ) dispatcher(ch *amqp.Channel) (*confirmDispatcher, error) {
+	d, ok := r.dispatchers.Load(ch)
+	if !ok {
+		return nil, fmt.Errorf("%w: publish channel closed", ErrNotConnected)
+	}
+	return d.(*confirmDispatcher), nil
+}
+
+// nackError describes a publish the broker nacked. A quorum queue leader also nacks
+// while it cannot commit, e.g. during a leader election, so a reject-publish queue
+// is only reported as full when it really is at its length limit.
+func (r *RabbitMQ) nackError(exchange, routingKey string) error {
+	nacked := fmt.Errorf("message not confirmed (nack received)")
+	if exchange != "" {
+		return nacked
+	}
+
+	r.mu.RLock()
+	queue, policy, ok := r.topology.rejectingQueue([]string{routingKey})
+	limits := r.topology.QueueLimits(queue)
+	r.mu.RUnlock()
+	if !ok {
+		return nacked
+	}
+
+	full, err := r.queueAtLimit(queue, limits)
+	if err != nil {
+		log.Printf("Could not check whether queue %s is full: %v", queue, err)
+	}
+	if !full {
+		return nacked
+	}
+	return queueFullError(queue, policy)
+}
@@ -0,0 +1,255 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var errTestNack = errors.New("nacked")
+
+// newTestDispatcher starts a dispatcher on a fake broker channel. Nacks resolve to
+// errTestNack and returns to an *UnroutableError; stopped is closed when it stops.
+func newTestDispatcher(t testing.TB, window int, autoAck bool) (d *confirmDispatcher, broker *fakeBroker, stopped chan struct{}) {
+	t.Helper()
+	ch, broker := newFakeChannel(t, autoAck)
+	stopped = make(chan struct{})
+	nackError := func(exchange, routingKey string) error { return errTestNack }
+	returned := func(ret amqp.Return) error {
+		return &UnroutableError{MessageID: ret.MessageId, Exchange: ret.Exchange, RoutingKey: ret.RoutingKey, ReplyCode: ret.ReplyCode}
+	}
+	d = newConfirmDispatcher(ch, window, nackError, returned, func() { close(stopped) })
+	return d, broker, stopped
+}
+
+// publishN publishes n messages with IDs m1..mn to the queue q
+func publishN(t *testing.T, d *confirmDispatcher, n int) []*PendingConfirm {
+	t.Helper()
+	pending := make([]*PendingConfirm, n)
+	for i := range pending {
+		p, err := d.publish(context.Background(), amqp.Publishing{MessageId: fmt.Sprintf("m%d", i+1), Body: []byte("body")}, "", "q")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending[i] = p
+	}
+	return pending
+}
+
+// settle waits for p to resolve and returns its error
+func settle(t *testing.T, p *PendingConfirm) error {
+	t.Helper()
+	select {
+	case <-p.Done():
+		return p.Err()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("confirm of %s (tag %d) never resolved", p.MessageID, p.DeliveryTag)
+		return nil
+	}
+}
+
+// unresolved fails the test if p is already resolved
+func unresolved(t *testing.T, p *PendingConfirm) {
+	t.Helper()
+	select {
+	case <-p.Done():
+		t.Fatalf("confirm of %s (tag %d) resolved early: %v", p.MessageID, p.DeliveryTag, p.Err())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConfirmDeliveryTags(t *testing.T) {
+	d, broker, _ := newTestDispatcher(t, 0, false)
+	pending := publishN(t, d, 3)
+	for i, p := range pending {
+		got := broker.next()
+		if p.DeliveryTag != uint64(i+1) || got.tag != p.DeliveryTag || got.messageID != p.MessageID {
+			t.Errorf("publish %d: future %s tag %d, broker got %s tag %d", i+1, p.MessageID, p.DeliveryTag, got.messageID, got.tag)
+		}
+	}
+
+	// A single ack settles only its own tag; futures resolve in publish order,
+	// so an ack that arrives out of order waits for the earlier confirms
+	broker.ack(2, false)
+	unresolved(t, pending[0])
+	unresolved(t, pending[1])
+
+	broker.nack(1, false)
+	if err := settle(t, pending[0]); !errors.Is(err, errTestNack) {
+		t.Errorf("m1 = %v, want the nack error", err)
+	}
+	if err := settle(t, pending[1]); err != nil {
+		t.Errorf("m2 = %v, want acked", err)
+	}
+	unresolved(t, pending[2])
+
+	broker.ack(3, false)
+	if err := settle(t, pending[2]); err != nil {
+		t.Errorf("m3 = %v, want acked", err)
+	}
+}
+
+func TestConfirmMultiple(t *testing.T) {
+	d, broker, _ := newTestDispatcher(t, 0, false)
+	pending := publishN(t, d, 5)
+	for range pending {
+		broker.next()
+	}
+
+	// ack multiple settles every tag up to 3, nack multiple the rest
+	broker.ack(3, true)
+	for _, p := range pending[:3] {
+		if err := settle(t, p); err != nil {
+			t.Errorf("%s = %v, want acked", p.MessageID, err)
+		}
+	}
+	unresolved(t, pending[3])
+
+	broker.nack(5, true)
+	for _, p := range pending[3:] {
+		if err := settle(t, p); !errors.Is(err, errTestNack) {
+			t.Errorf("%s = %v, want the nack error", p.MessageID, err)
+		}
+	}
+}
+
+func TestConfirmReturned(t *testing.T) {
+	d, broker, _ := newTestDispatcher(t, 0, false)
+	pending := publishN(t, d, 2)
+	broker.next()
+	second := broker.next()
+
+	// Only the returned message fails, although both are acked
+	broker.returnMessage(second)
+	broker.ack(second.tag, true)
+
+	if err := settle(t, pending[0]); err != nil {
+		t.Errorf("m1 = %v, want acked", err)
+	}
+	var unroutable *UnroutableError
+	if err := settle(t, pending[1]); !errors.As(err, &unroutable) || unroutable.MessageID != second.messageID || unroutable.RoutingKey != "q" {
+		t.Errorf("m2 = %v, want the return of %s", err, second.messageID)
+	}
+}
+
+func TestConfirmWindow(t *testing.T) {
+	d, broker, _ := newTestDispatcher(t, 2, false)
+	publishN(t, d, 2)
+	broker.next()
+	broker.next()
+
+	// The window is full until a confirm frees a slot
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := d.publish(ctx, amqp.Publishing{Body: []byte("body")}, "", "q"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("publish over the window = %v, want a deadline error", err)
+	}
+	select {
+	case p := <-broker.published:
+		t.Fatalf("publish %d reached the broker over the window", p.tag)
+	default:
+	}
+
+	broker.ack(1, false)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p, err := d.publish(ctx, amqp.Publishing{Body: []byte("body")}, "", "q")
+	if err != nil {
+		t.Fatalf("publish after an ack = %v, want room in the window", err)
+	}
+	if got := broker.next(); got.tag != 3 || p.DeliveryTag != 3 {
+		t.Errorf("publish after an ack has tag %d (broker %d), want 3", p.DeliveryTag, got.tag)
+	}
+}
+
+func TestConfirmChannelClosed(t *testing.T) {
+	d, broker, stopped := newTestDispatcher(t, 0, false)
+	pending := publishN(t, d, 3)
+	for range pending {
+		broker.next()
+	}
+	broker.ack(1, false)
+	if err := settle(t, pending[0]); err != nil {
+		t.Fatalf("m1 = %v, want acked", err)
+	}
+
+	// Closing the channel fails every publish still waiting for its confirm
+	broker.closeChannel()
+	for _, p := range pending[1:] {
+		if err := settle(t, p); !errors.Is(err, ErrConfirmLost) {
+			t.Errorf("%s = %v, want ErrConfirmLost", p.MessageID, err)
+		}
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispatcher did not stop with its channel")
+	}
+	if _, err := d.publish(context.Background(), amqp.Publishing{Body: []byte("body")}, "", "q"); err == nil {
+		t.Error("publish on a closed channel succeeded")
+	}
+}
+
+func TestPendingConfirmWait(t *testing.T) {
+	d, broker, _ := newTestDispatcher(t, 0, false)
+	p := publishN(t, d, 1)[0]
+	broker.next()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait before the ack = %v, want a deadline error", err)
+	}
+
+	// Giving up does not cancel the confirm, which still settles
+	broker.ack(1, false)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Wait(ctx); err != nil {
+		t.Errorf("Wait after the ack = %v, want nil", err)
+	}
+}
+
+// BenchmarkPublishSerial is the publish path before the dispatcher: the channel is
+// held from the publish until its confirm arrives
+func BenchmarkPublishSerial(b *testing.B) {
+	ch, _ := newFakeChannel(b, true)
+	ctx := context.Background()
+	publishing := amqp.Publishing{Body: make([]byte, 256)}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", "q", true, false, publishing)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if ok, err := confirm.WaitContext(ctx); err != nil || !ok {
+			b.Fatalf("confirm = %v %v, want an ack", ok, err)
+		}
+	}
+}
+
+// BenchmarkPublishPipelined sends every publish through the dispatcher and only
+// then waits for the confirms, with up to DefaultConfirmWindow of them in flight
+func BenchmarkPublishPipelined(b *testing.B) {
+	d, _, _ := newTestDispatcher(b, 0, true)
+	ctx := context.Background()
+	publishing := amqp.Publishing{Body: make([]byte, 256)}
+	pending := make([]*PendingConfirm, b.N)
+	b.ResetTimer()
+	for i := range pending {
+		p, err := d.publish(ctx, publishing, "", "q")
+		if err != nil {
+			b.Fatal(err)
+		}
+		pending[i] = p
+	}
+	for _, p := range pending {
+		if err := p.Wait(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
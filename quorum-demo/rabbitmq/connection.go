@@ -1,65 +1,373 @@
 package rabbitmq
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// State describes the lifecycle of the broker connection
+type State int32
+
+const (
+	StateConnecting State = iota
+	StateConnected
+	StateReconnecting
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrNotConnected is returned while the connection is down or being re-established
+var ErrNotConnected = errors.New("rabbitmq connection is not available")
+
+// Config holds the settings used to connect to RabbitMQ
+type Config struct {
+	// URLs lists the AMQP endpoints of the cluster nodes; the service
+	// connects to the first healthy one and fails over to the next
+	URLs      []string
+	QueueName string
+
+	// PoolSize is the number of channels kept open in each of the
+	// publish and consume pools (DefaultPoolSize when zero)
+	PoolSize int
+
+	// ConfirmWindow is the number of publishes that may await their broker
+	// confirm on each publish channel (DefaultConfirmWindow when zero)
+	ConfirmWindow int
+
+	// TLS enables TLS (and mutual TLS with a client certificate) for amqps:// URLs
+	TLS *TLSConfig
+
+	// Topology is declared on every (re)connect; DefaultTopology(QueueName) when nil
+	Topology *Topology
+
+	// DeliveryLimit sets x-delivery-limit on the built-in topology: a message returned
+	// to the queue more often is dead-lettered, or dropped without DeadLetter (0 = unset)
+	DeliveryLimit int
+
+	// DeadLetter adds a Dead Letter Exchange and a quorum DLQ to the built-in topology
+	DeadLetter DeadLetterConfig
+
+	// AlternateExchange receives the messages the broker returns as unroutable: the
+	// service republishes them there with their original routing key. Unless the
+	// topology declares it, it is added as a fanout exchange bound to UnroutableQueue.
+	AlternateExchange string
+
+	// Limits sets TTL, length limits, expiry and overflow policy per queue of the
+	// topology, keyed by queue name; the "" key stands for QueueName
+	Limits map[string]QueueLimits
+
+	// InitialGroupSize sets x-quorum-initial-group-size on the quorum queues of the
+	// built-in topology: how many replicas a new queue gets (0 leaves it to RabbitMQ)
+	InitialGroupSize int
+
+	// ClusterSize reports the number of cluster nodes, e.g. from the management API.
+	// When set and the topology sets x-quorum-initial-group-size, a size larger than
+	// the cluster, or a cluster that cannot be measured, fails NewRabbitMQWithQuorum
+	// before the topology is declared. It is only checked there, not on reconnect:
+	// the argument applies when a queue is created, and by then the queues exist.
+	ClusterSize func(ctx context.Context) (int, error)
+
+	// QueueBytes reports the body size of the ready messages of a queue, e.g. from the
+	// management API. A nack from a queue with only x-max-length-bytes is reported as
+	// ErrQueueFull only when it says the limit is reached.
+	QueueBytes func(ctx context.Context, queue string) (int64, error)
+}
+
 type RabbitMQ struct {
-	Connection *amqp.Connection
-	Channel    *amqp.Channel
-	QueueName  string
+	QueueName string
+
+	config    Config
+	tlsConfig *tls.Config
+	topology  *Topology
+	backoff   Backoff
+
+	// dispatchers maps each open publish channel to its *confirmDispatcher
+	dispatchers sync.Map
+
+	mu          sync.RWMutex
+	publishConn *amqp.Connection
+	consumeConn *amqp.Connection
+	publishPool *channelPool
+	consumePool *channelPool
+	state       State
+	activeNode  int // index in config.URLs of the connected node, -1 when disconnected
+	nextNode    int // index in config.URLs to try first on the next connect
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRabbitMQWithQuorum creates a new RabbitMQ connection with Quorum Queue support.
+// Publishing and consuming use separate connections, each with its own pool of channels.
+// The connections are watched and re-established automatically if the broker goes away.
+func NewRabbitMQWithQuorum(cfg Config) (*RabbitMQ, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, fmt.Errorf("at least one RabbitMQ URL is required")
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		for _, u := range cfg.URLs {
+			if err := checkTLSURL(u); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if tlsConfig, err = cfg.TLS.Build(); err != nil {
+			return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+	}
+
+	topology := cfg.Topology
+	if topology == nil {
+		var err error
+		if topology, err = QuorumTopology(cfg.QueueName, cfg.DeliveryLimit, cfg.DeadLetter); err != nil {
+			return nil, err
+		}
+	} else if cfg.DeliveryLimit != 0 || cfg.DeadLetter.Enabled() || cfg.InitialGroupSize != 0 {
+		return nil, fmt.Errorf("delivery limit, dead letter and initial group size settings apply to the built-in topology; declare them in the topology instead")
+	}
+	if !topology.HasQueue(cfg.QueueName) {
+		return nil, fmt.Errorf("topology does not declare queue %q", cfg.QueueName)
+	}
+	if len(cfg.Limits) > 0 {
+		var err error
+		if topology, err = topology.WithLimits(cfg.QueueName, cfg.Limits); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.AlternateExchange != "" {
+		var err error
+		if topology, err = topology.WithAlternateExchange(cfg.AlternateExchange, UnroutableQueue(cfg.QueueName)); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.InitialGroupSize != 0 {
+		var err error
+		if topology, err = topology.WithInitialGroupSize(cfg.InitialGroupSize); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &RabbitMQ{
+		QueueName:  cfg.QueueName,
+		config:     cfg,
+		tlsConfig:  tlsConfig,
+		topology:   topology,
+		backoff:    DefaultBackoff,
+		state:      StateConnecting,
+		activeNode: -1,
+		done:       make(chan struct{}),
+	}
+
+	if err := r.checkClusterSize(); err != nil {
+		return nil, err
+	}
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("✓ Connected to RabbitMQ with Quorum Queue support")
+	log.Printf("  Queue: %s (type: quorum)", cfg.QueueName)
+	log.Printf("  Active node: %s", r.ActiveNode())
+	if policy := r.PoisonPolicy(); policy.DeliveryLimit > 0 || policy.DeadLetterQueue != "" {
+		log.Printf("  Delivery limit: %d, DLQ: %q (%s)", policy.DeliveryLimit, policy.DeadLetterQueue, policy.Strategy)
+	}
+	if cfg.AlternateExchange != "" {
+		log.Printf("  Alternate exchange for unroutable messages: %s", cfg.AlternateExchange)
+	}
+
+	return r, nil
+}
+
+// connect tries each cluster node once, starting with r.nextNode,
+// and stays on the first one that accepts the connection
+func (r *RabbitMQ) connect() error {
+	r.mu.RLock()
+	start := r.nextNode
+	r.mu.RUnlock()
+
+	var errs []error
+	for i := range r.config.URLs {
+		node := (start + i) % len(r.config.URLs)
+		err := r.connectNode(node)
+		if err == nil {
+			return nil
+		}
+		log.Printf("✗ Node %s unavailable: %v", nodeAddress(r.config.URLs[node]), err)
+		errs = append(errs, fmt.Errorf("%s: %w", nodeAddress(r.config.URLs[node]), err))
+	}
+
+	return fmt.Errorf("no RabbitMQ node available: %w", errors.Join(errs...))
 }
 
-// NewRabbitMQWithQuorum creates a new RabbitMQ connection with Quorum Queue support
-func NewRabbitMQWithQuorum(url, queueName string) (*RabbitMQ, error) {
-	// Connect to RabbitMQ
-	conn, err := amqp.Dial(url)
+// connectNode dials one node, declares the topology and opens the channel pools
+func (r *RabbitMQ) connectNode(node int) error {
+	url := r.config.URLs[node]
+
+	// Connect to RabbitMQ, once for publishing and once for consuming
+	publishConn, err := dial(url, r.tlsConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	consumeConn, err := dial(url, r.tlsConfig)
+	if err != nil {
+		publishConn.Close()
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	closeAll := func() {
+		publishConn.Close()
+		consumeConn.Close()
+	}
+
+	// Declare the topology so a restarted broker gets it back
+	if err := r.topology.Declare(publishConn); err != nil {
+		closeAll()
+		return err
+	}
+
+	// Every publish channel runs in confirm mode with its own confirm dispatcher
+	publishPool, err := newChannelPool("publish", publishConn, r.config.PoolSize, r.initPublishChannel)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+		closeAll()
+		return err
 	}
 
-	// Create a channel
-	ch, err := conn.Channel()
+	consumePool, err := newChannelPool("consume", consumeConn, r.config.PoolSize, nil)
 	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("failed to open a channel: %w", err)
+		closeAll()
+		return err
 	}
 
+	r.mu.Lock()
+	if r.state == StateClosed {
+		// Close was called while we were dialing
+		r.mu.Unlock()
+		closeAll()
+		return nil
+	}
+	r.publishConn = publishConn
+	r.consumeConn = consumeConn
+	r.publishPool = publishPool
+	r.consumePool = consumePool
+	r.state = StateConnected
+	r.activeNode = node
+	r.nextNode = node
+	r.mu.Unlock()
+
+	go r.watch(publishConn, consumeConn)
+
+	return nil
+}
+
+// enableConfirms puts a publish channel into confirm mode
+func enableConfirms(ch *amqp.Channel) error {
 	// Enable publisher confirmations
 	if err := ch.Confirm(false); err != nil {
-		ch.Close()
-		conn.Close()
-		return nil, fmt.Errorf("failed to enable publisher confirmations: %w", err)
+		return fmt.Errorf("failed to enable publisher confirmations: %w", err)
 	}
-	log.Println("✓ Publisher confirmations enabled")
+	return nil
+}
+
+// State returns the current connection state
+func (r *RabbitMQ) State() State {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state
+}
 
-	// Setup Quorum Queue
-	if err := SetupQuorumQueue(ch, queueName); err != nil {
-		ch.Close()
-		conn.Close()
-		return nil, fmt.Errorf("failed to setup quorum queue: %w", err)
+// pools returns the active publish and consume pools or ErrNotConnected while reconnecting
+func (r *RabbitMQ) pools() (*channelPool, *channelPool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.state != StateConnected {
+		return nil, nil, fmt.Errorf("%w (state: %s)", ErrNotConnected, r.state)
 	}
+	return r.publishPool, r.consumePool, nil
+}
 
-	log.Printf("✓ Connected to RabbitMQ with Quorum Queue support")
-	log.Printf("  Queue: %s (type: quorum)", queueName)
+// borrowPublish borrows a channel from the publish pool; return it with pool.put
+func (r *RabbitMQ) borrowPublish(ctx context.Context) (*channelPool, *amqp.Channel, error) {
+	pool, _, err := r.pools()
+	if err != nil {
+		return nil, nil, err
+	}
+	ch, err := pool.get(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pool, ch, nil
+}
 
-	return &RabbitMQ{
-		Connection: conn,
-		Channel:    ch,
-		QueueName:  queueName,
-	}, nil
+// borrowConsume borrows a channel from the consume pool; return it with pool.put
+func (r *RabbitMQ) borrowConsume(ctx context.Context) (*channelPool, *amqp.Channel, error) {
+	_, pool, err := r.pools()
+	if err != nil {
+		return nil, nil, err
+	}
+	ch, err := pool.get(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pool, ch, nil
 }
 
-// Close closes the channel and connection
+// Close closes the channel pools and connections
 func (r *RabbitMQ) Close() {
-	if r.Channel != nil {
-		r.Channel.Close()
+	r.closeOnce.Do(func() {
+		close(r.done)
+
+		r.mu.Lock()
+		r.state = StateClosed
+		r.release()
+		r.mu.Unlock()
+
+		log.Println("RabbitMQ connection closed")
+	})
+}
+
+// release closes the pools and connections; the caller must hold r.mu
+func (r *RabbitMQ) release() {
+	if r.publishPool != nil {
+		r.publishPool.close()
+	}
+	if r.consumePool != nil {
+		r.consumePool.close()
+	}
+	if r.publishConn != nil {
+		r.publishConn.Close()
 	}
-	if r.Connection != nil {
-		r.Connection.Close()
+	if r.consumeConn != nil {
+		r.consumeConn.Close()
+	}
+	r.publishPool, r.consumePool = nil, nil
+	r.publishConn, r.consumeConn = nil, nil
+
+	// Fail over: the next connect starts with the node after the one we lost
+	if r.activeNode >= 0 {
+		r.nextNode = (r.activeNode + 1) % len(r.config.URLs)
 	}
-	log.Println("RabbitMQ connection closed")
+	r.activeNode = -1
 }
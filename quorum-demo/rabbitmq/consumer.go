@@ -1,85 +1,328 @@
 package rabbitmq
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// ErrNoMessages is returned when the queue is empty
+	ErrNoMessages = errors.New("no messages available in queue")
+
+	// ErrDLQEmpty is returned when the Dead Letter Queue is empty
+	ErrDLQEmpty = errors.New("no messages available in DLQ")
+
+	// ErrNoDeadLetter is returned for FailDeadLetter when the queue has no Dead Letter Queue
+	ErrNoDeadLetter = errors.New("queue has no dead letter exchange configured")
+
+	// ErrInvalidFailAction is returned for a FailAction other than requeue, dead-letter and discard
+	ErrInvalidFailAction = errors.New("invalid fail action")
 )
 
-// MessageWithTag represents a message with its delivery tag for manual ack
+// MessageWithTag represents a message with its delivery tag for manual ack.
+// It must be settled with AckMessage or NackMessage.
 type MessageWithTag struct {
-	Body        string
+	Delivery
 	DeliveryTag uint64
+
+	acker acknowledger
+}
+
+// acknowledger settles a message received without auto-ack
+type acknowledger interface {
+	ack(tag uint64) error
+	nack(tag uint64, requeue bool) error
+}
+
+// channelAcknowledger settles messages on the pooled channel they were received on,
+// since delivery tags are only valid there, and then returns the channel to its pool
+type channelAcknowledger struct {
+	ch   *amqp.Channel
+	pool *channelPool
 }
 
-// ConsumeWithManualAck consumes a message without auto-ack
+func (a channelAcknowledger) ack(tag uint64) error {
+	defer a.pool.put(a.ch)
+	return a.ch.Ack(tag, false)
+}
+
+func (a channelAcknowledger) nack(tag uint64, requeue bool) error {
+	defer a.pool.put(a.ch)
+	return a.ch.Nack(tag, false, requeue)
+}
+
+// ConsumeWithManualAck consumes a message without auto-ack.
+// The returned message must be passed to AckMessage or NackMessage.
 func (r *RabbitMQ) ConsumeWithManualAck() (*MessageWithTag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	pool, ch, err := r.borrowConsume(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get a single message without auto-ack
-	msg, ok, err := r.Channel.Get(
+	msg, ok, err := ch.Get(
 		r.QueueName, // queue
 		false,       // auto-ack = false (manual acknowledgment)
 	)
 	if err != nil {
+		pool.put(ch)
 		return nil, fmt.Errorf("failed to consume message: %w", err)
 	}
 
 	if !ok {
-		return nil, fmt.Errorf("no messages available in queue")
+		pool.put(ch)
+		return nil, ErrNoMessages
 	}
 
 	return &MessageWithTag{
-		Body:        string(msg.Body),
+		Delivery:    *newDelivery(msg),
 		DeliveryTag: msg.DeliveryTag,
+		acker:       channelAcknowledger{ch: ch, pool: pool},
 	}, nil
 }
 
 // AckMessage acknowledges a message (confirms successful processing)
-func (r *RabbitMQ) AckMessage(deliveryTag uint64) error {
-	err := r.Channel.Ack(deliveryTag, false)
+func (r *RabbitMQ) AckMessage(msg *MessageWithTag) error {
+	return ackMessage(msg)
+}
+
+// NackMessage negatively acknowledges a message (rejects it)
+func (r *RabbitMQ) NackMessage(msg *MessageWithTag, requeue bool) error {
+	return nackMessage(msg, requeue)
+}
+
+func ackMessage(msg *MessageWithTag) error {
+	err := msg.acker.ack(msg.DeliveryTag)
 	if err != nil {
 		return fmt.Errorf("failed to ack message: %w", err)
 	}
-	log.Printf("✓ Message acknowledged (delivery tag: %d)", deliveryTag)
+	log.Printf("✓ Message acknowledged (delivery tag: %d)", msg.DeliveryTag)
 	return nil
 }
 
-// NackMessage negatively acknowledges a message (rejects it)
-func (r *RabbitMQ) NackMessage(deliveryTag uint64, requeue bool) error {
-	err := r.Channel.Nack(deliveryTag, false, requeue)
+func nackMessage(msg *MessageWithTag, requeue bool) error {
+	err := msg.acker.nack(msg.DeliveryTag, requeue)
 	if err != nil {
 		return fmt.Errorf("failed to nack message: %w", err)
 	}
 	if requeue {
-		log.Printf("✗ Message rejected and requeued (delivery tag: %d)", deliveryTag)
+		log.Printf("✗ Message rejected and requeued (delivery tag: %d)", msg.DeliveryTag)
 	} else {
-		log.Printf("✗ Message rejected without requeue (delivery tag: %d)", deliveryTag)
+		log.Printf("✗ Message rejected without requeue (delivery tag: %d)", msg.DeliveryTag)
 	}
 	return nil
 }
 
 // ConsumeAndAck consumes a message and immediately acknowledges it
-func (r *RabbitMQ) ConsumeAndAck() (string, error) {
-	msg, err := r.ConsumeWithManualAck()
+func (r *RabbitMQ) ConsumeAndAck() (*Delivery, error) {
+	return consumeAndAck(r)
+}
+
+// ConsumeAndNack consumes a message and rejects it (simulates processing failure)
+func (r *RabbitMQ) ConsumeAndNack(requeue bool) (*Delivery, error) {
+	return consumeAndNack(r, requeue)
+}
+
+// FailAction is what to do with a message whose processing failed
+type FailAction string
+
+const (
+	// FailRequeue nacks with requeue; the quorum queue counts the return in
+	// x-delivery-count and dead-letters or drops the message past x-delivery-limit
+	FailRequeue FailAction = "requeue"
+	// FailDeadLetter nacks without requeue so the message goes to the Dead Letter Queue
+	FailDeadLetter FailAction = "dead-letter"
+	// FailDiscard acknowledges the message so it is removed for good
+	FailDiscard FailAction = "discard"
+)
+
+// FailOutcome is where a failed message ended up
+type FailOutcome string
+
+const (
+	OutcomeRequeued     FailOutcome = "requeued"
+	OutcomeDeadLettered FailOutcome = "dead_lettered"
+	OutcomeDropped      FailOutcome = "dropped" // over the delivery limit with no DLQ
+	OutcomeDiscarded    FailOutcome = "discarded"
+)
+
+// FailResult describes a message consumed and settled by ConsumeAndFail
+type FailResult struct {
+	Action  FailAction  `json:"action"`
+	Outcome FailOutcome `json:"outcome"`
+
+	// Queue is the Dead Letter Queue when the message was dead-lettered
+	Queue string `json:"queue,omitempty"`
+
+	// DeliveryLimit is the x-delivery-limit of the queue; 0 when unset
+	DeliveryLimit int `json:"delivery_limit,omitempty"`
+
+	Delivery *Delivery `json:"delivery"`
+}
+
+// PoisonPolicy reports the delivery limit and Dead Letter Queue of the main queue
+func (r *RabbitMQ) PoisonPolicy() PoisonPolicy {
+	return r.topology.poisonPolicy(r.QueueName)
+}
+
+// ConsumeAndFail consumes a message and settles it as a processing failure according to action
+func (r *RabbitMQ) ConsumeAndFail(action FailAction) (*FailResult, error) {
+	return consumeAndFail(r, r.PoisonPolicy(), action)
+}
+
+// ConsumeFromDLQ consumes a message from the Dead Letter Queue
+func (r *RabbitMQ) ConsumeFromDLQ() (*Delivery, error) {
+	dlq := r.PoisonPolicy().DeadLetterQueue
+	if dlq == "" {
+		return nil, ErrNoDeadLetter
+	}
+	return r.consumeFrom(dlq, ErrDLQEmpty)
+}
+
+// consumeFrom gets a single message from queue with auto-ack, or empty when there is none
+func (r *RabbitMQ) consumeFrom(queue string, empty error) (*Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	pool, ch, err := r.borrowConsume(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer pool.put(ch)
 
-	if err := r.AckMessage(msg.DeliveryTag); err != nil {
-		return "", err
+	msg, ok, err := ch.Get(
+		queue, // queue
+		true,  // auto-ack
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume from %s: %w", queue, err)
 	}
 
-	return msg.Body, nil
+	if !ok {
+		return nil, empty
+	}
+
+	return newDelivery(msg), nil
 }
 
-// ConsumeAndNack consumes a message and rejects it (simulates processing failure)
-func (r *RabbitMQ) ConsumeAndNack(requeue bool) (string, error) {
-	msg, err := r.ConsumeWithManualAck()
+// consumeAndFail gets a message from b and settles it as a failure under policy.
+// The outcome of a requeue is predicted from the delivery count the message had:
+// the broker increments it on the return and gives up once it exceeds the limit.
+func consumeAndFail(b Broker, policy PoisonPolicy, action FailAction) (*FailResult, error) {
+	switch action {
+	case FailRequeue, FailDiscard:
+	case FailDeadLetter:
+		if policy.DeadLetterQueue == "" {
+			return nil, ErrNoDeadLetter
+		}
+	default:
+		return nil, fmt.Errorf("%w %q (want requeue, dead-letter or discard)", ErrInvalidFailAction, action)
+	}
+
+	msg, err := b.ConsumeWithManualAck()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if err := r.NackMessage(msg.DeliveryTag, requeue); err != nil {
-		return "", err
+	result := &FailResult{Action: action, DeliveryLimit: policy.DeliveryLimit, Delivery: &msg.Delivery}
+	switch action {
+	case FailDiscard:
+		err = b.AckMessage(msg)
+		result.Outcome = OutcomeDiscarded
+	case FailDeadLetter:
+		err = b.NackMessage(msg, false)
+		result.Outcome = OutcomeDeadLettered
+	default:
+		err = b.NackMessage(msg, true)
+		result.Outcome = OutcomeRequeued
+		if policy.DeliveryLimit > 0 && msg.DeliveryCount+1 > policy.DeliveryLimit {
+			result.Outcome = OutcomeDropped
+			if policy.DeadLetterQueue != "" {
+				result.Outcome = OutcomeDeadLettered
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if result.Outcome == OutcomeDeadLettered {
+		result.Queue = policy.DeadLetterQueue
+	}
+
+	log.Printf("Failed message %s (delivery count %d): %s", msg.MessageID, msg.DeliveryCount, result.Outcome)
+	return result, nil
+}
+
+// consumeAndAck gets a message from b and acknowledges it
+func consumeAndAck(b Broker) (*Delivery, error) {
+	msg, err := b.ConsumeWithManualAck()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.AckMessage(msg); err != nil {
+		return nil, err
+	}
+
+	return &msg.Delivery, nil
+}
+
+// consumeAndNack gets a message from b and rejects it
+func consumeAndNack(b Broker, requeue bool) (*Delivery, error) {
+	msg, err := b.ConsumeWithManualAck()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.NackMessage(msg, requeue); err != nil {
+		return nil, err
+	}
+
+	return &msg.Delivery, nil
+}
+
+// startConsumer opens a dedicated channel on the consume connection, limits it to
+// prefetch unacknowledged deliveries and starts a manual-ack consumer on queue.
+// Pooled channels are not used because a consumer keeps its channel for as long as it runs.
+func (r *RabbitMQ) startConsumer(queue, consumerTag string, prefetch int) (*amqp.Channel, <-chan amqp.Delivery, error) {
+	r.mu.RLock()
+	conn, state := r.consumeConn, r.state
+	r.mu.RUnlock()
+	if state != StateConnected {
+		return nil, nil, fmt.Errorf("%w (state: %s)", ErrNotConnected, state)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open a consumer channel: %w", err)
+	}
+
+	// Limit the unacknowledged deliveries the broker pushes to this consumer
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to set prefetch: %w", err)
+	}
+
+	deliveries, err := ch.Consume(
+		queue,       // queue
+		consumerTag, // consumer
+		false,       // auto-ack
+		false,       // exclusive
+		false,       // no-local
+		false,       // no-wait
+		nil,         // args
+	)
+	if err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to start consumer: %w", err)
 	}
 
-	return msg.Body, nil
+	return ch, deliveries, nil
 }
@@ -0,0 +1,284 @@
+package rabbitmq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// AMQP 0-9-1 frame types
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xCE
+)
+
+// fakeBroker speaks just enough AMQP 0-9-1 to open a connection and a channel in
+// confirm mode, so the confirm dispatcher can run on a real *amqp.Channel.
+// The test decides when each publish is acked, nacked or returned, unless autoAck is set.
+type fakeBroker struct {
+	t       testing.TB
+	conn    net.Conn
+	autoAck bool
+
+	// published receives every publish when autoAck is not set
+	published chan fakePublish
+
+	mu sync.Mutex // serialises frame writes
+}
+
+// fakePublish is a message published on the fake broker
+type fakePublish struct {
+	tag        uint64
+	exchange   string
+	routingKey string
+	messageID  string
+	body       []byte
+}
+
+// newFakeChannel connects to a new fake broker and opens a channel in confirm mode
+func newFakeChannel(t testing.TB, autoAck bool) (*amqp.Channel, *fakeBroker) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{t: t, autoAck: autoAck, published: make(chan fakePublish, 64)}
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			accepted <- err
+			return
+		}
+		b.conn = conn
+		accepted <- nil
+		b.serve()
+	}()
+
+	conn, err := amqp.DialConfig(fmt.Sprintf("amqp://guest:guest@%s/?heartbeat=0", ln.Addr()), amqp.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-accepted; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		b.conn.Close()
+	})
+
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		t.Fatal(err)
+	}
+	return ch, b
+}
+
+// serve answers the client until the connection closes
+func (b *fakeBroker) serve() {
+	r := bufio.NewReader(b.conn)
+	if _, err := io.ReadFull(r, make([]byte, 8)); err != nil { // protocol header
+		return
+	}
+	b.method(0, 10, 10, octet(0), octet(9), table(), longstr("PLAIN"), longstr("en_US")) // connection.start
+
+	var tag uint64
+	var msg *fakePublish
+	var size uint64
+	for {
+		typ, channel, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case frameMethod:
+			class, method, args := binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:]), payload[4:]
+			switch {
+			case class == 10 && method == 11: // connection.start-ok
+				b.method(0, 10, 30, short(0), long(131072), short(0)) // connection.tune
+			case class == 10 && method == 40: // connection.open
+				b.method(0, 10, 41, shortstr(""))
+			case class == 10 && method == 50: // connection.close
+				b.method(0, 10, 51)
+				return
+			case class == 20 && method == 10: // channel.open
+				b.method(channel, 20, 11, longstr(""))
+			case class == 20 && method == 40: // channel.close
+				b.method(channel, 20, 41)
+			case class == 85 && method == 10: // confirm.select
+				b.method(channel, 85, 11)
+			case class == 60 && method == 40: // basic.publish
+				tag++
+				exchange, rest := readShortstr(args[2:])
+				routingKey, _ := readShortstr(rest)
+				msg = &fakePublish{tag: tag, exchange: exchange, routingKey: routingKey}
+			}
+		case frameHeader:
+			size = binary.BigEndian.Uint64(payload[4:])
+			msg.messageID = propertyMessageID(payload[12:])
+			if size == 0 {
+				b.receive(*msg)
+			}
+		case frameBody:
+			msg.body = append(msg.body, payload...)
+			if uint64(len(msg.body)) >= size {
+				b.receive(*msg)
+			}
+		}
+	}
+}
+
+// receive hands a complete publish to the test, or acks it right away
+func (b *fakeBroker) receive(p fakePublish) {
+	if b.autoAck {
+		b.ack(p.tag, false)
+		return
+	}
+	b.published <- p
+}
+
+// next returns the next publish the broker received
+func (b *fakeBroker) next() fakePublish {
+	b.t.Helper()
+	select {
+	case p := <-b.published:
+		return p
+	case <-time.After(5 * time.Second):
+		b.t.Fatal("timed out waiting for a publish")
+		return fakePublish{}
+	}
+}
+
+// ack confirms the publish with tag, and every earlier one when multiple is set
+func (b *fakeBroker) ack(tag uint64, multiple bool) {
+	b.method(1, 60, 80, longlong(tag), bits(multiple))
+}
+
+// nack refuses the publish with tag, and every earlier one when multiple is set
+func (b *fakeBroker) nack(tag uint64, multiple bool) {
+	b.method(1, 60, 120, longlong(tag), bits(multiple))
+}
+
+// returnMessage sends p back to the client as unroutable; like RabbitMQ, it must come before the ack
+func (b *fakeBroker) returnMessage(p fakePublish) {
+	header := concat(short(60), short(0), longlong(uint64(len(p.body))), short(1<<7), shortstr(p.messageID))
+	b.write(
+		frame(frameMethod, 1, concat(short(60), short(50), short(312), shortstr("NO_ROUTE"), shortstr(p.exchange), shortstr(p.routingKey))),
+		frame(frameHeader, 1, header),
+		frame(frameBody, 1, p.body),
+	)
+}
+
+// closeChannel closes the channel from the broker side, as a channel error would
+func (b *fakeBroker) closeChannel() {
+	b.method(1, 20, 40, short(406), shortstr("PRECONDITION_FAILED - test"), short(0), short(0))
+}
+
+// method writes a method frame on channel
+func (b *fakeBroker) method(channel, class, method uint16, args ...[]byte) {
+	b.write(frame(frameMethod, channel, concat(append([][]byte{short(class), short(method)}, args...)...)))
+}
+
+// write sends frames back to back, so a returned message is not split by another frame
+func (b *fakeBroker) write(frames ...[]byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, f := range frames {
+		if _, err := b.conn.Write(f); err != nil {
+			return
+		}
+	}
+}
+
+// readFrame reads one frame, skipping heartbeats
+func readFrame(r *bufio.Reader) (typ byte, channel uint16, payload []byte, err error) {
+	for {
+		head := make([]byte, 7)
+		if _, err := io.ReadFull(r, head); err != nil {
+			return 0, 0, nil, err
+		}
+		payload = make([]byte, binary.BigEndian.Uint32(head[3:])+1)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return 0, 0, nil, err
+		}
+		if payload[len(payload)-1] != frameEnd {
+			return 0, 0, nil, errors.New("frame without frame-end")
+		}
+		if head[0] != frameHeartbeat {
+			return head[0], binary.BigEndian.Uint16(head[1:]), payload[:len(payload)-1], nil
+		}
+	}
+}
+
+// propertyMessageID reads the message-id from the property flags and list of a content header
+func propertyMessageID(props []byte) string {
+	flags := binary.BigEndian.Uint16(props)
+	props = props[2:]
+	for bit := uint16(15); bit >= 7; bit-- {
+		if flags&(1<<bit) == 0 {
+			continue
+		}
+		switch bit {
+		case 13: // headers
+			props = props[4+binary.BigEndian.Uint32(props):]
+		case 12, 11: // delivery-mode, priority
+			props = props[1:]
+		case 7:
+			id, _ := readShortstr(props)
+			return id
+		default: // content-type, content-encoding, correlation-id, reply-to, expiration
+			_, props = readShortstr(props)
+		}
+	}
+	return ""
+}
+
+func readShortstr(b []byte) (string, []byte) {
+	n := int(b[0])
+	return string(b[1 : 1+n]), b[1+n:]
+}
+
+func frame(typ byte, channel uint16, payload []byte) []byte {
+	return concat([]byte{typ}, short(channel), long(uint32(len(payload))), payload, []byte{frameEnd})
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func octet(v byte) []byte { return []byte{v} }
+
+func short(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+
+func long(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+func longlong(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func shortstr(s string) []byte { return append([]byte{byte(len(s))}, s...) }
+
+func longstr(s string) []byte { return append(long(uint32(len(s))), s...) }
+
+func table() []byte { return long(0) }
+
+func bits(set bool) []byte {
+	if set {
+		return []byte{1}
+	}
+	return []byte{0}
+}
@@ -0,0 +1,344 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrQueueFull is returned when a queue with a reject-publish overflow policy
+// is at its length limit and the broker nacked the message
+var ErrQueueFull = errors.New("queue is full")
+
+// ErrInvalidLimits is returned for queue limits the broker would refuse
+var ErrInvalidLimits = errors.New("invalid queue limits")
+
+// OverflowPolicy is what a queue does with a new message once it reaches x-max-length or x-max-length-bytes
+type OverflowPolicy string
+
+const (
+	// OverflowDropHead drops (or dead-letters) the oldest messages; the broker default
+	OverflowDropHead OverflowPolicy = "drop-head"
+	// OverflowRejectPublish refuses the new message with a publisher nack
+	OverflowRejectPublish OverflowPolicy = "reject-publish"
+	// OverflowRejectPublishDLX refuses the new message and dead-letters it
+	OverflowRejectPublishDLX OverflowPolicy = "reject-publish-dlx"
+)
+
+// rejects reports whether the policy nacks publishes to a full queue
+func (p OverflowPolicy) rejects() bool {
+	return p == OverflowRejectPublish || p == OverflowRejectPublishDLX
+}
+
+// QueueLimits are the size and lifetime limits of a queue, declared as queue arguments.
+// Zero fields are left out, so the broker applies no limit.
+type QueueLimits struct {
+	MessageTTL     time.Duration  // x-message-ttl: messages expire (and are dead-lettered) after this long
+	MaxLength      int64          // x-max-length: ready messages
+	MaxLengthBytes int64          // x-max-length-bytes: total body size of the ready messages
+	Expires        time.Duration  // x-expires: the queue is deleted after being unused this long
+	Overflow       OverflowPolicy // x-overflow: drop-head when empty
+}
+
+// Validate checks the limits against what the broker accepts
+func (l QueueLimits) Validate() error {
+	var problems []string
+	if l.MessageTTL < 0 || l.MessageTTL%time.Millisecond != 0 {
+		problems = append(problems, fmt.Sprintf("message TTL %s must be a whole, non-negative number of milliseconds", l.MessageTTL))
+	}
+	if l.Expires < 0 || l.Expires%time.Millisecond != 0 {
+		problems = append(problems, fmt.Sprintf("queue expiry %s must be a whole, non-negative number of milliseconds", l.Expires))
+	}
+	if l.MaxLength < 0 {
+		problems = append(problems, fmt.Sprintf("max length %d cannot be negative", l.MaxLength))
+	}
+	if l.MaxLengthBytes < 0 {
+		problems = append(problems, fmt.Sprintf("max length bytes %d cannot be negative", l.MaxLengthBytes))
+	}
+	switch l.Overflow {
+	case "", OverflowDropHead, OverflowRejectPublish, OverflowRejectPublishDLX:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown overflow policy %q (want drop-head, reject-publish or reject-publish-dlx)", l.Overflow))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidLimits, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// arguments returns the queue arguments that declare the limits
+func (l QueueLimits) arguments() map[string]interface{} {
+	args := make(map[string]interface{})
+	if l.MessageTTL > 0 {
+		args["x-message-ttl"] = l.MessageTTL.Milliseconds()
+	}
+	if l.MaxLength > 0 {
+		args["x-max-length"] = l.MaxLength
+	}
+	if l.MaxLengthBytes > 0 {
+		args["x-max-length-bytes"] = l.MaxLengthBytes
+	}
+	if l.Expires > 0 {
+		args["x-expires"] = l.Expires.Milliseconds()
+	}
+	if l.Overflow != "" {
+		args["x-overflow"] = string(l.Overflow)
+	}
+	return args
+}
+
+// queueLimits reads the limits back from queue arguments, as declared in code or in a topology file
+func queueLimits(args map[string]interface{}) QueueLimits {
+	integer := func(key string) int64 {
+		switch v := toAMQPValue(args[key]).(type) {
+		case int64:
+			return v
+		case float64:
+			return int64(v)
+		}
+		return 0
+	}
+	overflow, _ := args["x-overflow"].(string)
+	return QueueLimits{
+		MessageTTL:     time.Duration(integer("x-message-ttl")) * time.Millisecond,
+		MaxLength:      integer("x-max-length"),
+		MaxLengthBytes: integer("x-max-length-bytes"),
+		Expires:        time.Duration(integer("x-expires")) * time.Millisecond,
+		Overflow:       OverflowPolicy(overflow),
+	}
+}
+
+// overflow decides what a queue holding messages of the given body sizes does with a
+// new message of size bytes: reject it or, once it is appended, drop the first drop
+// messages (the new one included when it alone exceeds x-max-length-bytes)
+func (l QueueLimits) overflow(sizes []int, size int) (reject bool, drop int) {
+	count, total := int64(len(sizes))+1, int64(size)
+	for _, s := range sizes {
+		total += int64(s)
+	}
+	over := func() bool {
+		return (l.MaxLength > 0 && count > l.MaxLength) || (l.MaxLengthBytes > 0 && total > l.MaxLengthBytes)
+	}
+	if !over() {
+		return false, 0
+	}
+	if l.Overflow.rejects() {
+		return true, 0
+	}
+
+	sizes = append(sizes, size)
+	for over() && drop < len(sizes) {
+		count--
+		total -= int64(sizes[drop])
+		drop++
+	}
+	return false, drop
+}
+
+// queueArgumentProblems lists the limit arguments of q the broker would refuse
+func queueArgumentProblems(q QueueSpec) []string {
+	var problems []string
+	for _, key := range []string{"x-message-ttl", "x-max-length", "x-max-length-bytes", "x-expires"} {
+		value, ok := q.Arguments[key]
+		if !ok {
+			continue
+		}
+		if n, ok := toAMQPValue(value).(int64); !ok || n < 0 || (key == "x-expires" && n == 0) {
+			problems = append(problems, fmt.Sprintf("queue %q has invalid %s %v", q.Name, key, value))
+		}
+	}
+
+	overflow, hasOverflow := q.Arguments["x-overflow"]
+	if hasOverflow {
+		policy, _ := overflow.(string)
+		if err := (QueueLimits{Overflow: OverflowPolicy(policy)}).Validate(); err != nil || policy == "" {
+			problems = append(problems, fmt.Sprintf("queue %q has unknown x-overflow %v", q.Name, overflow))
+		}
+	}
+
+	switch q.Arguments["x-queue-type"] {
+	case "quorum":
+		if overflow == string(OverflowRejectPublishDLX) {
+			problems = append(problems, fmt.Sprintf("quorum queue %q does not support x-overflow reject-publish-dlx", q.Name))
+		}
+		if value, ok := q.Arguments["x-delivery-limit"]; ok {
+			if n, ok := toAMQPValue(value).(int64); !ok || n < 0 {
+				problems = append(problems, fmt.Sprintf("queue %q has invalid x-delivery-limit %v", q.Name, value))
+			}
+		}
+		if value, ok := q.Arguments["x-quorum-initial-group-size"]; ok {
+			if n, ok := toAMQPValue(value).(int64); !ok || n < 1 {
+				problems = append(problems, fmt.Sprintf("queue %q has invalid x-quorum-initial-group-size %v", q.Name, value))
+			}
+		}
+		// at-least-once keeps messages in the queue until the DLQ confirms them,
+		// which RabbitMQ only allows when the queue refuses new ones once full
+		if strategy := q.Arguments["x-dead-letter-strategy"]; strategy == "at-least-once" && overflow != string(OverflowRejectPublish) {
+			problems = append(problems, fmt.Sprintf("quorum queue %q needs x-overflow reject-publish for x-dead-letter-strategy at-least-once", q.Name))
+		}
+	case "stream":
+		for _, key := range []string{"x-message-ttl", "x-max-length", "x-expires", "x-overflow"} {
+			if _, ok := q.Arguments[key]; ok {
+				problems = append(problems, fmt.Sprintf("stream %q does not support %s (use x-max-length-bytes or x-max-age)", q.Name, key))
+			}
+		}
+	}
+	return problems
+}
+
+// WithLimits returns a copy of the topology whose queues declare the given limits,
+// keyed by queue name; the "" key stands for mainQueue
+func (t *Topology) WithLimits(mainQueue string, limits map[string]QueueLimits) (*Topology, error) {
+	limited := &Topology{
+		Exchanges: t.Exchanges,
+		Queues:    append([]QueueSpec(nil), t.Queues...),
+		Bindings:  t.Bindings,
+	}
+
+	for name, l := range limits {
+		if name == "" {
+			name = mainQueue
+		}
+		if err := l.Validate(); err != nil {
+			return nil, fmt.Errorf("queue %q: %w", name, err)
+		}
+
+		found := false
+		for i, q := range limited.Queues {
+			if q.Name != name {
+				continue
+			}
+			found = true
+			args := make(map[string]interface{}, len(q.Arguments))
+			for k, v := range q.Arguments {
+				args[k] = v
+			}
+			for k, v := range l.arguments() {
+				args[k] = v
+			}
+			limited.Queues[i].Arguments = args
+			if problems := queueArgumentProblems(limited.Queues[i]); len(problems) > 0 {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidLimits, strings.Join(problems, "; "))
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: topology does not declare queue %q", ErrInvalidLimits, name)
+		}
+	}
+	return limited, nil
+}
+
+// QueueLimits returns the limits the topology declares for the named queue
+func (t *Topology) QueueLimits(name string) QueueLimits {
+	for _, q := range t.Queues {
+		if q.Name == name {
+			return queueLimits(q.Arguments)
+		}
+	}
+	return QueueLimits{}
+}
+
+// rejectingQueue returns the first of queues whose overflow policy nacks publishes when full
+func (t *Topology) rejectingQueue(queues []string) (string, OverflowPolicy, bool) {
+	for _, name := range queues {
+		if policy := t.QueueLimits(name).Overflow; policy.rejects() {
+			return name, policy, true
+		}
+	}
+	return "", "", false
+}
+
+// queueAtLimit reports whether queue holds as many ready messages, or bytes, as its limits
+// allow. The message count comes from a passive declare; AMQP does not report the size
+// of a queue, so x-max-length-bytes is only checked through Config.QueueBytes.
+func (r *RabbitMQ) queueAtLimit(queue string, limits QueueLimits) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	if limits.MaxLength > 0 {
+		pool, ch, err := r.borrowConsume(ctx)
+		if err != nil {
+			return false, err
+		}
+		q, err := GetQueueInfo(ch, queue)
+		pool.put(ch)
+		if err != nil {
+			return false, err
+		}
+		if int64(q.Messages) >= limits.MaxLength {
+			return true, nil
+		}
+	}
+
+	if limits.MaxLengthBytes > 0 && r.config.QueueBytes != nil {
+		size, err := r.config.QueueBytes(ctx, queue)
+		if err != nil {
+			return false, err
+		}
+		return size >= limits.MaxLengthBytes, nil
+	}
+	return false, nil
+}
+
+// queueFullError describes a publish nacked by the reject-publish policy of queue
+func queueFullError(queue string, policy OverflowPolicy) error {
+	return fmt.Errorf("%w: %s is at its length limit and refused the message (x-overflow %s)", ErrQueueFull, queue, policy)
+}
+
+// ParseQueueLimits parses per-queue limits such as
+// "max-length=1000,overflow=reject-publish;orders.dlq:message-ttl=168h".
+// Entries are separated by ";" and may start with "queue:"; entries without a
+// queue name configure the main queue and are returned under the "" key.
+// The settings are message-ttl, max-length, max-length-bytes, expires and overflow.
+func ParseQueueLimits(spec string) (map[string]QueueLimits, error) {
+	limits := make(map[string]QueueLimits)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// Durations and numbers have no colon, so the queue name ends at the last one
+		queue, settings := "", entry
+		if i := strings.LastIndex(entry, ":"); i >= 0 {
+			queue, settings = strings.TrimSpace(entry[:i]), entry[i+1:]
+		}
+		if _, ok := limits[queue]; ok {
+			return nil, fmt.Errorf("%w: limits for queue %q are listed twice", ErrInvalidLimits, queue)
+		}
+
+		var l QueueLimits
+		for _, setting := range strings.Split(settings, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+			if !ok {
+				return nil, fmt.Errorf("%w: %q is not a key=value setting", ErrInvalidLimits, setting)
+			}
+			var err error
+			switch strings.TrimSpace(key) {
+			case "message-ttl":
+				l.MessageTTL, err = time.ParseDuration(value)
+			case "max-length":
+				l.MaxLength, err = strconv.ParseInt(value, 10, 64)
+			case "max-length-bytes":
+				l.MaxLengthBytes, err = strconv.ParseInt(value, 10, 64)
+			case "expires":
+				l.Expires, err = time.ParseDuration(value)
+			case "overflow":
+				l.Overflow = OverflowPolicy(value)
+			default:
+				return nil, fmt.Errorf("%w: unknown setting %q", ErrInvalidLimits, key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrInvalidLimits, key, err)
+			}
+		}
+		if err := l.Validate(); err != nil {
+			return nil, err
+		}
+		limits[queue] = l
+	}
+	return limits, nil
+}
@@ -0,0 +1,471 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MemoryBroker is an in-process Broker for tests and local development.
+// It models a durable quorum queue: messages survive Restart, unacknowledged
+// messages go back to the head of the queue and their delivery count grows
+// every time they are returned, like x-delivery-count on a real quorum queue.
+// With SetPoisonPolicy it also enforces x-delivery-limit and dead-letters to a DLQ.
+// DeleteQueue makes publishes come back as unroutable, as after the queue expired.
+type MemoryBroker struct {
+	QueueName string
+	DLQName   string // set by SetPoisonPolicy when dead-lettering is enabled
+
+	mu       sync.Mutex
+	state    State
+	ready    []*memoryMessage
+	dlq      []*memoryMessage
+	unacked  map[uint64]*memoryMessage
+	nextTag  uint64
+	topology *Topology // queue arguments, for the length limits and the delivery limit
+
+	// Unroutable publishes, enabled by DeleteQueue and kept when SetAlternateExchange is set
+	deleted           bool
+	alternateExchange string
+	unroutable        []*memoryMessage
+}
+
+// memoryMessage is a message stored by MemoryBroker, kept as the broker would deliver it
+type memoryMessage struct {
+	delivery      amqp.Delivery
+	deliveryCount int
+}
+
+// NewMemoryBroker creates a connected in-memory broker with an empty queue
+func NewMemoryBroker(queueName string) *MemoryBroker {
+	return &MemoryBroker{
+		QueueName: queueName,
+		state:     StateConnected,
+		unacked:   make(map[uint64]*memoryMessage),
+		topology:  DefaultTopology(queueName),
+	}
+}
+
+// SetQueueLimits declares limits on the queue the way Config.Limits does; publishing
+// then honours x-max-length, x-max-length-bytes and x-overflow.
+// TTL and queue expiry are declared but not simulated.
+func (m *MemoryBroker) SetQueueLimits(limits QueueLimits) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	topology, err := m.topology.WithLimits(m.QueueName, map[string]QueueLimits{"": limits})
+	if err != nil {
+		return err
+	}
+	m.topology = topology
+	return nil
+}
+
+// SetPoisonPolicy declares x-delivery-limit and a Dead Letter Queue the way
+// Config.DeliveryLimit and Config.DeadLetter do; limits set before are kept
+func (m *MemoryBroker) SetPoisonPolicy(deliveryLimit int, deadLetter DeadLetterConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	topology, err := QuorumTopology(m.QueueName, deliveryLimit, deadLetter)
+	if err != nil {
+		return err
+	}
+	limits := map[string]QueueLimits{"": m.topology.QueueLimits(m.QueueName)}
+	if topology, err = topology.WithLimits(m.QueueName, limits); err != nil {
+		return err
+	}
+	m.topology = topology
+	m.DLQName = topology.poisonPolicy(m.QueueName).DeadLetterQueue
+	return nil
+}
+
+// SetState changes the reported connection state; any state other than
+// StateConnected makes operations fail with ErrNotConnected
+func (m *MemoryBroker) SetState(state State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = state
+}
+
+// SetAlternateExchange keeps unroutable messages as if they were republished to
+// exchange, the way Config.AlternateExchange does
+func (m *MemoryBroker) SetAlternateExchange(exchange string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.alternateExchange = exchange
+}
+
+// Restart simulates a broker restart: the queue is durable, so ready messages
+// are kept and unacknowledged ones are returned to the queue. A deleted queue
+// is declared again, as the service does when it reconnects.
+func (m *MemoryBroker) Restart() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for tag, msg := range m.unacked {
+		delete(m.unacked, tag)
+		m.requeue(msg)
+	}
+	m.deleted = false
+}
+
+// DeleteQueue simulates the queue being deleted, by x-expires or from the management UI:
+// its messages are lost and publishes are returned as unroutable until Restart
+func (m *MemoryBroker) DeleteQueue() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ready = nil
+	m.unacked = make(map[uint64]*memoryMessage)
+	m.deleted = true
+}
+
+// UnroutableMessages returns the bodies of the unroutable messages sent to the alternate exchange
+func (m *MemoryBroker) UnroutableMessages() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bodies := make([]string, len(m.unroutable))
+	for i, msg := range m.unroutable {
+		bodies[i] = string(msg.delivery.Body)
+	}
+	return bodies
+}
+
+// Messages returns the bodies of the ready messages in delivery order
+func (m *MemoryBroker) Messages() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bodies := make([]string, len(m.ready))
+	for i, msg := range m.ready {
+		bodies[i] = string(msg.delivery.Body)
+	}
+	return bodies
+}
+
+// DLQMessages returns the bodies of the messages in the Dead Letter Queue in delivery order
+func (m *MemoryBroker) DLQMessages() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bodies := make([]string, len(m.dlq))
+	for i, msg := range m.dlq {
+		bodies[i] = string(msg.delivery.Body)
+	}
+	return bodies
+}
+
+// PublishWithConfirmation appends a message to the queue; the "confirm" is immediate
+func (m *MemoryBroker) PublishWithConfirmation(msg Message) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return "", err
+	}
+
+	publishing, err := msg.publishing()
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.publish(publishing); err != nil {
+		return "", err
+	}
+	return publishing.MessageId, nil
+}
+
+// PublishBatch appends the messages one by one; in all-or-nothing mode the
+// first refused message restores the queues and rolls back the rest of the batch.
+// As with RabbitMQ.PublishBatch, an all-or-nothing batch to a deleted queue is
+// rolled back before anything is published.
+func (m *MemoryBroker) PublishBatch(messages []Message, mode BatchMode) (*BatchResult, error) {
+	publishings, err := prepareBatch(messages, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+
+	result := newBatchResult(mode, publishings)
+	if mode == BatchAllOrNothing && m.deleted {
+		result.set(0, BatchReturned, &UnroutableError{
+			MessageID:  publishings[0].MessageId,
+			RoutingKey: m.QueueName,
+			ReplyCode:  amqp.NoRoute,
+			ReplyText:  "NO_ROUTE",
+		})
+		result.rollBack(0, fmt.Errorf("transaction rolled back: message 0 cannot be routed"))
+		result.count()
+		return result, nil
+	}
+	ready := append([]*memoryMessage(nil), m.ready...)
+	dlq := append([]*memoryMessage(nil), m.dlq...)
+	unroutable := append([]*memoryMessage(nil), m.unroutable...)
+	for i, publishing := range publishings {
+		err := m.publish(publishing)
+		switch {
+		case err == nil:
+			result.set(i, BatchAcked, nil)
+		case errors.Is(err, ErrUnroutable):
+			result.set(i, BatchReturned, err)
+		default:
+			result.set(i, BatchNacked, err)
+			if mode == BatchAllOrNothing {
+				m.ready, m.dlq, m.unroutable = ready, dlq, unroutable
+				result.rollBack(i, fmt.Errorf("transaction rolled back: message %d failed", i))
+				result.count()
+				return result, nil
+			}
+		}
+	}
+	result.count()
+	return result, nil
+}
+
+// ConsumeWithManualAck takes the message at the head of the queue and holds it as unacknowledged
+func (m *MemoryBroker) ConsumeWithManualAck() (*MessageWithTag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	if len(m.ready) == 0 {
+		return nil, ErrNoMessages
+	}
+
+	msg := m.ready[0]
+	m.ready = m.ready[1:]
+	m.nextTag++
+	m.unacked[m.nextTag] = msg
+
+	return &MessageWithTag{
+		Delivery:    *newDelivery(msg.delivery),
+		DeliveryTag: m.nextTag,
+		acker:       memoryAcknowledger{m},
+	}, nil
+}
+
+// AckMessage removes an unacknowledged message for good
+func (m *MemoryBroker) AckMessage(msg *MessageWithTag) error {
+	return ackMessage(msg)
+}
+
+// NackMessage requeues an unacknowledged message or, without requeue, dead-letters or discards it
+func (m *MemoryBroker) NackMessage(msg *MessageWithTag, requeue bool) error {
+	return nackMessage(msg, requeue)
+}
+
+// ConsumeAndAck consumes a message and immediately acknowledges it
+func (m *MemoryBroker) ConsumeAndAck() (*Delivery, error) {
+	return consumeAndAck(m)
+}
+
+// ConsumeAndNack consumes a message and rejects it
+func (m *MemoryBroker) ConsumeAndNack(requeue bool) (*Delivery, error) {
+	return consumeAndNack(m, requeue)
+}
+
+// ConsumeAndFail consumes a message and requeues, dead-letters or discards it
+func (m *MemoryBroker) ConsumeAndFail(action FailAction) (*FailResult, error) {
+	return consumeAndFail(m, m.PoisonPolicy(), action)
+}
+
+// ConsumeFromDLQ takes the message at the head of the Dead Letter Queue
+func (m *MemoryBroker) ConsumeFromDLQ() (*Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	if m.DLQName == "" {
+		return nil, ErrNoDeadLetter
+	}
+	if len(m.dlq) == 0 {
+		return nil, ErrDLQEmpty
+	}
+
+	msg := m.dlq[0]
+	m.dlq = m.dlq[1:]
+	return newDelivery(msg.delivery), nil
+}
+
+// PoisonPolicy reports the delivery limit and Dead Letter Queue set by SetPoisonPolicy
+func (m *MemoryBroker) PoisonPolicy() PoisonPolicy {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.topology.poisonPolicy(m.QueueName)
+}
+
+// QueueInfo reports the ready messages; unacknowledged ones are not counted, as in RabbitMQ
+func (m *MemoryBroker) QueueInfo() (amqp.Queue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return amqp.Queue{}, err
+	}
+	return amqp.Queue{Name: m.QueueName, Messages: len(m.ready)}, nil
+}
+
+// State returns the simulated connection state
+func (m *MemoryBroker) State() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// ActiveNode always reports the single in-memory node
+func (m *MemoryBroker) ActiveNode() string {
+	if m.State() != StateConnected {
+		return ""
+	}
+	return "memory"
+}
+
+// Nodes lists the single in-memory node
+func (m *MemoryBroker) Nodes() []NodeStatus {
+	return []NodeStatus{{Address: "memory", Active: m.State() == StateConnected}}
+}
+
+// publish appends publishing to the queue or, once the queue is deleted, returns
+// it as unroutable; the caller must hold m.mu
+func (m *MemoryBroker) publish(publishing amqp.Publishing) error {
+	if !m.deleted {
+		return m.enqueue(&memoryMessage{delivery: publishedDelivery(publishing, "", m.QueueName)})
+	}
+
+	unroutable := &UnroutableError{
+		MessageID:  publishing.MessageId,
+		RoutingKey: m.QueueName,
+		ReplyCode:  amqp.NoRoute,
+		ReplyText:  "NO_ROUTE",
+	}
+	if m.alternateExchange != "" {
+		m.unroutable = append(m.unroutable, &memoryMessage{delivery: publishedDelivery(publishing, m.alternateExchange, m.QueueName)})
+		unroutable.AlternateExchange = m.alternateExchange
+	}
+	return unroutable
+}
+
+// checkConnected fails while the simulated connection is down; the caller must hold m.mu
+func (m *MemoryBroker) checkConnected() error {
+	if m.state != StateConnected {
+		return fmt.Errorf("%w (state: %s)", ErrNotConnected, m.state)
+	}
+	return nil
+}
+
+// requeue puts a returned message back at the head of the queue or, once it has been
+// returned more than x-delivery-limit times, dead-letters it; the caller must hold m.mu
+func (m *MemoryBroker) requeue(msg *memoryMessage) {
+	msg.deliveryCount++
+	msg.delivery.Redelivered = true
+	msg.delivery.Headers = withDeliveryCount(msg.delivery.Headers, msg.deliveryCount)
+
+	if limit := m.topology.poisonPolicy(m.QueueName).DeliveryLimit; limit > 0 && msg.deliveryCount > limit {
+		m.deadLetter(msg, DeathDeliveryLimit)
+		return
+	}
+	m.ready = append([]*memoryMessage{msg}, m.ready...)
+}
+
+// deadLetter moves msg to the DLQ with an x-death record, or drops it when the
+// queue has no DLX; the caller must hold m.mu
+func (m *MemoryBroker) deadLetter(msg *memoryMessage, reason DeathReason) {
+	if m.DLQName == "" {
+		return
+	}
+
+	d := msg.delivery
+	d.Headers = addDeath(d.Headers, DeathRecord{
+		Reason:      reason,
+		Queue:       m.QueueName,
+		Exchange:    d.Exchange,
+		RoutingKeys: []string{d.RoutingKey},
+		Time:        time.Now().Truncate(time.Second),
+	})
+	for _, q := range m.topology.Queues {
+		if q.Name != m.QueueName {
+			continue
+		}
+		if dlx, ok := q.Arguments["x-dead-letter-exchange"].(string); ok {
+			d.Exchange = dlx
+		}
+		if key, ok := q.Arguments["x-dead-letter-routing-key"].(string); ok {
+			d.RoutingKey = key
+		}
+	}
+	d.Redelivered = false
+
+	m.dlq = append(m.dlq, &memoryMessage{delivery: d})
+}
+
+// enqueue appends msg within the x-max-length and x-max-length-bytes of the queue:
+// reject-publish refuses the message and drop-head dead-letters or discards the oldest ones.
+// The caller must hold m.mu.
+func (m *MemoryBroker) enqueue(msg *memoryMessage) error {
+	limits := m.topology.QueueLimits(m.QueueName)
+	sizes := make([]int, len(m.ready))
+	for i, queued := range m.ready {
+		sizes[i] = len(queued.delivery.Body)
+	}
+
+	reject, drop := limits.overflow(sizes, len(msg.delivery.Body))
+	if reject {
+		return queueFullError(m.QueueName, limits.Overflow)
+	}
+	m.ready = append(m.ready, msg)
+	for _, dropped := range m.ready[:drop] {
+		m.deadLetter(dropped, DeathMaxLen)
+	}
+	m.ready = m.ready[drop:]
+	return nil
+}
+
+// memoryAcknowledger settles messages handed out by a MemoryBroker
+type memoryAcknowledger struct {
+	m *MemoryBroker
+}
+
+func (a memoryAcknowledger) take(tag uint64) (*memoryMessage, error) {
+	msg, ok := a.m.unacked[tag]
+	if !ok {
+		// RabbitMQ closes the channel with PRECONDITION_FAILED in this case
+		return nil, fmt.Errorf("unknown delivery tag %d", tag)
+	}
+	delete(a.m.unacked, tag)
+	return msg, nil
+}
+
+func (a memoryAcknowledger) ack(tag uint64) error {
+	a.m.mu.Lock()
+	defer a.m.mu.Unlock()
+	_, err := a.take(tag)
+	return err
+}
+
+func (a memoryAcknowledger) nack(tag uint64, requeue bool) error {
+	a.m.mu.Lock()
+	defer a.m.mu.Unlock()
+	msg, err := a.take(tag)
+	if err != nil {
+		return err
+	}
+	if requeue {
+		a.m.requeue(msg)
+	} else {
+		a.m.deadLetter(msg, DeathRejected)
+	}
+	return nil
+}
+
+// withDeliveryCount returns a copy of headers with x-delivery-count set, as a quorum queue does on redelivery
+func withDeliveryCount(headers amqp.Table, count int) amqp.Table {
+	out := make(amqp.Table, len(headers)+1)
+	for k, v := range headers {
+		out[k] = v
+	}
+	out["x-delivery-count"] = int64(count)
+	return out
+}
@@ -0,0 +1,101 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Export writes the ready messages of the queue as NDJSON archive records, like RabbitMQ.Export.
+// Copied messages count as returned, as a requeue on a quorum queue does: they
+// are marked redelivered, their delivery count grows and x-delivery-limit applies.
+func (m *MemoryBroker) Export(w io.Writer, opts ExportOptions) (*ExportResult, error) {
+	opts, err := opts.withDefaults(m.QueueName)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	if opts.Queue != m.QueueName {
+		return nil, fmt.Errorf("%w: %s", ErrQueueNotFound, opts.Queue)
+	}
+
+	n := len(m.ready)
+	if opts.Limit > 0 && opts.Limit < n {
+		n = opts.Limit
+	}
+	encoder := json.NewEncoder(w)
+	for _, msg := range m.ready[:n] {
+		if err := encoder.Encode(newArchiveRecord(msg.delivery, opts.Queue)); err != nil {
+			return nil, fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+
+	if opts.Mode == ExportDestructive {
+		m.ready = m.ready[n:]
+	} else {
+		m.requeueHead(n)
+	}
+	return &ExportResult{Queue: opts.Queue, Mode: opts.Mode, Exported: n}, nil
+}
+
+// Import appends the records of an NDJSON archive, skipping duplicates like RabbitMQ.Import
+func (m *MemoryBroker) Import(src io.Reader, opts ImportOptions) (*ImportResult, error) {
+	return importArchive(src, opts, m.messageIDs, m.importPublishing)
+}
+
+// messageIDs lists the message IDs of up to scan ready messages of queue. Like the
+// requeue of RabbitMQ.Import's scan, it counts them as returned, as Export does.
+func (m *MemoryBroker) messageIDs(queue string, scan int) (map[string]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	if queue != m.QueueName {
+		return nil, fmt.Errorf("%w: %s", ErrQueueNotFound, queue)
+	}
+
+	n := len(m.ready)
+	if scan < n {
+		n = scan
+	}
+	ids := make(map[string]bool)
+	for _, msg := range m.ready[:n] {
+		if msg.delivery.MessageId != "" {
+			ids[msg.delivery.MessageId] = true
+		}
+	}
+	m.requeueHead(n)
+	return ids, nil
+}
+
+// requeueHead returns the first n ready messages to the queue in their order, as the
+// multiple nack with requeue of a scan does; the caller must hold m.mu
+func (m *MemoryBroker) requeueHead(n int) {
+	held := append([]*memoryMessage(nil), m.ready[:n]...)
+	m.ready = m.ready[n:]
+	for i := len(held) - 1; i >= 0; i-- {
+		m.requeue(held[i])
+	}
+}
+
+// importPublishing appends p to queue, within its length limits, as if published through the default exchange
+func (m *MemoryBroker) importPublishing(p amqp.Publishing, queue string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return err
+	}
+	if queue != m.QueueName {
+		return fmt.Errorf("%w: queue %q does not exist", ErrQueueNotFound, queue)
+	}
+
+	return m.enqueue(&memoryMessage{delivery: publishedDelivery(p, "", queue)})
+}
@@ -2,6 +2,7 @@ package rabbitmq
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -9,54 +10,135 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-// PublishWithConfirmation publishes a message and waits for broker confirmation
-func (r *RabbitMQ) PublishWithConfirmation(message string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// ErrUnroutable is returned when a mandatory message matched no queue and was returned
+var ErrUnroutable = errors.New("message could not be routed to any queue")
+
+// UnroutableError describes a message the broker returned. It matches ErrUnroutable with errors.Is.
+type UnroutableError struct {
+	MessageID  string `json:"message_id,omitempty"`
+	Exchange   string `json:"exchange"`
+	RoutingKey string `json:"routing_key"`
+	ReplyCode  uint16 `json:"reply_code"`
+	ReplyText  string `json:"reply_text"`
+
+	// AlternateExchange is set when the message was republished to Config.AlternateExchange
+	AlternateExchange string `json:"alternate_exchange,omitempty"`
+}
+
+func (e *UnroutableError) Error() string {
+	msg := fmt.Sprintf("%v: exchange %q, routing key %q (%d %s)", ErrUnroutable, e.Exchange, e.RoutingKey, e.ReplyCode, e.ReplyText)
+	if e.AlternateExchange != "" {
+		msg += fmt.Sprintf("; sent to alternate exchange %q", e.AlternateExchange)
+	}
+	return msg
+}
+
+func (e *UnroutableError) Unwrap() error {
+	return ErrUnroutable
+}
+
+// PublishWithConfirmation publishes a message and waits for broker confirmation.
+// It returns the message ID, generated when msg has none.
+func (r *RabbitMQ) PublishWithConfirmation(msg Message) (string, error) {
+	publishing, err := msg.publishing()
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.publish(publishing, "", r.QueueName); err != nil {
+		return "", err
+	}
+
+	log.Printf("✓ Message confirmed by broker: %s", msg.Body)
+	return publishing.MessageId, nil
+}
+
+// publishTimeout bounds a publish, from borrowing a channel to receiving its confirm
+const publishTimeout = 5 * time.Second
+
+// PublishAsync publishes a message and returns as soon as it is on the wire.
+// The returned future resolves when the broker confirms the message, so many
+// publishes can be in flight on the same channel.
+func (r *RabbitMQ) PublishAsync(msg Message) (*PendingConfirm, error) {
+	publishing, err := msg.publishing()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
 	defer cancel()
+	return r.publishAsync(ctx, publishing, "", r.QueueName)
+}
 
-	// Create a channel to receive confirmations
-	confirms := r.Channel.NotifyPublish(make(chan amqp.Confirmation, 1))
-
-	// Publish the message
-	err := r.Channel.PublishWithContext(
-		ctx,
-		"",          // exchange
-		r.QueueName, // routing key (queue name)
-		true,        // mandatory - return message if not routable
-		false,       // immediate
-		amqp.Publishing{
-			ContentType:  "text/plain",
-			Body:         []byte(message),
-			DeliveryMode: amqp.Persistent, // persistent (required for quorum queues)
-			Timestamp:    time.Now(),
-		},
-	)
+// publish sends publishing as mandatory and waits for the broker to confirm it
+func (r *RabbitMQ) publish(publishing amqp.Publishing, exchange, routingKey string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	pending, err := r.publishAsync(ctx, publishing, exchange, routingKey)
 	if err != nil {
-		return fmt.Errorf("failed to publish message: %w", err)
+		return err
 	}
+	return pending.Wait(ctx)
+}
 
-	// Wait for confirmation
-	select {
-	case confirm := <-confirms:
-		if confirm.Ack {
-			log.Printf("✓ Message confirmed by broker: %s", message)
-			return nil
-		}
-		return fmt.Errorf("message not confirmed (nack received)")
-	case <-ctx.Done():
-		return fmt.Errorf("timeout waiting for confirmation")
+// publishAsync sends publishing as mandatory and hands its confirm to the
+// dispatcher of the channel. The channel is only borrowed while sending.
+func (r *RabbitMQ) publishAsync(ctx context.Context, publishing amqp.Publishing, exchange, routingKey string) (*PendingConfirm, error) {
+	pool, ch, err := r.borrowPublish(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.put(ch)
+
+	dispatcher, err := r.dispatcher(ch)
+	if err != nil {
+		return nil, err
+	}
+	return dispatcher.publish(ctx, publishing, exchange, routingKey)
+}
+
+// returned reports a message the broker returned and, when Config.AlternateExchange
+// is set, republishes it there with its original routing key so it is not lost
+func (r *RabbitMQ) returned(ret amqp.Return) error {
+	unroutable := &UnroutableError{
+		MessageID:  ret.MessageId,
+		Exchange:   ret.Exchange,
+		RoutingKey: ret.RoutingKey,
+		ReplyCode:  ret.ReplyCode,
+		ReplyText:  ret.ReplyText,
+	}
+	log.Printf("✗ Message %s returned by broker: %s", ret.MessageId, ret.ReplyText)
+
+	ae := r.config.AlternateExchange
+	if ae == "" || ret.Exchange == ae {
+		return unroutable
+	}
+	if err := r.publish(returnedPublishing(ret), ae, ret.RoutingKey); err != nil {
+		log.Printf("✗ Failed to send message %s to alternate exchange %q: %v", ret.MessageId, ae, err)
+		return unroutable
 	}
+	unroutable.AlternateExchange = ae
+	log.Printf("↪ Message %s sent to alternate exchange %q", ret.MessageId, ae)
+	return unroutable
 }
 
-// PublishBatch publishes multiple messages with confirmations
-func (r *RabbitMQ) PublishBatch(messages []string) (int, error) {
-	successCount := 0
-	for i, msg := range messages {
-		if err := r.PublishWithConfirmation(msg); err != nil {
-			log.Printf("✗ Failed to publish message %d: %v", i+1, err)
-			return successCount, err
-		}
-		successCount++
+// returnedPublishing rebuilds the publishing of a returned message
+func returnedPublishing(ret amqp.Return) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         ret.Headers,
+		ContentType:     ret.ContentType,
+		ContentEncoding: ret.ContentEncoding,
+		DeliveryMode:    ret.DeliveryMode,
+		Priority:        ret.Priority,
+		CorrelationId:   ret.CorrelationId,
+		ReplyTo:         ret.ReplyTo,
+		Expiration:      ret.Expiration,
+		MessageId:       ret.MessageId,
+		Timestamp:       ret.Timestamp,
+		Type:            ret.Type,
+		UserId:          ret.UserId,
+		AppId:           ret.AppId,
+		Body:            ret.Body,
 	}
-	return successCount, nil
 }
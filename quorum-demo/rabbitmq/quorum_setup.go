@@ -1,43 +1,298 @@
 package rabbitmq
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"strings"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-// SetupQuorumQueue creates a Quorum Queue with replication
-func SetupQuorumQueue(ch *amqp.Channel, queueName string) error {
-	// Quorum queue arguments
-	args := amqp.Table{
-		"x-queue-type": "quorum", // Quorum queue type
-		// Optional: specify initial group size (default is 3 if cluster has 3+ nodes)
-		// "x-quorum-initial-group-size": 3,
+// clusterSizeTimeout bounds Config.ClusterSize when the service starts
+const clusterSizeTimeout = 10 * time.Second
+
+// DefaultTopology is used when no topology file is configured:
+// a single replicated Quorum Queue
+func DefaultTopology(queueName string) *Topology {
+	return &Topology{
+		Queues: []QueueSpec{
+			{
+				Name: queueName, // durable (always true for quorum queues)
+				Arguments: map[string]interface{}{
+					"x-queue-type": "quorum", // Quorum queue type
+					// Replicas default to every cluster node; Config.InitialGroupSize
+					// sets x-quorum-initial-group-size to use fewer
+				},
+			},
+		},
 	}
+}
 
-	// Declare the Quorum Queue
-	q, err := ch.QueueDeclare(
-		queueName, // name
-		true,      // durable (always true for quorum queues)
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		args,      // arguments
-	)
-	if err != nil {
-		return fmt.Errorf("failed to declare quorum queue: %w", err)
+// DeadLetterStrategy is how a quorum queue hands messages to its Dead Letter Exchange
+type DeadLetterStrategy string
+
+const (
+	// DeadLetterAtMostOnce drops a dead-lettered message if the DLQ does not take it; the broker default
+	DeadLetterAtMostOnce DeadLetterStrategy = "at-most-once"
+	// DeadLetterAtLeastOnce keeps a dead-lettered message in the source queue until the DLQ
+	// confirms it. RabbitMQ requires x-overflow reject-publish for it.
+	DeadLetterAtLeastOnce DeadLetterStrategy = "at-least-once"
+)
+
+// QueuePlaceholder in a DeadLetterConfig name is replaced by the queue name
+const QueuePlaceholder = "{queue}"
+
+// DeadLetterConfig adds a Dead Letter Exchange and a quorum Dead Letter Queue to the
+// built-in topology when Strategy is set. Empty names default to {queue}.dlx,
+// {queue}.dlq and {queue}.
+type DeadLetterConfig struct {
+	Strategy   DeadLetterStrategy
+	Exchange   string
+	Queue      string
+	RoutingKey string
+}
+
+// Enabled reports whether the queue dead-letters at all
+func (c DeadLetterConfig) Enabled() bool {
+	return c.Strategy != ""
+}
+
+// For returns the config with the names used by queueName filled in
+func (c DeadLetterConfig) For(queueName string) DeadLetterConfig {
+	expand := func(name, fallback string) string {
+		if name == "" {
+			name = fallback
+		}
+		return strings.ReplaceAll(name, QueuePlaceholder, queueName)
+	}
+	c.Exchange = expand(c.Exchange, QueuePlaceholder+".dlx")
+	c.Queue = expand(c.Queue, QueuePlaceholder+".dlq")
+	c.RoutingKey = expand(c.RoutingKey, QueuePlaceholder)
+	return c
+}
+
+// QuorumTopology is DefaultTopology with poison-message handling: x-delivery-limit
+// bounds how often a message is returned to the queue (0 leaves it unset), and
+// deadLetter, when enabled, routes the messages over the limit or rejected without
+// requeue to a quorum DLQ instead of dropping them
+func QuorumTopology(queueName string, deliveryLimit int, deadLetter DeadLetterConfig) (*Topology, error) {
+	if deliveryLimit < 0 {
+		return nil, fmt.Errorf("delivery limit %d cannot be negative", deliveryLimit)
 	}
 
-	log.Printf("✓ Created Quorum Queue: %s", queueName)
-	log.Printf("  - Type: Quorum (replicated)")
-	log.Printf("  - Durable: true")
-	log.Printf("  - Messages: %d", q.Messages)
-	log.Printf("  - Consumers: %d", q.Consumers)
+	t := DefaultTopology(queueName)
+	args := t.Queues[0].Arguments
+	if deliveryLimit > 0 {
+		args["x-delivery-limit"] = int64(deliveryLimit)
+	}
+	if !deadLetter.Enabled() {
+		return t, nil
+	}
+
+	switch deadLetter.Strategy {
+	case DeadLetterAtMostOnce, DeadLetterAtLeastOnce:
+	default:
+		return nil, fmt.Errorf("unknown dead-letter strategy %q (want at-most-once or at-least-once)", deadLetter.Strategy)
+	}
+	names := deadLetter.For(queueName)
+	if names.Queue == queueName {
+		return nil, fmt.Errorf("queue %q cannot be its own Dead Letter Queue", queueName)
+	}
+
+	args["x-dead-letter-exchange"] = names.Exchange
+	args["x-dead-letter-routing-key"] = names.RoutingKey
+	args["x-dead-letter-strategy"] = string(names.Strategy)
+	if names.Strategy == DeadLetterAtLeastOnce {
+		args["x-overflow"] = string(OverflowRejectPublish)
+	}
+
+	t.Exchanges = append(t.Exchanges, ExchangeSpec{Name: names.Exchange, Type: amqp.ExchangeDirect})
+	t.Queues = append(t.Queues, QueueSpec{
+		Name:      names.Queue,
+		Arguments: map[string]interface{}{"x-queue-type": "quorum"},
+	})
+	t.Bindings = append(t.Bindings, BindingSpec{Source: names.Exchange, Destination: names.Queue, RoutingKey: names.RoutingKey})
+	return t, nil
+}
+
+// UnroutableQueue is the queue bound to an alternate exchange the service adds to the topology
+func UnroutableQueue(queueName string) string {
+	return queueName + ".unroutable"
+}
+
+// WithAlternateExchange returns a copy of the topology that declares exchange as a
+// fanout exchange bound to the quorum queue queue, so every message sent there is kept.
+// A topology that already declares exchange is returned as is.
+func (t *Topology) WithAlternateExchange(exchange, queue string) (*Topology, error) {
+	for _, e := range t.Exchanges {
+		if e.Name == exchange {
+			return t, nil
+		}
+	}
+
+	extended := &Topology{
+		Exchanges: append(append([]ExchangeSpec(nil), t.Exchanges...), ExchangeSpec{Name: exchange, Type: amqp.ExchangeFanout}),
+		Queues: append(append([]QueueSpec(nil), t.Queues...), QueueSpec{
+			Name:      queue,
+			Arguments: map[string]interface{}{"x-queue-type": "quorum"},
+		}),
+		Bindings: append(append([]BindingSpec(nil), t.Bindings...), BindingSpec{Source: exchange, Destination: queue}),
+	}
+	if err := extended.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid alternate exchange: %w", err)
+	}
+	return extended, nil
+}
+
+// WithInitialGroupSize returns a copy of the topology that sets x-quorum-initial-group-size
+// to size on every quorum queue that does not set it: the number of replicas the queue
+// gets when it is created
+func (t *Topology) WithInitialGroupSize(size int) (*Topology, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("initial group size %d must be positive", size)
+	}
 
+	sized := &Topology{
+		Exchanges: t.Exchanges,
+		Queues:    append([]QueueSpec(nil), t.Queues...),
+		Bindings:  t.Bindings,
+	}
+	for i, q := range sized.Queues {
+		if q.Arguments["x-queue-type"] != "quorum" {
+			continue
+		}
+		if _, ok := q.Arguments["x-quorum-initial-group-size"]; ok {
+			continue
+		}
+		args := make(map[string]interface{}, len(q.Arguments)+1)
+		for k, v := range q.Arguments {
+			args[k] = v
+		}
+		args["x-quorum-initial-group-size"] = int64(size)
+		sized.Queues[i].Arguments = args
+	}
+	return sized, nil
+}
+
+// CheckInitialGroupSize reports the quorum queues whose x-quorum-initial-group-size
+// is larger than the cluster: RabbitMQ would create them with fewer replicas than asked
+func (t *Topology) CheckInitialGroupSize(clusterSize int) error {
+	var problems []string
+	for _, q := range t.Queues {
+		size, ok := toAMQPValue(q.Arguments["x-quorum-initial-group-size"]).(int64)
+		if ok && size > int64(clusterSize) {
+			problems = append(problems, fmt.Sprintf("queue %q has x-quorum-initial-group-size %d", q.Name, size))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("the cluster has %d node(s): %s", clusterSize, strings.Join(problems, "; "))
+	}
 	return nil
 }
 
+// checkClusterSize checks the x-quorum-initial-group-size of the topology against
+// Config.ClusterSize before the queues are declared. A cluster that cannot be measured
+// fails the check too; leave Config.ClusterSize nil to skip it.
+func (r *RabbitMQ) checkClusterSize() error {
+	if r.config.ClusterSize == nil || !r.topology.hasInitialGroupSize() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), clusterSizeTimeout)
+	defer cancel()
+
+	size, err := r.config.ClusterSize(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot check x-quorum-initial-group-size against the cluster size: %w", err)
+	}
+	if err := r.topology.CheckInitialGroupSize(size); err != nil {
+		return fmt.Errorf("invalid quorum group size: %w", err)
+	}
+	return nil
+}
+
+// hasInitialGroupSize reports whether a queue of the topology sets x-quorum-initial-group-size
+func (t *Topology) hasInitialGroupSize() bool {
+	for _, q := range t.Queues {
+		if _, ok := q.Arguments["x-quorum-initial-group-size"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DeadLetterQueue finds the queue that receives the messages dead-lettered by queueName:
+// the queue bound to its x-dead-letter-exchange with its x-dead-letter-routing-key
+func (t *Topology) DeadLetterQueue(queueName string) (string, bool) {
+	var dlx, routingKey string
+	found := false
+	for _, q := range t.Queues {
+		if q.Name != queueName {
+			continue
+		}
+		dlx, found = q.Arguments["x-dead-letter-exchange"].(string)
+		if key, ok := q.Arguments["x-dead-letter-routing-key"].(string); ok {
+			routingKey = key
+		} else {
+			// Without an explicit key the message keeps its original routing key,
+			// which for the default exchange is the queue name
+			routingKey = queueName
+		}
+	}
+	if !found {
+		return "", false
+	}
+
+	exchangeType := ""
+	for _, e := range t.Exchanges {
+		if e.Name == dlx {
+			exchangeType = e.Type
+		}
+	}
+
+	for _, b := range t.Bindings {
+		if b.Source != dlx || b.DestinationType == "exchange" {
+			continue
+		}
+		if exchangeType == amqp.ExchangeFanout || b.RoutingKey == routingKey {
+			return b.Destination, true
+		}
+	}
+	return "", false
+}
+
+// PoisonPolicy is how the queue treats a message that keeps failing
+type PoisonPolicy struct {
+	// DeliveryLimit is x-delivery-limit; 0 when unset
+	DeliveryLimit int `json:"delivery_limit"`
+
+	// DeadLetterQueue receives rejected messages and those over the delivery limit;
+	// empty when the queue drops them
+	DeadLetterQueue string             `json:"dead_letter_queue,omitempty"`
+	Strategy        DeadLetterStrategy `json:"dead_letter_strategy,omitempty"`
+}
+
+// poisonPolicy reads the poison-message handling of queueName from the topology
+func (t *Topology) poisonPolicy(queueName string) PoisonPolicy {
+	var p PoisonPolicy
+	for _, q := range t.Queues {
+		if q.Name != queueName {
+			continue
+		}
+		if limit, ok := toAMQPValue(q.Arguments["x-delivery-limit"]).(int64); ok {
+			p.DeliveryLimit = int(limit)
+		}
+		if dlq, ok := t.DeadLetterQueue(queueName); ok {
+			p.DeadLetterQueue = dlq
+			p.Strategy = DeadLetterAtMostOnce
+			if strategy, ok := q.Arguments["x-dead-letter-strategy"].(string); ok {
+				p.Strategy = DeadLetterStrategy(strategy)
+			}
+		}
+	}
+	return p
+}
+
 // GetQueueInfo retrieves information about the queue
 func GetQueueInfo(ch *amqp.Channel, queueName string) (amqp.Queue, error) {
 	// Passive declare to get queue info without creating it
@@ -55,3 +310,17 @@ func GetQueueInfo(ch *amqp.Channel, queueName string) (amqp.Queue, error) {
 
 	return q, nil
 }
+
+// QueueInfo retrieves information about the service queue using a pooled channel
+func (r *RabbitMQ) QueueInfo() (amqp.Queue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	pool, ch, err := r.borrowConsume(ctx)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	defer pool.put(ch)
+
+	return GetQueueInfo(ch, r.QueueName)
+}
@@ -0,0 +1,64 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckClusterSize(t *testing.T) {
+	errUnreachable := errors.New("connection refused")
+	tests := []struct {
+		name      string
+		groupSize int
+		size      int
+		err       error
+		want      string // substring of the error, "" for none
+		called    bool
+	}{
+		{name: "fits", groupSize: 3, size: 3, called: true},
+		{name: "larger than the cluster", groupSize: 5, size: 3, want: "invalid quorum group size", called: true},
+		{name: "cluster unreachable", groupSize: 3, err: errUnreachable, want: "cannot check", called: true},
+		{name: "no group size", size: 1, err: errUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topology := DefaultTopology("orders-quorum")
+			if tt.groupSize > 0 {
+				var err error
+				if topology, err = topology.WithInitialGroupSize(tt.groupSize); err != nil {
+					t.Fatal(err)
+				}
+			}
+			called := false
+			r := &RabbitMQ{topology: topology, config: Config{ClusterSize: func(ctx context.Context) (int, error) {
+				called = true
+				return tt.size, tt.err
+			}}}
+
+			err := r.checkClusterSize()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("checkClusterSize = %v, want nil", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("checkClusterSize = %v, want an error with %q", err, tt.want)
+			}
+			if tt.err != nil && err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("checkClusterSize = %v, want it to wrap %v", err, tt.err)
+			}
+			if called != tt.called {
+				t.Errorf("ClusterSize called = %t, want %t", called, tt.called)
+			}
+		})
+	}
+
+	// Without ClusterSize the check is skipped
+	topology, err := DefaultTopology("orders-quorum").WithInitialGroupSize(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&RabbitMQ{topology: topology}).checkClusterSize(); err != nil {
+		t.Errorf("checkClusterSize without ClusterSize = %v, want nil", err)
+	}
+}
@@ -0,0 +1,276 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DefaultShutdownTimeout is how long a stopping Worker waits for in-flight handlers
+const DefaultShutdownTimeout = 30 * time.Second
+
+// ErrPermanent matches the errors wrapped with Permanent
+var ErrPermanent = errors.New("permanent failure")
+
+// HandlerFunc processes one delivery. Returning nil acks the message, an error
+// wrapped with Permanent rejects it without requeue so the queue dead-letters it
+// (or drops it when the queue has no DLX) and any other error requeues it.
+type HandlerFunc func(ctx context.Context, d Delivery) error
+
+// Permanent marks err as not retryable: the message is dead-lettered instead of requeued
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string        { return e.err.Error() }
+func (e *permanentError) Unwrap() error        { return e.err }
+func (e *permanentError) Is(target error) bool { return target == ErrPermanent }
+
+// HandlerOptions controls how the handler of a queue is run
+type HandlerOptions struct {
+	// Concurrency is how many messages are processed at the same time (1 when zero)
+	Concurrency int
+
+	// Prefetch is how many unacknowledged messages the broker pushes to the worker
+	// (Concurrency when zero); a larger value keeps the next messages buffered
+	Prefetch int
+}
+
+// WorkerConfig holds the settings of a Worker
+type WorkerConfig struct {
+	// ShutdownTimeout is how long Run waits for in-flight handlers once it is
+	// stopped before canceling their context (DefaultShutdownTimeout when zero)
+	ShutdownTimeout time.Duration
+}
+
+// Worker consumes queues in the background and runs the handler registered for each.
+// Consumers run on dedicated channels of the consume connection and are restarted
+// after the connection is re-established.
+type Worker struct {
+	rmq    *RabbitMQ
+	config WorkerConfig
+
+	mu       sync.Mutex
+	handlers map[string]registration
+	running  bool
+}
+
+// registration is a handler registered for a queue
+type registration struct {
+	queue   string
+	handler HandlerFunc
+	opts    HandlerOptions
+}
+
+// NewWorker creates a worker that consumes through rmq
+func NewWorker(rmq *RabbitMQ, cfg WorkerConfig) *Worker {
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	return &Worker{
+		rmq:      rmq,
+		config:   cfg,
+		handlers: make(map[string]registration),
+	}
+}
+
+// Handle registers the handler for queue; it must be called before Run
+func (w *Worker) Handle(queue string, handler HandlerFunc, opts HandlerOptions) error {
+	if queue == "" || handler == nil {
+		return errors.New("a worker handler needs a queue and a function")
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Prefetch <= 0 {
+		opts.Prefetch = opts.Concurrency
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.running {
+		return errors.New("cannot register handlers while the worker is running")
+	}
+	if _, ok := w.handlers[queue]; ok {
+		return fmt.Errorf("a handler is already registered for queue %q", queue)
+	}
+	w.handlers[queue] = registration{queue: queue, handler: handler, opts: opts}
+	return nil
+}
+
+// Run consumes every registered queue until ctx is canceled or the connection is
+// closed. It then stops taking messages, waits for the in-flight handlers to settle
+// theirs and returns; unstarted prefetched messages go back to their queue.
+func (w *Worker) Run(ctx context.Context) error {
+	w.mu.Lock()
+	if w.running {
+		w.mu.Unlock()
+		return errors.New("worker is already running")
+	}
+	if len(w.handlers) == 0 {
+		w.mu.Unlock()
+		return errors.New("no worker handlers registered")
+	}
+	w.running = true
+	registrations := make([]registration, 0, len(w.handlers))
+	for _, reg := range w.handlers {
+		registrations = append(registrations, reg)
+	}
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		w.running = false
+		w.mu.Unlock()
+	}()
+
+	// Handlers outlive ctx so they can finish during shutdown, up to the timeout
+	handlerCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandlers()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, reg := range registrations {
+		wg.Add(1)
+		go func(reg registration) {
+			defer wg.Done()
+			w.consume(handlerCtx, stop, reg)
+		}(reg)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-w.rmq.done:
+	}
+	close(stop)
+	log.Printf("Worker stopping, waiting for in-flight messages")
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(w.config.ShutdownTimeout):
+		log.Printf("Worker shutdown timed out after %s, canceling in-flight handlers", w.config.ShutdownTimeout)
+		cancelHandlers()
+		<-finished
+	}
+
+	log.Printf("Worker stopped")
+	return nil
+}
+
+// consume keeps a consumer running on reg.queue until stop is closed,
+// restarting it with backoff whenever its channel is lost
+func (w *Worker) consume(ctx context.Context, stop <-chan struct{}, reg registration) {
+	consumerTag := "worker-" + reg.queue + "-" + newMessageID()
+
+	for attempt := 1; ; attempt++ {
+		ch, deliveries, err := w.rmq.startConsumer(reg.queue, consumerTag, reg.opts.Prefetch)
+		if err != nil {
+			delay := w.rmq.backoff.Delay(attempt)
+			log.Printf("Worker could not consume %s: %v (retrying in %s)", reg.queue, err, delay.Round(time.Millisecond))
+			select {
+			case <-stop:
+				return
+			case <-time.After(delay):
+			}
+			continue
+		}
+		attempt = 0
+
+		log.Printf("Worker consuming %s (concurrency %d, prefetch %d)", reg.queue, reg.opts.Concurrency, reg.opts.Prefetch)
+		w.process(ctx, stop, reg, deliveries)
+
+		// Closing the channel requeues the deliveries no handler picked up
+		if !ch.IsClosed() {
+			ch.Close()
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+			log.Printf("Worker lost its channel on %s, restarting consumer", reg.queue)
+		}
+	}
+}
+
+// process runs reg.opts.Concurrency handlers over deliveries until stop is
+// closed or the channel goes away, and returns once they are all idle
+func (w *Worker) process(ctx context.Context, stop <-chan struct{}, reg registration, deliveries <-chan amqp.Delivery) {
+	var wg sync.WaitGroup
+	for i := 0; i < reg.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				// Checked first so no new message is started once stopping
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				select {
+				case <-stop:
+					return
+				case d, ok := <-deliveries:
+					if !ok {
+						return
+					}
+					w.handle(ctx, reg, d)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// handle runs the handler and settles the message according to its result
+func (w *Worker) handle(ctx context.Context, reg registration, d amqp.Delivery) {
+	err := runHandler(ctx, reg.handler, *newDelivery(d))
+
+	var settleErr error
+	switch {
+	case err == nil:
+		settleErr = d.Ack(false)
+	case errors.Is(err, ErrPermanent):
+		log.Printf("Worker rejected message %s from %s without requeue: %v", d.MessageId, reg.queue, err)
+		settleErr = d.Nack(false, false)
+	default:
+		log.Printf("Worker requeued message %s from %s (delivery count %d): %v", d.MessageId, reg.queue, deliveryCount(d.Headers), err)
+		settleErr = d.Nack(false, true)
+	}
+
+	if settleErr != nil {
+		// The channel is gone, so the broker requeues the message itself
+		log.Printf("Worker could not settle message %s from %s: %v", d.MessageId, reg.queue, settleErr)
+	}
+}
+
+// runHandler calls handler and turns a panic into a permanent error,
+// since retrying a message that crashes the handler would loop forever
+func runHandler(ctx context.Context, handler HandlerFunc, d Delivery) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = Permanent(fmt.Errorf("handler panicked: %v", p))
+		}
+	}()
+	return handler(ctx, d)
+}
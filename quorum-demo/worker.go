@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"rabbitmq-quorum-demo/rabbitmq"
+	"strings"
+)
+
+// startWorker processes the service queue in the background when concurrency is positive.
+// The returned channel is closed once the worker has stopped after ctx is canceled.
+func startWorker(ctx context.Context, rmq *rabbitmq.RabbitMQ, queueName string, concurrency, prefetch int) <-chan struct{} {
+	done := make(chan struct{})
+	if concurrency <= 0 {
+		close(done)
+		return done
+	}
+
+	worker := rabbitmq.NewWorker(rmq, rabbitmq.WorkerConfig{})
+	if err := worker.Handle(queueName, processMessage, rabbitmq.HandlerOptions{
+		Concurrency: concurrency,
+		Prefetch:    prefetch,
+	}); err != nil {
+		log.Fatalf("Failed to register worker handler: %v", err)
+	}
+
+	go func() {
+		defer close(done)
+		if err := worker.Run(ctx); err != nil {
+			log.Printf("Worker failed: %v", err)
+		}
+	}()
+	return done
+}
+
+// flakyAttempts is how many times the example handler fails a "flaky" message before processing it
+const flakyAttempts = 3
+
+// processMessage is the example handler run by the worker. Messages whose body
+// contains "flaky" fail with a retryable error until they have been redelivered
+// flakyAttempts times; x-delivery-count tracks the attempts.
+func processMessage(ctx context.Context, d rabbitmq.Delivery) error {
+	if strings.Contains(d.Body, "flaky") && d.DeliveryCount < flakyAttempts {
+		return fmt.Errorf("transient failure on attempt %d", d.DeliveryCount+1)
+	}
+	log.Printf("Worker processed message %s after %d redelivery(ies): %s", d.MessageID, d.DeliveryCount, d.Body)
+	return nil
+}
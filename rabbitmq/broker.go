@@ -0,0 +1,45 @@
+package rabbitmq
+
+import "io"
+
+// Broker is the set of queue operations the HTTP handlers depend on.
+// *RabbitMQ implements it against a real broker and *MemoryBroker in process,
+// so handlers can be exercised with httptest and no running RabbitMQ.
+type Broker interface {
+	// PublishMessage publishes a message to the queue, or to the exchange and
+	// routing key in opts, and returns its message ID.
+	// It returns ErrUnroutable when no queue receives the message.
+	PublishMessage(msg Message, opts PublishOptions) (string, error)
+
+	// PublishBatch publishes several messages and reports the outcome of each one,
+	// best-effort or all-or-nothing
+	PublishBatch(batch []BatchMessage, mode BatchMode) (*BatchResult, error)
+
+	// DeclareExchange declares an exchange of any type
+	DeclareExchange(spec ExchangeSpec) error
+
+	// Bind binds a queue or exchange to a source exchange
+	Bind(spec BindingSpec) error
+
+	// ConsumeMessage gets a message from the queue with auto-ack
+	ConsumeMessage() (*Delivery, error)
+
+	// Subscribe streams deliveries from the queue with manual acks,
+	// holding at most prefetch of them unsettled
+	Subscribe(prefetch int) (Subscription, error)
+
+	// Export writes the ready messages of a queue to w as NDJSON archive records,
+	// copying them or, in destructive mode, removing them
+	Export(w io.Writer, opts ExportOptions) (*ExportResult, error)
+
+	// Import publishes NDJSON archive records with confirms, skipping message IDs already queued
+	Import(r io.Reader, opts ImportOptions) (*ImportResult, error)
+
+	// State describes the connection to the broker
+	State() State
+}
+
+var (
+	_ Broker = (*RabbitMQ)(nil)
+	_ Broker = (*MemoryBroker)(nil)
+)
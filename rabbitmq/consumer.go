@@ -1,23 +1,78 @@
 package rabbitmq
 
 import (
+	"context"
+	"errors"
 	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-// ConsumeMessage consumes a single message from the queue
-func (r *RabbitMQ) ConsumeMessage() (string, error) {
+// ErrNoMessages is returned when the queue is empty
+var ErrNoMessages = errors.New("no messages available in queue")
+
+// ConsumeMessage consumes a single message from the queue with auto-ack
+func (r *RabbitMQ) ConsumeMessage() (*Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), borrowTimeout)
+	defer cancel()
+
+	pool, ch, err := r.borrowConsume(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.put(ch)
+
 	// Get a single message
-	msg, ok, err := r.Channel.Get(
+	msg, ok, err := ch.Get(
 		r.QueueName, // queue
 		true,        // auto-ack
 	)
 	if err != nil {
-		return "", fmt.Errorf("failed to consume message: %w", err)
+		return nil, fmt.Errorf("failed to consume message: %w", err)
 	}
 
 	if !ok {
-		return "", fmt.Errorf("no messages available in queue")
+		return nil, ErrNoMessages
+	}
+
+	return newDelivery(msg), nil
+}
+
+// startConsumer opens a dedicated channel on the consume connection, limits it to
+// prefetch unacknowledged deliveries and starts a manual-ack consumer on queue.
+// Pooled channels are not used because a consumer keeps its channel for as long as it runs.
+func (r *RabbitMQ) startConsumer(queue, consumerTag string, prefetch int) (*amqp.Channel, <-chan amqp.Delivery, error) {
+	r.mu.RLock()
+	conn, state := r.consumeConn, r.state
+	r.mu.RUnlock()
+	if state != StateConnected {
+		return nil, nil, fmt.Errorf("%w (state: %s)", ErrNotConnected, state)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open a consumer channel: %w", err)
+	}
+
+	// Limit the unacknowledged deliveries the broker pushes to this consumer
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to set prefetch: %w", err)
+	}
+
+	deliveries, err := ch.Consume(
+		queue,       // queue
+		consumerTag, // consumer
+		false,       // auto-ack
+		false,       // exclusive
+		false,       // no-local
+		false,       // no-wait
+		nil,         // args
+	)
+	if err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to start consumer: %w", err)
 	}
 
-	return string(msg.Body), nil
+	return ch, deliveries, nil
 }
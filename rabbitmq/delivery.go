@@ -0,0 +1,191 @@
+package rabbitmq
+
+import (
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Delivery is a message received from a queue: its body, its AMQP properties
+// and what the broker reports about how it was delivered
+type Delivery struct {
+	Body string `json:"body"`
+
+	ContentType     string                 `json:"content_type,omitempty"`
+	ContentEncoding string                 `json:"content_encoding,omitempty"`
+	MessageID       string                 `json:"message_id,omitempty"`
+	CorrelationID   string                 `json:"correlation_id,omitempty"`
+	ReplyTo         string                 `json:"reply_to,omitempty"`
+	Priority        int                    `json:"priority,omitempty"`
+	Expiration      string                 `json:"expiration,omitempty"`
+	Timestamp       *time.Time             `json:"timestamp,omitempty"`
+	Type            string                 `json:"type,omitempty"`
+	AppID           string                 `json:"app_id,omitempty"`
+	Headers         map[string]interface{} `json:"headers,omitempty"`
+
+	Redelivered bool   `json:"redelivered"`
+	Exchange    string `json:"exchange"`
+	RoutingKey  string `json:"routing_key"`
+
+	// DeliveryCount is how many times the message was returned to the queue
+	// before this delivery (x-delivery-count on quorum queues)
+	DeliveryCount int `json:"delivery_count"`
+
+	// Deaths is the parsed x-death header, most recent dead-lettering first
+	Deaths []DeathRecord `json:"x_death,omitempty"`
+}
+
+// DeathReason is why RabbitMQ dead-lettered a message
+type DeathReason string
+
+const (
+	DeathRejected      DeathReason = "rejected"       // nacked or rejected without requeue
+	DeathExpired       DeathReason = "expired"        // per-message or x-message-ttl TTL ran out
+	DeathMaxLen        DeathReason = "maxlen"         // dropped by x-max-length(-bytes) with overflow drop-head
+	DeathDeliveryLimit DeathReason = "delivery_limit" // redelivered more than x-delivery-limit times (quorum queues)
+)
+
+// DeathRecord is one entry of the x-death header RabbitMQ adds to a dead-lettered message
+type DeathRecord struct {
+	Reason      DeathReason `json:"reason"`
+	Queue       string      `json:"queue"`
+	Exchange    string      `json:"exchange"`
+	RoutingKeys []string    `json:"routing_keys"`
+	Count       int64       `json:"count"`
+	Time        time.Time   `json:"time"`
+}
+
+// newDelivery maps an AMQP delivery onto a Delivery
+func newDelivery(d amqp.Delivery) *Delivery {
+	delivery := &Delivery{
+		Body:            string(d.Body),
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		MessageID:       d.MessageId,
+		CorrelationID:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		Priority:        int(d.Priority),
+		Expiration:      d.Expiration,
+		Type:            d.Type,
+		AppID:           d.AppId,
+		Headers:         fromTable(d.Headers),
+		Redelivered:     d.Redelivered,
+		Exchange:        d.Exchange,
+		RoutingKey:      d.RoutingKey,
+		DeliveryCount:   deliveryCount(d.Headers),
+		Deaths:          parseDeaths(d.Headers),
+	}
+	if !d.Timestamp.IsZero() {
+		timestamp := d.Timestamp
+		delivery.Timestamp = &timestamp
+	}
+	return delivery
+}
+
+// deliveryCount reads the x-delivery-count header set by quorum queues on redelivery
+func deliveryCount(headers amqp.Table) int {
+	count, _ := toInt64(headers["x-delivery-count"])
+	return int(count)
+}
+
+// parseDeaths decodes the x-death header: an array of tables, one per queue and reason
+func parseDeaths(headers amqp.Table) []DeathRecord {
+	entries, ok := headers["x-death"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	deaths := make([]DeathRecord, 0, len(entries))
+	for _, entry := range entries {
+		table, ok := entry.(amqp.Table)
+		if !ok {
+			continue
+		}
+
+		death := DeathRecord{}
+		reason, _ := table["reason"].(string)
+		death.Reason = DeathReason(reason)
+		death.Queue, _ = table["queue"].(string)
+		death.Exchange, _ = table["exchange"].(string)
+		death.Count, _ = toInt64(table["count"])
+		death.Time, _ = table["time"].(time.Time)
+		if keys, ok := table["routing-keys"].([]interface{}); ok {
+			for _, key := range keys {
+				if s, ok := key.(string); ok {
+					death.RoutingKeys = append(death.RoutingKeys, s)
+				}
+			}
+		}
+		deaths = append(deaths, death)
+	}
+	return deaths
+}
+
+// toInt64 reads any of the integer types the AMQP decoder produces
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// fromTable converts an AMQP table, nested tables included, into plain maps for JSON
+func fromTable(table amqp.Table) map[string]interface{} {
+	if len(table) == 0 {
+		return nil
+	}
+	out := make(map[string]interface{}, len(table))
+	for k, v := range table {
+		out[k] = fromAMQPValue(v)
+	}
+	return out
+}
+
+func fromAMQPValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case amqp.Table:
+		return fromTable(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = fromAMQPValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// publishedDelivery is the delivery the broker would hand out for a publishing;
+// MemoryBroker stores messages in this form
+func publishedDelivery(p amqp.Publishing, exchange, routingKey string) amqp.Delivery {
+	return amqp.Delivery{
+		Headers:         p.Headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Timestamp:       p.Timestamp.Truncate(time.Second), // AMQP timestamps have second precision
+		Type:            p.Type,
+		AppId:           p.AppId,
+		Exchange:        exchange,
+		RoutingKey:      routingKey,
+		Body:            p.Body,
+	}
+}
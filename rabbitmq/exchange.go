@@ -0,0 +1,93 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrInvalidTopology is returned when a declaration requested at runtime fails validation
+var ErrInvalidTopology = errors.New("invalid topology")
+
+// DeclareExchange declares an exchange of any type. It becomes part of the
+// topology, so it is declared again after a reconnect.
+// A conflicting existing exchange is reported as a *TopologyError.
+func (r *RabbitMQ) DeclareExchange(spec ExchangeSpec) error {
+	return r.extendTopology(&Topology{Exchanges: []ExchangeSpec{spec}})
+}
+
+// Bind binds a queue or an exchange to a source exchange and adds the
+// binding to the topology declared after a reconnect
+func (r *RabbitMQ) Bind(spec BindingSpec) error {
+	return r.extendTopology(&Topology{Bindings: []BindingSpec{spec}})
+}
+
+// extendTopology declares extra on the broker and merges it into r.topology.
+// r.mu is held throughout so a reconnect never declares a stale topology.
+func (r *RabbitMQ) extendTopology(extra *Topology) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	merged, err := r.topology.merge(extra)
+	if err != nil {
+		return err
+	}
+
+	if r.state != StateConnected {
+		return fmt.Errorf("%w (state: %s)", ErrNotConnected, r.state)
+	}
+	if err := extra.Declare(r.publishConn); err != nil {
+		return err
+	}
+
+	r.topology = merged
+	return nil
+}
+
+// merge returns a copy of t extended with extra and checks the result.
+// An exchange with the same name replaces the existing one and an identical
+// binding is only kept once, so repeated declarations are idempotent.
+func (t *Topology) merge(extra *Topology) (*Topology, error) {
+	merged := &Topology{
+		Exchanges: append([]ExchangeSpec(nil), t.Exchanges...),
+		Queues:    append([]QueueSpec(nil), t.Queues...),
+		Bindings:  append([]BindingSpec(nil), t.Bindings...),
+	}
+
+exchanges:
+	for _, e := range extra.Exchanges {
+		for i := range merged.Exchanges {
+			if merged.Exchanges[i].Name == e.Name {
+				merged.Exchanges[i] = e
+				continue exchanges
+			}
+		}
+		merged.Exchanges = append(merged.Exchanges, e)
+	}
+
+queues:
+	for _, q := range extra.Queues {
+		for i := range merged.Queues {
+			if merged.Queues[i].Name == q.Name {
+				merged.Queues[i] = q
+				continue queues
+			}
+		}
+		merged.Queues = append(merged.Queues, q)
+	}
+
+bindings:
+	for _, b := range extra.Bindings {
+		for _, existing := range merged.Bindings {
+			if reflect.DeepEqual(existing, b) {
+				continue bindings
+			}
+		}
+		merged.Bindings = append(merged.Bindings, b)
+	}
+
+	if err := merged.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTopology, err)
+	}
+	return merged, nil
+}
@@ -0,0 +1,228 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MemoryBroker is an in-process Broker for tests and local development.
+// It models a single durable queue: messages are delivered in publish order
+// and survive Restart. Exchanges and bindings are routed like RabbitMQ does.
+type MemoryBroker struct {
+	QueueName string
+
+	mu       sync.Mutex
+	state    State
+	queue    []amqp.Delivery
+	topology *Topology
+
+	nextTag uint64
+	wake    chan struct{} // closed and replaced whenever messages become ready
+}
+
+// NewMemoryBroker creates a connected in-memory broker with an empty queue
+func NewMemoryBroker(queueName string) *MemoryBroker {
+	return &MemoryBroker{
+		QueueName: queueName,
+		state:     StateConnected,
+		topology:  DefaultTopology(queueName),
+		wake:      make(chan struct{}),
+	}
+}
+
+// SetState changes the reported connection state; any state other than
+// StateConnected makes operations fail with ErrNotConnected
+func (m *MemoryBroker) SetState(state State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = state
+}
+
+// Restart simulates a broker restart; the queue is durable, so nothing is lost
+func (m *MemoryBroker) Restart() {
+	m.SetState(StateConnected)
+}
+
+// SetQueueLimits declares limits on the queue the way Config.Limits does; publishing
+// then honours x-max-length, x-max-length-bytes and x-overflow.
+// TTL and queue expiry are declared but not simulated.
+func (m *MemoryBroker) SetQueueLimits(limits QueueLimits) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	topology, err := m.topology.WithLimits(m.QueueName, map[string]QueueLimits{"": limits})
+	if err != nil {
+		return err
+	}
+	m.topology = topology
+	return nil
+}
+
+// Messages returns the ready messages in delivery order
+func (m *MemoryBroker) Messages() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bodies := make([]string, len(m.queue))
+	for i, d := range m.queue {
+		bodies[i] = string(d.Body)
+	}
+	return bodies
+}
+
+// PublishMessage routes a message through the declared exchanges and bindings
+// and appends it to the queue when it reaches it
+func (m *MemoryBroker) PublishMessage(msg Message, opts PublishOptions) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return "", err
+	}
+
+	publishing, err := msg.publishing()
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.publish(publishing, opts); err != nil {
+		return "", err
+	}
+	return publishing.MessageId, nil
+}
+
+// PublishBatch publishes the messages one by one; in all-or-nothing mode the
+// first failure restores the queue and rolls back the rest of the batch
+func (m *MemoryBroker) PublishBatch(batch []BatchMessage, mode BatchMode) (*BatchResult, error) {
+	publishings, err := prepareBatch(batch, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+
+	result := newBatchResult(mode, publishings)
+	saved := append([]amqp.Delivery(nil), m.queue...)
+	for i, publishing := range publishings {
+		if err := m.publish(publishing, batch[i].Options); err != nil {
+			result.set(i, batchFailure(err), err)
+			if mode == BatchAllOrNothing {
+				m.queue = saved
+				result.rollBack(i, fmt.Errorf("transaction rolled back: message %d failed", i))
+				break
+			}
+			continue
+		}
+		result.set(i, BatchAcked, nil)
+	}
+	result.count()
+	return result, nil
+}
+
+// publish routes publishing and appends it to the queue; the caller must hold m.mu
+func (m *MemoryBroker) publish(publishing amqp.Publishing, opts PublishOptions) error {
+	exchange, routingKey := opts.target(m.QueueName)
+	queues, err := m.topology.route(exchange, routingKey, publishing.Headers)
+	if err != nil {
+		return err
+	}
+	if len(queues) == 0 {
+		return fmt.Errorf("%w: exchange %q, routing key %q (312 NO_ROUTE)", ErrUnroutable, exchange, routingKey)
+	}
+
+	return m.enqueue(publishedDelivery(publishing, exchange, routingKey))
+}
+
+// DeclareExchange adds an exchange to the in-memory topology
+func (m *MemoryBroker) DeclareExchange(spec ExchangeSpec) error {
+	return m.extendTopology(&Topology{Exchanges: []ExchangeSpec{spec}})
+}
+
+// Bind adds a binding to the in-memory topology
+func (m *MemoryBroker) Bind(spec BindingSpec) error {
+	return m.extendTopology(&Topology{Bindings: []BindingSpec{spec}})
+}
+
+func (m *MemoryBroker) extendTopology(extra *Topology) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return err
+	}
+
+	for _, e := range extra.Exchanges {
+		if kind, ok := m.topology.exchangeType(e.Name); ok && kind != e.Type {
+			// Same error the broker reports for an inequivalent redeclaration
+			return &TopologyError{Conflicts: []TopologyConflict{{
+				Kind:   "exchange",
+				Name:   e.Name,
+				Reason: fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg 'type' for exchange '%s': received '%s' but current is '%s'", e.Name, e.Type, kind),
+			}}}
+		}
+	}
+
+	merged, err := m.topology.merge(extra)
+	if err != nil {
+		return err
+	}
+	m.topology = merged
+	return nil
+}
+
+// ConsumeMessage removes and returns the message at the head of the queue
+func (m *MemoryBroker) ConsumeMessage() (*Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	if len(m.queue) == 0 {
+		return nil, ErrNoMessages
+	}
+	d := m.queue[0]
+	m.queue = m.queue[1:]
+	return newDelivery(d), nil
+}
+
+// State returns the simulated connection state
+func (m *MemoryBroker) State() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// checkConnected fails while the simulated connection is down; the caller must hold m.mu
+func (m *MemoryBroker) checkConnected() error {
+	if m.state != StateConnected {
+		return fmt.Errorf("%w (state: %s)", ErrNotConnected, m.state)
+	}
+	return nil
+}
+
+// enqueue appends d within the x-max-length and x-max-length-bytes of the queue:
+// reject-publish refuses the message and drop-head discards the oldest ones.
+// The caller must hold m.mu.
+func (m *MemoryBroker) enqueue(d amqp.Delivery) error {
+	limits := m.topology.QueueLimits(m.QueueName)
+	sizes := make([]int, len(m.queue))
+	for i, queued := range m.queue {
+		sizes[i] = len(queued.Body)
+	}
+
+	reject, drop := limits.overflow(sizes, len(d.Body))
+	if reject {
+		return queueFullError(m.QueueName, limits.Overflow)
+	}
+	m.queue = append(m.queue, d)[drop:]
+	m.signal()
+	return nil
+}
+
+// signal wakes the subscriptions waiting for ready messages; the caller must hold m.mu
+func (m *MemoryBroker) signal() {
+	close(m.wake)
+	m.wake = make(chan struct{})
+}
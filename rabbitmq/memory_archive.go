@@ -0,0 +1,92 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Export writes the ready messages of the queue as NDJSON archive records, like RabbitMQ.Export.
+// Copied messages stay in place but are marked redelivered, as a requeue does.
+func (m *MemoryBroker) Export(w io.Writer, opts ExportOptions) (*ExportResult, error) {
+	opts, err := opts.withDefaults(m.QueueName)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	if opts.Queue != m.QueueName {
+		return nil, fmt.Errorf("%w: %s", ErrQueueNotFound, opts.Queue)
+	}
+
+	n := len(m.queue)
+	if opts.Limit > 0 && opts.Limit < n {
+		n = opts.Limit
+	}
+	encoder := json.NewEncoder(w)
+	for i := 0; i < n; i++ {
+		if err := encoder.Encode(newArchiveRecord(m.queue[i], opts.Queue)); err != nil {
+			return nil, fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+
+	if opts.Mode == ExportDestructive {
+		m.queue = m.queue[n:]
+	} else {
+		for i := 0; i < n; i++ {
+			m.queue[i].Redelivered = true
+		}
+	}
+	return &ExportResult{Queue: opts.Queue, Mode: opts.Mode, Exported: n}, nil
+}
+
+// Import appends the records of an NDJSON archive, skipping duplicates like RabbitMQ.Import
+func (m *MemoryBroker) Import(src io.Reader, opts ImportOptions) (*ImportResult, error) {
+	return importArchive(src, opts, m.messageIDs, m.importPublishing)
+}
+
+// messageIDs lists the message IDs of up to scan ready messages of queue, which
+// are marked redelivered, as the requeue of RabbitMQ.Import's scan does
+func (m *MemoryBroker) messageIDs(queue string, scan int) (map[string]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+	if queue != m.QueueName {
+		return nil, fmt.Errorf("%w: %s", ErrQueueNotFound, queue)
+	}
+
+	n := len(m.queue)
+	if scan < n {
+		n = scan
+	}
+	ids := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		m.queue[i].Redelivered = true
+		if id := m.queue[i].MessageId; id != "" {
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
+
+// importPublishing appends p to queue, within its length limits, as if published through the default exchange
+func (m *MemoryBroker) importPublishing(p amqp.Publishing, queue string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return err
+	}
+	if queue != m.QueueName {
+		return fmt.Errorf("%w: exchange %q, routing key %q (312 NO_ROUTE)", ErrUnroutable, "", queue)
+	}
+
+	return m.enqueue(publishedDelivery(p, "", queue))
+}
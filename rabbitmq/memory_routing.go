@@ -0,0 +1,145 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// builtinExchanges are the amq.* exchanges every RabbitMQ vhost has
+var builtinExchanges = map[string]string{
+	"amq.direct":  amqp.ExchangeDirect,
+	"amq.fanout":  amqp.ExchangeFanout,
+	"amq.topic":   amqp.ExchangeTopic,
+	"amq.headers": amqp.ExchangeHeaders,
+	"amq.match":   amqp.ExchangeHeaders,
+}
+
+// route resolves the queues a message reaches, following the exchange type
+// semantics of RabbitMQ, exchange-to-exchange bindings and alternate exchanges.
+// MemoryBroker uses it in place of the broker's routing.
+func (t *Topology) route(exchange, routingKey string, headers map[string]interface{}) ([]string, error) {
+	if exchange == "" {
+		// The default exchange routes to the queue named by the routing key
+		if t.HasQueue(routingKey) {
+			return []string{routingKey}, nil
+		}
+		return nil, nil
+	}
+
+	if _, ok := t.exchangeType(exchange); !ok {
+		return nil, fmt.Errorf("%w: %q", ErrExchangeNotFound, exchange)
+	}
+
+	queues := make(map[string]bool)
+	t.routeFrom(exchange, routingKey, headers, make(map[string]bool), queues)
+
+	var routed []string
+	for _, q := range t.Queues {
+		if queues[q.Name] {
+			routed = append(routed, q.Name)
+		}
+	}
+	return routed, nil
+}
+
+func (t *Topology) routeFrom(exchange, routingKey string, headers map[string]interface{}, visited, queues map[string]bool) {
+	if visited[exchange] {
+		return
+	}
+	visited[exchange] = true
+
+	kind, _ := t.exchangeType(exchange)
+	matched := false
+	for _, b := range t.Bindings {
+		if b.Source != exchange || !bindingMatches(kind, b, routingKey, headers) {
+			continue
+		}
+		matched = true
+		if b.DestinationType == "exchange" {
+			t.routeFrom(b.Destination, routingKey, headers, visited, queues)
+		} else {
+			queues[b.Destination] = true
+		}
+	}
+
+	if !matched {
+		for _, e := range t.Exchanges {
+			if ae, ok := e.Arguments["alternate-exchange"].(string); ok && e.Name == exchange {
+				t.routeFrom(ae, routingKey, headers, visited, queues)
+			}
+		}
+	}
+}
+
+// exchangeType returns the type of a declared or built-in exchange
+func (t *Topology) exchangeType(name string) (string, bool) {
+	for _, e := range t.Exchanges {
+		if e.Name == name {
+			return e.Type, true
+		}
+	}
+	kind, ok := builtinExchanges[name]
+	return kind, ok
+}
+
+func bindingMatches(kind string, b BindingSpec, routingKey string, headers map[string]interface{}) bool {
+	switch kind {
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeTopic:
+		return topicMatches(strings.Split(b.RoutingKey, "."), strings.Split(routingKey, "."))
+	case amqp.ExchangeHeaders:
+		return headersMatch(b.Arguments, headers)
+	default:
+		return b.RoutingKey == routingKey
+	}
+}
+
+// topicMatches matches routing key words against a pattern where
+// "*" stands for exactly one word and "#" for zero or more words
+func topicMatches(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatches(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatches(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && topicMatches(pattern[1:], words[1:])
+	}
+}
+
+// headersMatch applies the x-match rule ("all" by default, or "any") of a headers binding
+func headersMatch(args, headers map[string]interface{}) bool {
+	matchAny := false
+	if mode, ok := args["x-match"].(string); ok {
+		matchAny = strings.HasPrefix(mode, "any")
+	}
+
+	matches, total := 0, 0
+	for k, want := range args {
+		if strings.HasPrefix(k, "x-") {
+			continue
+		}
+		total++
+		got, ok := headers[k]
+		if ok && reflect.DeepEqual(toAMQPValue(got), toAMQPValue(want)) {
+			matches++
+		}
+	}
+
+	if matchAny {
+		return matches > 0
+	}
+	return matches == total
+}
@@ -0,0 +1,134 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Subscribe streams the queue, keeping at most prefetch deliveries unsettled
+func (m *MemoryBroker) Subscribe(prefetch int) (Subscription, error) {
+	if prefetch <= 0 {
+		prefetch = DefaultPrefetch
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkConnected(); err != nil {
+		return nil, err
+	}
+
+	s := &memorySubscription{
+		m:        m,
+		prefetch: prefetch,
+		// Never more than prefetch deliveries are in flight, so sends never block
+		out:     make(chan *StreamDelivery, prefetch),
+		pending: make(map[uint64]amqp.Delivery),
+		settled: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+
+	return s, nil
+}
+
+// memorySubscription is a Subscription on a MemoryBroker
+type memorySubscription struct {
+	m        *MemoryBroker
+	prefetch int
+	out      chan *StreamDelivery
+	pending  map[uint64]amqp.Delivery // guarded by m.mu
+	settled  chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// run pushes ready messages while there is room in the prefetch window
+func (s *memorySubscription) run() {
+	defer close(s.out)
+	for {
+		s.m.mu.Lock()
+		for len(s.pending) < s.prefetch && len(s.m.queue) > 0 {
+			d := s.m.queue[0]
+			s.m.queue = s.m.queue[1:]
+			s.m.nextTag++
+			s.pending[s.m.nextTag] = d
+			s.out <- &StreamDelivery{Delivery: *newDelivery(d), DeliveryTag: s.m.nextTag}
+		}
+		wake := s.m.wake
+		s.m.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-s.settled:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *memorySubscription) Deliveries() <-chan *StreamDelivery {
+	return s.out
+}
+
+func (s *memorySubscription) Ack(tag uint64) error {
+	_, err := s.settle(tag)
+	return err
+}
+
+func (s *memorySubscription) Nack(tag uint64, requeue bool) error {
+	d, err := s.settle(tag)
+	if err != nil || !requeue {
+		return err
+	}
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.requeue(d)
+	return nil
+}
+
+// settle removes tag from the pending set and makes room for the next delivery
+func (s *memorySubscription) settle(tag uint64) (amqp.Delivery, error) {
+	s.m.mu.Lock()
+	d, ok := s.pending[tag]
+	delete(s.pending, tag)
+	s.m.mu.Unlock()
+	if !ok {
+		return amqp.Delivery{}, fmt.Errorf("%w: %d", ErrUnknownDeliveryTag, tag)
+	}
+
+	select {
+	case s.settled <- struct{}{}:
+	default:
+	}
+	return d, nil
+}
+
+func (s *memorySubscription) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		s.m.mu.Lock()
+		defer s.m.mu.Unlock()
+		tags := make(map[uint64]bool, len(s.pending))
+		for tag := range s.pending {
+			tags[tag] = true
+		}
+		// Requeue in reverse so the oldest delivery ends up at the head
+		ordered := pendingTags(tags)
+		for i := len(ordered) - 1; i >= 0; i-- {
+			s.m.requeue(s.pending[ordered[i]])
+		}
+		s.pending = make(map[uint64]amqp.Delivery)
+	})
+	return nil
+}
+
+// requeue puts a returned message back at the head of the queue; the caller must hold m.mu
+func (m *MemoryBroker) requeue(d amqp.Delivery) {
+	d.Redelivered = true
+	m.queue = append([]amqp.Delivery{d}, m.queue...)
+	m.signal()
+}
@@ -0,0 +1,108 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DefaultPoolSize is the number of channels opened per pool when Config.PoolSize is not set
+const DefaultPoolSize = 4
+
+// borrowTimeout bounds how long an operation waits for a free pooled channel
+const borrowTimeout = 5 * time.Second
+
+// channelPool hands out AMQP channels opened on a single connection.
+// A borrowed channel belongs to one goroutine until it is returned with put,
+// so frames, delivery tags and confirms of concurrent requests never mix.
+type channelPool struct {
+	name string
+	conn *amqp.Connection
+	init func(*amqp.Channel) error
+
+	// slots holds one entry per pool slot; a nil entry is a free slot
+	// whose channel still has to be opened
+	slots chan *amqp.Channel
+}
+
+// newChannelPool opens size channels on conn, running init on each of them
+func newChannelPool(name string, conn *amqp.Connection, size int, init func(*amqp.Channel) error) (*channelPool, error) {
+	if size <= 0 {
+		size = DefaultPoolSize
+	}
+
+	p := &channelPool{
+		name:  name,
+		conn:  conn,
+		init:  init,
+		slots: make(chan *amqp.Channel, size),
+	}
+
+	for i := 0; i < size; i++ {
+		ch, err := p.open()
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		p.slots <- ch
+	}
+
+	return p, nil
+}
+
+// open creates a new channel and prepares it with the pool's init function
+func (p *channelPool) open() (*amqp.Channel, error) {
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a %s channel: %w", p.name, err)
+	}
+	if p.init != nil {
+		if err := p.init(ch); err != nil {
+			ch.Close()
+			return nil, fmt.Errorf("failed to prepare a %s channel: %w", p.name, err)
+		}
+	}
+	return ch, nil
+}
+
+// get borrows a channel, waiting until one is free or ctx is done.
+// Channels closed by the broker (for example after a channel-level error)
+// are replaced by a fresh one before being handed out.
+func (p *channelPool) get(ctx context.Context) (*amqp.Channel, error) {
+	select {
+	case ch := <-p.slots:
+		if ch == nil || ch.IsClosed() {
+			fresh, err := p.open()
+			if err != nil {
+				p.slots <- nil
+				return nil, err
+			}
+			ch = fresh
+		}
+		return ch, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out waiting for a %s channel: %w", p.name, ctx.Err())
+	}
+}
+
+// put returns a borrowed channel to the pool
+func (p *channelPool) put(ch *amqp.Channel) {
+	p.slots <- ch
+}
+
+// close closes the channels currently sitting in the pool.
+// Borrowed channels are closed together with their connection.
+func (p *channelPool) close() {
+	for {
+		select {
+		case ch := <-p.slots:
+			if ch != nil {
+				ch.Close()
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DefaultPrefetch is how many unacknowledged deliveries a subscription holds when no prefetch is given
+const DefaultPrefetch = 10
+
+// ErrUnknownDeliveryTag is returned when settling a delivery the subscription does not hold
+var ErrUnknownDeliveryTag = errors.New("unknown delivery tag")
+
+// StreamDelivery is a delivery pushed by a Subscription; settle it with Ack or Nack
+type StreamDelivery struct {
+	Delivery
+	DeliveryTag uint64 `json:"delivery_tag"`
+}
+
+// Subscription streams deliveries from the queue. At most prefetch deliveries
+// are unsettled at a time; more are pushed as they are acked or nacked.
+type Subscription interface {
+	// Deliveries is closed when the subscription is closed or its channel is lost
+	Deliveries() <-chan *StreamDelivery
+
+	Ack(tag uint64) error
+	Nack(tag uint64, requeue bool) error
+
+	// Close stops consuming and nacks every unsettled delivery with requeue
+	Close() error
+}
+
+// Subscribe starts a consumer with the given prefetch (Qos) on a dedicated channel
+// of the consume connection
+func (r *RabbitMQ) Subscribe(prefetch int) (Subscription, error) {
+	if prefetch <= 0 {
+		prefetch = DefaultPrefetch
+	}
+
+	consumerTag := "stream-" + newMessageID()
+	ch, deliveries, err := r.startConsumer(r.QueueName, consumerTag, prefetch)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &channelSubscription{
+		ch:          ch,
+		consumerTag: consumerTag,
+		out:         make(chan *StreamDelivery),
+		pending:     make(map[uint64]bool),
+		done:        make(chan struct{}),
+	}
+	go s.forward(deliveries)
+
+	return s, nil
+}
+
+// channelSubscription is a Subscription backed by Channel.Consume
+type channelSubscription struct {
+	ch          *amqp.Channel
+	consumerTag string
+	out         chan *StreamDelivery
+
+	mu      sync.Mutex
+	pending map[uint64]bool // delivered but not yet settled
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *channelSubscription) forward(deliveries <-chan amqp.Delivery) {
+	defer close(s.out)
+	for d := range deliveries {
+		s.mu.Lock()
+		s.pending[d.DeliveryTag] = true
+		s.mu.Unlock()
+
+		select {
+		case s.out <- &StreamDelivery{Delivery: *newDelivery(d), DeliveryTag: d.DeliveryTag}:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *channelSubscription) Deliveries() <-chan *StreamDelivery {
+	return s.out
+}
+
+func (s *channelSubscription) Ack(tag uint64) error {
+	if !s.settle(tag) {
+		return fmt.Errorf("%w: %d", ErrUnknownDeliveryTag, tag)
+	}
+	if err := s.ch.Ack(tag, false); err != nil {
+		return fmt.Errorf("failed to ack message: %w", err)
+	}
+	return nil
+}
+
+func (s *channelSubscription) Nack(tag uint64, requeue bool) error {
+	if !s.settle(tag) {
+		return fmt.Errorf("%w: %d", ErrUnknownDeliveryTag, tag)
+	}
+	if err := s.ch.Nack(tag, false, requeue); err != nil {
+		return fmt.Errorf("failed to nack message: %w", err)
+	}
+	return nil
+}
+
+// settle removes tag from the pending set and reports whether it was there
+func (s *channelSubscription) settle(tag uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.pending[tag] {
+		return false
+	}
+	delete(s.pending, tag)
+	return true
+}
+
+func (s *channelSubscription) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		// Stop the broker from pushing more, then hand back what the client never confirmed.
+		// Deliveries still buffered in the client library are requeued when the channel closes.
+		if cancelErr := s.ch.Cancel(s.consumerTag, false); cancelErr != nil && !s.ch.IsClosed() {
+			err = fmt.Errorf("failed to cancel consumer: %w", cancelErr)
+		}
+
+		s.mu.Lock()
+		tags := pendingTags(s.pending)
+		s.pending = make(map[uint64]bool)
+		s.mu.Unlock()
+
+		for _, tag := range tags {
+			if nackErr := s.ch.Nack(tag, false, true); nackErr != nil {
+				break // channel gone: the broker requeues them anyway
+			}
+		}
+
+		if !s.ch.IsClosed() {
+			s.ch.Close()
+		}
+	})
+	return err
+}
+
+// pendingTags returns the tags of a pending set in delivery order
+func pendingTags(pending map[uint64]bool) []uint64 {
+	tags := make([]uint64, 0, len(pending))
+	for tag := range pending {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	return tags
+}
@@ -0,0 +1,367 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Topology lists the exchanges, queues and bindings a service declares at startup.
+// The JSON layout follows the RabbitMQ definitions export, so a trimmed
+// export from the management UI can be used as a topology file.
+type Topology struct {
+	Exchanges []ExchangeSpec `json:"exchanges"`
+	Queues    []QueueSpec    `json:"queues"`
+	Bindings  []BindingSpec  `json:"bindings"`
+}
+
+// ExchangeSpec describes an exchange to declare
+type ExchangeSpec struct {
+	Name       string                 `json:"name"`
+	Type       string                 `json:"type"` // direct, fanout, topic or headers
+	Durable    *bool                  `json:"durable,omitempty"`
+	AutoDelete bool                   `json:"auto_delete,omitempty"`
+	Internal   bool                   `json:"internal,omitempty"`
+	Arguments  map[string]interface{} `json:"arguments,omitempty"`
+}
+
+// QueueSpec describes a queue to declare.
+// Arguments carry x-queue-type, x-dead-letter-exchange, x-message-ttl, x-max-length and so on.
+type QueueSpec struct {
+	Name       string                 `json:"name"`
+	Durable    *bool                  `json:"durable,omitempty"`
+	AutoDelete bool                   `json:"auto_delete,omitempty"`
+	Arguments  map[string]interface{} `json:"arguments,omitempty"`
+}
+
+// BindingSpec binds a queue (or another exchange) to a source exchange
+type BindingSpec struct {
+	Source          string                 `json:"source"`
+	Destination     string                 `json:"destination"`
+	DestinationType string                 `json:"destination_type,omitempty"` // "queue" (default) or "exchange"
+	RoutingKey      string                 `json:"routing_key,omitempty"`
+	Arguments       map[string]interface{} `json:"arguments,omitempty"`
+}
+
+// durable defaults to true when the field is left out of the file
+func durable(flag *bool) bool {
+	return flag == nil || *flag
+}
+
+// LoadTopology reads and validates a JSON topology file
+func LoadTopology(path string) (*Topology, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read topology file: %w", err)
+	}
+
+	// Keep numbers exact so integer arguments such as x-message-ttl stay integers
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+
+	var t Topology
+	if err := dec.Decode(&t); err != nil {
+		return nil, fmt.Errorf("failed to parse topology file %s: %w", path, err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid topology file %s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// Validate checks names, exchange types and that every reference points to a declared entity
+func (t *Topology) Validate() error {
+	var problems []string
+
+	exchanges := make(map[string]bool)
+	for _, e := range t.Exchanges {
+		switch {
+		case e.Name == "":
+			problems = append(problems, "exchange without a name")
+		case exchanges[e.Name]:
+			problems = append(problems, fmt.Sprintf("exchange %q declared twice", e.Name))
+		case strings.HasPrefix(e.Name, "amq."):
+			problems = append(problems, fmt.Sprintf("exchange %q uses the reserved amq. prefix", e.Name))
+		}
+		switch e.Type {
+		case amqp.ExchangeDirect, amqp.ExchangeFanout, amqp.ExchangeTopic, amqp.ExchangeHeaders:
+		default:
+			problems = append(problems, fmt.Sprintf("exchange %q has unsupported type %q", e.Name, e.Type))
+		}
+		exchanges[e.Name] = true
+	}
+
+	queues := make(map[string]bool)
+	for _, q := range t.Queues {
+		switch {
+		case q.Name == "":
+			problems = append(problems, "queue without a name")
+		case queues[q.Name]:
+			problems = append(problems, fmt.Sprintf("queue %q declared twice", q.Name))
+		}
+		queues[q.Name] = true
+		problems = append(problems, queueArgumentProblems(q)...)
+	}
+
+	knownExchange := func(name string) bool {
+		return exchanges[name] || strings.HasPrefix(name, "amq.")
+	}
+
+	for _, e := range t.Exchanges {
+		if ae, ok := e.Arguments["alternate-exchange"].(string); ok && !knownExchange(ae) {
+			problems = append(problems, fmt.Sprintf("exchange %q uses undeclared alternate-exchange %q", e.Name, ae))
+		}
+	}
+	for _, q := range t.Queues {
+		if dlx, ok := q.Arguments["x-dead-letter-exchange"].(string); ok && dlx != "" && !knownExchange(dlx) {
+			problems = append(problems, fmt.Sprintf("queue %q uses undeclared x-dead-letter-exchange %q", q.Name, dlx))
+		}
+	}
+	for _, b := range t.Bindings {
+		if !knownExchange(b.Source) {
+			problems = append(problems, fmt.Sprintf("binding from undeclared exchange %q", b.Source))
+		}
+		switch b.DestinationType {
+		case "", "queue":
+			if !queues[b.Destination] {
+				problems = append(problems, fmt.Sprintf("binding to undeclared queue %q", b.Destination))
+			}
+		case "exchange":
+			if !knownExchange(b.Destination) {
+				problems = append(problems, fmt.Sprintf("binding to undeclared exchange %q", b.Destination))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("binding to %q has unsupported destination_type %q", b.Destination, b.DestinationType))
+		}
+	}
+
+	if _, err := t.orderedExchanges(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// HasQueue reports whether the topology declares the named queue
+func (t *Topology) HasQueue(name string) bool {
+	for _, q := range t.Queues {
+		if q.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// orderedExchanges sorts exchanges so an alternate-exchange is declared before the exchanges using it
+func (t *Topology) orderedExchanges() ([]ExchangeSpec, error) {
+	byName := make(map[string]ExchangeSpec, len(t.Exchanges))
+	for _, e := range t.Exchanges {
+		byName[e.Name] = e
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	marks := make(map[string]int)
+	ordered := make([]ExchangeSpec, 0, len(t.Exchanges))
+
+	var visit func(e ExchangeSpec) error
+	visit = func(e ExchangeSpec) error {
+		switch marks[e.Name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("alternate-exchange cycle through exchange %q", e.Name)
+		}
+		marks[e.Name] = visiting
+		if ae, ok := e.Arguments["alternate-exchange"].(string); ok {
+			if dep, ok := byName[ae]; ok {
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+		marks[e.Name] = done
+		ordered = append(ordered, e)
+		return nil
+	}
+
+	for _, e := range t.Exchanges {
+		if err := visit(e); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// TopologyConflict is a declaration the broker refused because the entity
+// already exists with different settings
+type TopologyConflict struct {
+	Kind   string // "exchange", "queue" or "binding"
+	Name   string
+	Reason string
+}
+
+// TopologyError reports every conflicting declaration found at startup
+type TopologyError struct {
+	Conflicts []TopologyConflict
+}
+
+func (e *TopologyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "topology conflicts with the existing broker state (%d PRECONDITION_FAILED):", len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		fmt.Fprintf(&b, "\n  - %s %q: %s", c.Kind, c.Name, c.Reason)
+	}
+	b.WriteString("\n  delete or migrate the existing entities, or align the topology file with them")
+	return b.String()
+}
+
+// Declare declares exchanges, then queues, then bindings on conn.
+// A PRECONDITION_FAILED closes the channel, so Declare opens a new one and keeps
+// going in order to report every conflict at once in a *TopologyError.
+func (t *Topology) Declare(conn *amqp.Connection) error {
+	exchanges, err := t.orderedExchanges()
+	if err != nil {
+		return err
+	}
+
+	d := &declarer{conn: conn}
+	defer d.close()
+
+	for _, e := range exchanges {
+		err := d.do("exchange", e.Name, func(ch *amqp.Channel) error {
+			return ch.ExchangeDeclare(e.Name, e.Type, durable(e.Durable), e.AutoDelete, e.Internal, false, toTable(e.Arguments))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, q := range t.Queues {
+		err := d.do("queue", q.Name, func(ch *amqp.Channel) error {
+			_, err := ch.QueueDeclare(q.Name, durable(q.Durable), q.AutoDelete, false, false, toTable(q.Arguments))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, b := range t.Bindings {
+		name := fmt.Sprintf("%s -> %s (%s)", b.Source, b.Destination, b.RoutingKey)
+		err := d.do("binding", name, func(ch *amqp.Channel) error {
+			if b.DestinationType == "exchange" {
+				return ch.ExchangeBind(b.Destination, b.RoutingKey, b.Source, false, toTable(b.Arguments))
+			}
+			return ch.QueueBind(b.Destination, b.RoutingKey, b.Source, false, toTable(b.Arguments))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(d.conflicts) > 0 {
+		return &TopologyError{Conflicts: d.conflicts}
+	}
+
+	log.Printf("Declared topology: %d exchange(s), %d queue(s), %d binding(s)",
+		len(t.Exchanges), len(t.Queues), len(t.Bindings))
+	return nil
+}
+
+// declarer runs declarations on a channel, replacing it after a PRECONDITION_FAILED
+type declarer struct {
+	conn      *amqp.Connection
+	ch        *amqp.Channel
+	conflicts []TopologyConflict
+}
+
+// do runs one declaration; conflicts are collected, any other error is returned
+func (d *declarer) do(kind, name string, declare func(ch *amqp.Channel) error) error {
+	if d.ch == nil || d.ch.IsClosed() {
+		ch, err := d.conn.Channel()
+		if err != nil {
+			return fmt.Errorf("failed to open a channel: %w", err)
+		}
+		d.ch = ch
+	}
+
+	err := declare(d.ch)
+	if err == nil {
+		return nil
+	}
+
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		d.conflicts = append(d.conflicts, TopologyConflict{Kind: kind, Name: name, Reason: amqpErr.Reason})
+		return nil
+	}
+	return fmt.Errorf("failed to declare %s %q: %w", kind, name, err)
+}
+
+func (d *declarer) close() {
+	if d.ch != nil && !d.ch.IsClosed() {
+		d.ch.Close()
+	}
+}
+
+// toTable converts decoded JSON arguments to an AMQP table:
+// integral numbers become int64, as the broker expects for TTLs and lengths
+func toTable(args map[string]interface{}) amqp.Table {
+	if len(args) == 0 {
+		return nil
+	}
+	table := make(amqp.Table, len(args))
+	for k, v := range args {
+		table[k] = toAMQPValue(v)
+	}
+	return table
+}
+
+func toAMQPValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v)
+		}
+		return v
+	case int:
+		return int64(v)
+	case map[string]interface{}:
+		return toTable(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = toAMQPValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// DefaultTopology is used when no topology file is configured:
+// a single durable queue published to through the default exchange
+func DefaultTopology(queueName string) *Topology {
+	return &Topology{
+		Queues: []QueueSpec{{Name: queueName}},
+	}
+}
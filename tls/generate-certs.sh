@@ -0,0 +1,45 @@
+#!/bin/bash
+# Generates a private CA plus server and client certificates for local TLS testing.
+# Usage: ./tls/generate-certs.sh [output-dir]   (default: tls/certs)
+
+set -e
+
+OUT_DIR="${1:-$(dirname "$0")/certs}"
+SERVER_CN="${SERVER_CN:-localhost}"
+DAYS=365
+
+mkdir -p "$OUT_DIR"
+cd "$OUT_DIR"
+
+# Certificate authority
+openssl req -x509 -newkey rsa:2048 -nodes -days $DAYS \
+    -keyout ca_key.pem -out ca_certificate.pem \
+    -subj "/CN=rabbitmq-examples-ca"
+
+# Broker certificate, valid for localhost and the docker-compose host names
+openssl req -newkey rsa:2048 -nodes \
+    -keyout server_key.pem -out server.csr \
+    -subj "/CN=$SERVER_CN"
+cat > server_ext.cnf <<EXT
+subjectAltName = DNS:$SERVER_CN, DNS:localhost, DNS:rabbitmq, DNS:rabbitmq-node1, DNS:rabbitmq-node2, DNS:rabbitmq-node3, IP:127.0.0.1
+extendedKeyUsage = serverAuth
+EXT
+openssl x509 -req -in server.csr -days $DAYS \
+    -CA ca_certificate.pem -CAkey ca_key.pem -CAcreateserial \
+    -extfile server_ext.cnf -out server_certificate.pem
+
+# Client certificate for mutual TLS
+openssl req -newkey rsa:2048 -nodes \
+    -keyout client_key.pem -out client.csr \
+    -subj "/CN=rabbitmq-examples-client"
+echo "extendedKeyUsage = clientAuth" > client_ext.cnf
+openssl x509 -req -in client.csr -days $DAYS \
+    -CA ca_certificate.pem -CAkey ca_key.pem -CAcreateserial \
+    -extfile client_ext.cnf -out client_certificate.pem
+
+rm -f server.csr client.csr server_ext.cnf client_ext.cnf ca_certificate.srl
+
+# The broker container runs as the rabbitmq user and must be able to read the keys
+chmod 644 ./*.pem
+
+echo "Certificates written to $OUT_DIR"